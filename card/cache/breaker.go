@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// breaker stops calling Redis after threshold consecutive failures. Once
+// open, it lets one call through every cooldown to find out whether Redis is
+// back.
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openedAt  time.Time
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown}
+}
+
+func (b *breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return true
+	}
+	if time.Since(b.openedAt) < b.cooldown {
+		return false
+	}
+	// the probe restarts the cooldown, so only one call goes through
+	b.openedAt = time.Now()
+	return true
+}
+
+func (b *breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+}
+
+func (b *breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.failures == b.threshold {
+		b.openedAt = time.Now()
+	}
+}
+
+// Trip opens the breaker right away, as if threshold calls had failed.
+func (b *breaker) Trip() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.threshold <= 0 {
+		return
+	}
+	b.failures = b.threshold
+	b.openedAt = time.Now()
+}
+
+func (b *breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return BreakerClosed
+	}
+	if time.Since(b.openedAt) < b.cooldown {
+		return BreakerOpen
+	}
+	return BreakerHalfOpen
+}
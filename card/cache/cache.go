@@ -0,0 +1,500 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rakateja/milo/twirp-rpc-examples/card/config"
+	redis "github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+)
+
+// notFound is cached in place of an entity that couldn't be found. It's
+// neither JSON nor an ID, so it can't be mistaken for either.
+const notFound = "!"
+
+// ErrNotFound is returned by Fetch for keys cached as missing. A load
+// returns it when the entity doesn't exist, so the miss is cached.
+var ErrNotFound = errors.New("cache: not found")
+
+// Options configures the Redis caches in front of the SQL repositories.
+type Options struct {
+	// Version prefixes every key. Bump it when the JSON shape of a cached
+	// entity changes, so a deploy doesn't read blobs written by the previous
+	// one.
+	Version string
+	TTL     time.Duration
+	// Jitter is the most that's added at random to TTL, so entries cached at
+	// the same time don't all expire at the same time.
+	Jitter time.Duration
+	// NegativeTTL is how long an entity that couldn't be found is remembered.
+	NegativeTTL time.Duration
+	// LocalSize is the number of entries each cache keeps in process, zero
+	// turns the in-process tier off.
+	LocalSize int
+	// LocalTTL bounds how long an in-process entry can outlive a write whose
+	// invalidation it missed.
+	LocalTTL time.Duration
+	// BreakerThreshold is the number of consecutive Redis errors after which
+	// the cache is bypassed for BreakerCooldown, zero never bypasses it.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// RetryInterval is how often invalidations that failed are retried.
+	RetryInterval time.Duration
+	// MaxPending bounds the invalidations waiting for a retry. Past it they're
+	// dropped and Redis isn't read until every entry cached before has
+	// expired.
+	MaxPending int
+	// HashTag puts the entity ID of each key in a hash tag, so the keys of an
+	// entity share a cluster slot while the keys of a cache spread over every
+	// shard. Multi-key reads and deletes are then sent one key per command in
+	// a pipeline, which the cluster client routes to each key's shard, instead
+	// of failing with CROSSSLOT.
+	HashTag bool
+}
+
+func NewOptions(conf config.Config) Options {
+	return Options{
+		Version:     conf.RedisCacheVersion,
+		TTL:         conf.RedisCacheTTL,
+		Jitter:      conf.RedisCacheTTLJitter,
+		NegativeTTL: conf.RedisNegativeCacheTTL,
+		LocalSize:   conf.LocalCacheSize,
+		LocalTTL:    conf.LocalCacheTTL,
+
+		BreakerThreshold: conf.RedisBreakerThreshold,
+		BreakerCooldown:  conf.RedisBreakerCooldown,
+		RetryInterval:    conf.RedisRetryInterval,
+		MaxPending:       conf.RedisMaxPendingInvalidations,
+		HashTag:          conf.RedisMode == config.RedisCluster,
+	}
+}
+
+// Stats counts the hits and misses of each tier. A local miss goes on to
+// Redis, a Redis miss to the SQL repository.
+type Stats struct {
+	LocalHits   int64 `json:"local_hits"`
+	LocalMisses int64 `json:"local_misses"`
+	RedisHits   int64 `json:"redis_hits"`
+	RedisMisses int64 `json:"redis_misses"`
+	RedisErrors int64 `json:"redis_errors"`
+}
+
+// Health tells whether the cache is degraded: Redis is failing, an
+// invalidation is waiting for a retry, or Redis isn't read at all. Reads go to
+// the SQL repositories for as long as it lasts.
+type Health struct {
+	Breaker              string     `json:"breaker"`
+	PendingInvalidations int        `json:"pending_invalidations"`
+	BypassedUntil        *time.Time `json:"bypassed_until,omitempty"`
+}
+
+func (h Health) Degraded() bool {
+	return h.Breaker != BreakerClosed || h.PendingInvalidations > 0 || h.BypassedUntil != nil
+}
+
+// Cache is a two-tier cache: an in-process LRU in front of Redis. Writes on
+// any replica invalidate the in-process tier of all of them over Redis
+// pub/sub. A missing key is loaded once, however many callers miss it at the
+// same time.
+//
+// Redis errors never fail a call: reads fall back to load and invalidations
+// that fail are retried in the background, Redis isn't read for their keys
+// until they succeed.
+type Cache struct {
+	// stats comes first to keep its counters aligned for atomic access
+	stats   Stats
+	name    string
+	client  redis.UniversalClient
+	options Options
+	group   singleflight.Group
+	local   *lru
+	breaker *breaker
+
+	mu            sync.Mutex
+	pending       map[string]bool
+	bypassedUntil time.Time
+}
+
+// New starts listening to invalidations right away when the in-process tier
+// is on, and retrying the failed ones.
+func New(name string, client redis.UniversalClient, options Options) *Cache {
+	c := &Cache{
+		name:    name,
+		client:  client,
+		options: options,
+		breaker: newBreaker(options.BreakerThreshold, options.BreakerCooldown),
+		pending: make(map[string]bool),
+	}
+	if options.LocalSize > 0 {
+		c.local = newLRU(options.LocalSize)
+		go c.listen()
+	}
+	if options.RetryInterval > 0 {
+		go c.retry()
+	}
+	return c
+}
+
+func (c *Cache) Name() string {
+	return c.name
+}
+
+func (c *Cache) Stats() Stats {
+	return Stats{
+		LocalHits:   atomic.LoadInt64(&c.stats.LocalHits),
+		LocalMisses: atomic.LoadInt64(&c.stats.LocalMisses),
+		RedisHits:   atomic.LoadInt64(&c.stats.RedisHits),
+		RedisMisses: atomic.LoadInt64(&c.stats.RedisMisses),
+		RedisErrors: atomic.LoadInt64(&c.stats.RedisErrors),
+	}
+}
+
+func (c *Cache) Health() Health {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := Health{Breaker: c.breaker.State(), PendingInvalidations: len(c.pending)}
+	if time.Now().Before(c.bypassedUntil) {
+		bypassedUntil := c.bypassedUntil
+		res.BypassedUntil = &bypassedUntil
+	}
+	return res
+}
+
+// Key formats a key under the version prefix. With HashTag the first arg,
+// the entity ID, is the hash tag of the key.
+func (c *Cache) Key(format string, args ...interface{}) string {
+	if c.options.HashTag && len(args) > 0 {
+		tagged := make([]interface{}, len(args))
+		copy(tagged, args)
+		tagged[0] = "{" + fmt.Sprint(args[0]) + "}"
+		args = tagged
+	}
+	return c.options.Version + ":" + fmt.Sprintf(format, args...)
+}
+
+// Trip bypasses Redis until the breaker lets a call through to find out it's
+// back, e.g. when it can't be reached at startup.
+func (c *Cache) Trip(err error) {
+	c.breaker.Trip()
+	log.Printf("[ERROR] %s cache bypasses Redis: %v", c.name, err)
+}
+
+// Fetch returns the value cached at key. On a miss it caches what load
+// returns, calling it once for all the callers missing the key at the same
+// time.
+func (c *Cache) Fetch(ctx context.Context, key string, load func() (string, error)) (string, error) {
+	if val, exist := c.getLocal(key); exist {
+		return value(val)
+	}
+	if c.stale(key) || !c.breaker.Allow() {
+		return load()
+	}
+	val, err := c.client.Get(ctx, key).Result()
+	if err == nil {
+		c.succeed()
+		atomic.AddInt64(&c.stats.RedisHits, 1)
+		c.setLocal(key, val)
+		return value(val)
+	}
+	if err != redis.Nil {
+		c.fail("get", err)
+		return load()
+	}
+	c.succeed()
+	atomic.AddInt64(&c.stats.RedisMisses, 1)
+	res, err, _ := c.group.Do(key, func() (interface{}, error) {
+		val, err := load()
+		if err == ErrNotFound {
+			c.set(ctx, key, notFound, c.options.NegativeTTL)
+			return "", err
+		}
+		if err != nil {
+			return "", err
+		}
+		c.Set(ctx, key, val)
+		return val, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return res.(string), nil
+}
+
+// MGet returns the values cached at keys, nil for the ones that aren't
+// cached, are cached as missing or can't be read from Redis.
+func (c *Cache) MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	res := make([]interface{}, len(keys))
+	var missed []int
+	var missedKeys []string
+	for i, key := range keys {
+		if val, exist := c.getLocal(key); exist {
+			if val != notFound {
+				res[i] = val
+			}
+			continue
+		}
+		if c.stale(key) {
+			continue
+		}
+		missed = append(missed, i)
+		missedKeys = append(missedKeys, key)
+	}
+	if len(missedKeys) == 0 || !c.breaker.Allow() {
+		return res, nil
+	}
+	vals, err := c.mget(ctx, missedKeys)
+	if err != nil {
+		c.fail("mget", err)
+		return res, nil
+	}
+	c.succeed()
+	for j, val := range vals {
+		if val == nil {
+			atomic.AddInt64(&c.stats.RedisMisses, 1)
+			continue
+		}
+		atomic.AddInt64(&c.stats.RedisHits, 1)
+		c.setLocal(missedKeys[j], val.(string))
+		if val != notFound {
+			res[missed[j]] = val
+		}
+	}
+	return res, nil
+}
+
+// Set caches val at key, unless Redis is failing.
+func (c *Cache) Set(ctx context.Context, key, val string) {
+	c.set(ctx, key, val, c.expiration())
+}
+
+// SetAll caches the values by key in a single round trip, unless Redis is
+// failing.
+func (c *Cache) SetAll(ctx context.Context, vals map[string]string) {
+	if len(vals) == 0 || !c.breaker.Allow() {
+		return
+	}
+	pipe := c.client.Pipeline()
+	for key, val := range vals {
+		pipe.Set(ctx, key, val, c.expiration())
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		c.fail("set all", err)
+		return
+	}
+	c.succeed()
+	for key, val := range vals {
+		c.setLocal(key, val)
+	}
+}
+
+func (c *Cache) set(ctx context.Context, key, val string, expiration time.Duration) {
+	if !c.breaker.Allow() {
+		return
+	}
+	err := c.client.Set(ctx, key, val, expiration).Err()
+	if err != nil {
+		c.fail("set", err)
+		return
+	}
+	c.succeed()
+	c.setLocal(key, val)
+}
+
+// Del removes the keys from Redis and from the in-process tier of every
+// replica. When Redis is failing the keys wait for a retry instead.
+func (c *Cache) Del(ctx context.Context, keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+	if c.local != nil {
+		c.local.Del(keys...)
+	}
+	if !c.breaker.Allow() {
+		c.queue(keys)
+		return
+	}
+	err := c.del(ctx, keys)
+	if err != nil {
+		c.fail("del", err)
+		c.queue(keys)
+		return
+	}
+	c.succeed()
+}
+
+func (c *Cache) del(ctx context.Context, keys []string) error {
+	var err error
+	if c.options.HashTag {
+		pipe := c.client.Pipeline()
+		for _, key := range keys {
+			pipe.Del(ctx, key)
+		}
+		_, err = pipe.Exec(ctx)
+	} else {
+		err = c.client.Del(ctx, keys...).Err()
+	}
+	if err != nil {
+		return err
+	}
+	bt, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	err = c.client.Publish(ctx, c.channel(), string(bt)).Err()
+	if err != nil {
+		// the keys are gone from Redis, other replicas catch up within LocalTTL
+		log.Printf("[ERROR] publish %s cache invalidation: %v", c.name, err)
+	}
+	return nil
+}
+
+// mget reads the keys in one round trip. With HashTag they may be on several
+// shards, so it pipelines a GET per key.
+func (c *Cache) mget(ctx context.Context, keys []string) ([]interface{}, error) {
+	if !c.options.HashTag {
+		return c.client.MGet(ctx, keys...).Result()
+	}
+	pipe := c.client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Get(ctx, key)
+	}
+	// Exec returns redis.Nil when any key is missing, the commands tell
+	_, _ = pipe.Exec(ctx)
+	res := make([]interface{}, len(keys))
+	for i, cmd := range cmds {
+		val, err := cmd.Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		res[i] = val
+	}
+	return res, nil
+}
+
+// stale tells whether Redis may hold an outdated value for key, because its
+// invalidation is waiting for a retry or was dropped.
+func (c *Cache) stale(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pending[key] || time.Now().Before(c.bypassedUntil)
+}
+
+func (c *Cache) queue(keys []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		c.pending[key] = true
+	}
+	if c.options.MaxPending <= 0 || len(c.pending) <= c.options.MaxPending {
+		return
+	}
+	log.Printf("[ERROR] %s cache has more than %d pending invalidations, bypassing Redis", c.name, c.options.MaxPending)
+	c.pending = make(map[string]bool)
+	c.bypassedUntil = time.Now().Add(c.options.TTL + c.options.Jitter)
+}
+
+// retry invalidates the pending keys again every RetryInterval.
+func (c *Cache) retry() {
+	for range time.Tick(c.options.RetryInterval) {
+		c.mu.Lock()
+		keys := make([]string, 0, len(c.pending))
+		for key := range c.pending {
+			keys = append(keys, key)
+		}
+		c.mu.Unlock()
+		if len(keys) == 0 || !c.breaker.Allow() {
+			continue
+		}
+		err := c.del(context.Background(), keys)
+		if err != nil {
+			c.fail("retry del", err)
+			continue
+		}
+		c.succeed()
+		c.mu.Lock()
+		for _, key := range keys {
+			delete(c.pending, key)
+		}
+		c.mu.Unlock()
+		log.Printf("[INFO] %s cache invalidated %d pending keys", c.name, len(keys))
+	}
+}
+
+func (c *Cache) succeed() {
+	c.breaker.Success()
+}
+
+func (c *Cache) fail(op string, err error) {
+	atomic.AddInt64(&c.stats.RedisErrors, 1)
+	c.breaker.Failure()
+	log.Printf("[ERROR] %s cache %s: %v", c.name, op, err)
+}
+
+func (c *Cache) getLocal(key string) (string, bool) {
+	if c.local == nil {
+		return "", false
+	}
+	val, exist := c.local.Get(key)
+	if !exist {
+		atomic.AddInt64(&c.stats.LocalMisses, 1)
+		return "", false
+	}
+	atomic.AddInt64(&c.stats.LocalHits, 1)
+	return val, true
+}
+
+func (c *Cache) setLocal(key, val string) {
+	if c.local == nil {
+		return
+	}
+	ttl := c.options.LocalTTL
+	if val == notFound && c.options.NegativeTTL < ttl {
+		ttl = c.options.NegativeTTL
+	}
+	c.local.Set(key, val, ttl)
+}
+
+// listen drops the keys other replicas invalidate from the in-process tier.
+func (c *Cache) listen() {
+	sub := c.client.Subscribe(context.Background(), c.channel())
+	for msg := range sub.Channel() {
+		var keys []string
+		err := json.Unmarshal([]byte(msg.Payload), &keys)
+		if err != nil {
+			log.Printf("[ERROR] decode %s cache invalidation: %v", c.name, err)
+			continue
+		}
+		c.local.Del(keys...)
+	}
+}
+
+func (c *Cache) channel() string {
+	return c.Key("%s:invalidate", c.name)
+}
+
+func (c *Cache) expiration() time.Duration {
+	if c.options.Jitter <= 0 {
+		return c.options.TTL
+	}
+	return c.options.TTL + time.Duration(rand.Int63n(int64(c.options.Jitter)))
+}
+
+func value(val string) (string, error) {
+	if val == notFound {
+		return "", ErrNotFound
+	}
+	return val, nil
+}
@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// NewStatsHandler serves the hit and miss counters of the caches by name.
+func NewStatsHandler(caches ...*Cache) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := make(map[string]Stats, 0)
+		for _, c := range caches {
+			res[c.Name()] = c.Stats()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(res)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+type healthResponse struct {
+	Status string            `json:"status"`
+	Caches map[string]Health `json:"caches"`
+}
+
+// NewHealthHandler reports "degraded" when any of the caches is. It still
+// responds 200, the API serves from MySQL meanwhile.
+func NewHealthHandler(caches ...*Cache) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := healthResponse{Status: "ok", Caches: make(map[string]Health, 0)}
+		for _, c := range caches {
+			health := c.Health()
+			if health.Degraded() {
+				res.Status = "degraded"
+			}
+			res.Caches[c.Name()] = health
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(res)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
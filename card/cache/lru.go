@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lru is an in-process cache of at most size entries, each one kept until
+// it expires or is the least recently used when a new one comes in.
+type lru struct {
+	mu   sync.Mutex
+	size int
+	// front is the most recently used
+	order *list.List
+	items map[string]*list.Element
+}
+
+type lruItem struct {
+	key       string
+	val       string
+	expiresAt time.Time
+}
+
+func newLRU(size int) *lru {
+	return &lru{
+		size:  size,
+		order: list.New(),
+		items: make(map[string]*list.Element, 0),
+	}
+}
+
+func (l *lru) Get(key string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	el, exist := l.items[key]
+	if !exist {
+		return "", false
+	}
+	item := el.Value.(*lruItem)
+	if time.Now().After(item.expiresAt) {
+		l.order.Remove(el)
+		delete(l.items, key)
+		return "", false
+	}
+	l.order.MoveToFront(el)
+	return item.val, true
+}
+
+func (l *lru) Set(key, val string, ttl time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	expiresAt := time.Now().Add(ttl)
+	if el, exist := l.items[key]; exist {
+		item := el.Value.(*lruItem)
+		item.val = val
+		item.expiresAt = expiresAt
+		l.order.MoveToFront(el)
+		return
+	}
+	l.items[key] = l.order.PushFront(&lruItem{key: key, val: val, expiresAt: expiresAt})
+	for l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.items, oldest.Value.(*lruItem).key)
+	}
+}
+
+func (l *lru) Del(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		if el, exist := l.items[key]; exist {
+			l.order.Remove(el)
+			delete(l.items, key)
+		}
+	}
+}
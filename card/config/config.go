@@ -2,10 +2,17 @@ package config
 
 import (
 	"log"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 )
 
+const (
+	RedisStandalone = "standalone"
+	RedisSentinel   = "sentinel"
+	RedisCluster    = "cluster"
+)
+
 type Config struct {
 	Port          int    `envconfig:"port" default:"9001"`
 	MySQLHost     string `envconfig:"mysql_host" default:"localhost"`
@@ -18,6 +25,30 @@ type Config struct {
 	MySQLMaxIdleConns int    `envconfig:"mysql_max_idle_conn" default:"10"`
 	RedisHost         string `envconfig:"redis_host" default:"localhost:6380"`
 	RedisPassword     string `envconfig:"redis_password" default:"eYVX7EwVmmxKPCDmwMtyKVge8oLd2t81"`
+
+	// RedisMode is one of standalone, sentinel or cluster. RedisAddrs are the
+	// sentinels or the cluster nodes, comma separated, RedisHost is used when
+	// it's empty.
+	RedisMode       string   `envconfig:"redis_mode" default:"standalone"`
+	RedisAddrs      []string `envconfig:"redis_addrs"`
+	RedisMasterName string   `envconfig:"redis_master_name" default:"mymaster"`
+
+	RedisCacheVersion     string        `envconfig:"redis_cache_version" default:"v1"`
+	RedisCacheTTL         time.Duration `envconfig:"redis_cache_ttl" default:"1h"`
+	RedisCacheTTLJitter   time.Duration `envconfig:"redis_cache_ttl_jitter" default:"10m"`
+	RedisNegativeCacheTTL time.Duration `envconfig:"redis_negative_cache_ttl" default:"30s"`
+	LocalCacheSize        int           `envconfig:"local_cache_size" default:"10000"`
+	LocalCacheTTL         time.Duration `envconfig:"local_cache_ttl" default:"30s"`
+
+	RedisBreakerThreshold        int           `envconfig:"redis_breaker_threshold" default:"5"`
+	RedisBreakerCooldown         time.Duration `envconfig:"redis_breaker_cooldown" default:"10s"`
+	RedisRetryInterval           time.Duration `envconfig:"redis_retry_interval" default:"1s"`
+	RedisMaxPendingInvalidations int           `envconfig:"redis_max_pending_invalidations" default:"10000"`
+
+	// MySQLQueryTimeout bounds every statement on top of the request deadline,
+	// statements that take MySQLSlowQueryThreshold or longer are logged.
+	MySQLQueryTimeout       time.Duration `envconfig:"mysql_query_timeout" default:"5s"`
+	MySQLSlowQueryThreshold time.Duration `envconfig:"mysql_slow_query_threshold" default:"500ms"`
 }
 
 func NewConfig() Config {
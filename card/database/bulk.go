@@ -0,0 +1,75 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// maxBulkRows keeps a multi-row statement well under the 65535 placeholders
+// MySQL accepts.
+const maxBulkRows = 500
+
+// BulkExec runs query once per maxBulkRows rows, formatting its %s with the
+// placeholders of the rows. Every row has the same number of values.
+func (m *MySQL) BulkExec(ctx context.Context, query string, rows [][]interface{}) error {
+	for start := 0; start < len(rows); start += maxBulkRows {
+		end := start + maxBulkRows
+		if end > len(rows) {
+			end = len(rows)
+		}
+		chunk := rows[start:end]
+		row := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(chunk[0])), ", ") + ")"
+		placeholders := make([]string, len(chunk))
+		args := make([]interface{}, 0, len(chunk)*len(chunk[0]))
+		for i, values := range chunk {
+			placeholders[i] = row
+			args = append(args, values...)
+		}
+		_, err := m.ExecContext(ctx, fmt.Sprintf(query, strings.Join(placeholders, ", ")), args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteExcept deletes the rows of table, of the parents bound to the one
+// placeholder of where, whose entity_id isn't in ids. The rows are diffed
+// against the ones read for update, not against what the caller read before,
+// and only the missing ones are deleted by entity_id.
+func (m *MySQL) DeleteExcept(ctx context.Context, table, where string, parentIDs []string, ids []string) error {
+	if len(parentIDs) == 0 {
+		return nil
+	}
+	query, args, err := sqlx.In("SELECT entity_id FROM "+table+" WHERE "+where+" FOR UPDATE", parentIDs)
+	if err != nil {
+		return err
+	}
+	var existing []string
+	err = m.SelectContext(ctx, &existing, m.Rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	keep := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		keep[id] = true
+	}
+	var missing []string
+	for _, id := range existing {
+		if !keep[id] {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	query, args, err = sqlx.In("DELETE FROM "+table+" WHERE entity_id IN (?)", missing)
+	if err != nil {
+		return err
+	}
+	_, err = m.ExecContext(ctx, m.Rebind(query), args...)
+	return err
+}
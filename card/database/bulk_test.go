@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/rakateja/milo/twirp-rpc-examples/card/config"
+)
+
+const (
+	createBenchChildQuery = `
+		CREATE TABLE IF NOT EXISTS bench_store_child (
+			entity_id VARCHAR(64) NOT NULL PRIMARY KEY,
+			parent_id VARCHAR(64) NOT NULL,
+			value INT NOT NULL,
+			KEY bench_store_child_parent_id (parent_id)
+		)
+	`
+	upsertBenchChildQuery = `
+		INSERT INTO bench_store_child (entity_id, parent_id, value)
+		VALUES %s
+		ON DUPLICATE KEY UPDATE
+			value = VALUES(value)
+	`
+	insertBenchChildQuery = `
+		INSERT INTO bench_store_child (entity_id, parent_id, value)
+		VALUES %s
+	`
+)
+
+type benchChild struct {
+	ID    string
+	Value int
+}
+
+// benchMySQL connects to the MySQL of the config. The benchmarks write to
+// it, so they only run when TWIRP_RPC_CARD_BENCH_MYSQL is set.
+func benchMySQL(b *testing.B) *MySQL {
+	if os.Getenv("TWIRP_RPC_CARD_BENCH_MYSQL") == "" {
+		b.Skip("TWIRP_RPC_CARD_BENCH_MYSQL isn't set")
+	}
+	db, err := NewMySQL(config.NewConfig())
+	if err != nil {
+		b.Fatalf("NewMySQL returned error: %v", err)
+	}
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, createBenchChildQuery); err != nil {
+		b.Fatalf("create bench_store_child: %v", err)
+	}
+	b.Cleanup(func() {
+		db.ExecContext(ctx, "DROP TABLE bench_store_child")
+	})
+	return db
+}
+
+func benchRows(parentID string, children []benchChild) [][]interface{} {
+	rows := make([][]interface{}, 0, len(children))
+	for _, c := range children {
+		rows = append(rows, []interface{}{c.ID, parentID, c.Value})
+	}
+	return rows
+}
+
+// storeDiff stores the children the way the repositories do: the missing
+// rows are deleted and the others upserted.
+func storeDiff(ctx context.Context, db *MySQL, parentID string, children []benchChild) error {
+	ids := make([]string, 0, len(children))
+	for _, c := range children {
+		ids = append(ids, c.ID)
+	}
+	err := db.DeleteExcept(ctx, "bench_store_child", "parent_id IN (?)", []string{parentID}, ids)
+	if err != nil {
+		return err
+	}
+	return db.BulkExec(ctx, upsertBenchChildQuery, benchRows(parentID, children))
+}
+
+// storeReinsert deletes every row of the parent and inserts the children
+// again.
+func storeReinsert(ctx context.Context, db *MySQL, parentID string, children []benchChild) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM bench_store_child WHERE parent_id = ?", parentID)
+	if err != nil {
+		return err
+	}
+	return db.BulkExec(ctx, insertBenchChildQuery, benchRows(parentID, children))
+}
+
+// BenchmarkStore stores a parent's children after a typical edit, one child
+// changed, one removed and one added, with both strategies.
+func BenchmarkStore(b *testing.B) {
+	db := benchMySQL(b)
+	strategies := []struct {
+		name  string
+		store func(ctx context.Context, db *MySQL, parentID string, children []benchChild) error
+	}{
+		{"diff", storeDiff},
+		{"reinsert", storeReinsert},
+	}
+	for _, size := range []int{10, 100, 1000} {
+		for _, strategy := range strategies {
+			b.Run(fmt.Sprintf("%s/%d", strategy.name, size), func(b *testing.B) {
+				ctx := context.Background()
+				parentID := fmt.Sprintf("%s-%d", strategy.name, size)
+				children := make([]benchChild, 0, size)
+				for i := 0; i < size; i++ {
+					children = append(children, benchChild{ID: fmt.Sprintf("%s-%d", parentID, i), Value: i})
+				}
+				err := storeReinsert(ctx, db, parentID, children)
+				if err != nil {
+					b.Fatalf("seed children: %v", err)
+				}
+				next := size
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					children[i%size].Value++
+					children[(i+1)%size] = benchChild{ID: fmt.Sprintf("%s-%d", parentID, next), Value: next}
+					next++
+					err := db.Transaction(ctx, func(ctx context.Context) error {
+						return strategy.store(ctx, db, parentID, children)
+					})
+					if err != nil {
+						b.Fatalf("store children: %v", err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package databasetest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/config"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/database"
+)
+
+// Counter counts the statements sent to MySQL by their first keyword, e.g.
+// SELECT or DELETE.
+type Counter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (c *Counter) add(query string) {
+	keyword := ""
+	if fields := strings.Fields(query); len(fields) > 0 {
+		keyword = strings.ToUpper(fields[0])
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[keyword]++
+}
+
+// Reset forgets the statements counted so far.
+func (c *Counter) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts = make(map[string]int, 0)
+}
+
+// Count is how many statements starting with keyword were sent.
+func (c *Counter) Count(keyword string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[keyword]
+}
+
+// Total is how many statements were sent.
+func (c *Counter) Total() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var total int
+	for _, n := range c.counts {
+		total += n
+	}
+	return total
+}
+
+var (
+	countingMu      sync.Mutex
+	countingDrivers int
+)
+
+// OpenCounting is Open with every statement counted by the returned counter.
+func OpenCounting(tb testing.TB) (*database.MySQL, *Counter) {
+	skipWithoutMySQL(tb)
+	counter := &Counter{counts: make(map[string]int, 0)}
+	countingMu.Lock()
+	countingDrivers++
+	driverName := fmt.Sprintf("mysql-counting-%d", countingDrivers)
+	countingMu.Unlock()
+	sql.Register(driverName, countingDriver{counter})
+	db, err := database.NewMySQLWithDriver(config.NewConfig(), driverName)
+	if err != nil {
+		tb.Fatalf("NewMySQLWithDriver returned error: %v", err)
+	}
+	return db, counter
+}
+
+type countingDriver struct {
+	counter *Counter
+}
+
+func (d countingDriver) Open(dsn string) (driver.Conn, error) {
+	conn, err := mysql.MySQLDriver{}.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{conn, d.counter}, nil
+}
+
+// countingConn counts the statements run on the connection. The mysql
+// connection skips the queries with arguments, database/sql prepares those
+// and they're counted by countingStmt instead.
+type countingConn struct {
+	driver.Conn
+	counter *Counter
+}
+
+func (c *countingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func (c *countingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res, err := c.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		c.counter.add(query)
+	}
+	return res, err
+}
+
+func (c *countingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := c.Conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		c.counter.add(query)
+	}
+	return rows, err
+}
+
+func (c *countingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := c.Conn.(driver.ConnPrepareContext).PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &countingStmt{stmt, query, c.counter}, nil
+}
+
+func (c *countingConn) CheckNamedValue(nv *driver.NamedValue) error {
+	return c.Conn.(driver.NamedValueChecker).CheckNamedValue(nv)
+}
+
+func (c *countingConn) Ping(ctx context.Context) error {
+	return c.Conn.(driver.Pinger).Ping(ctx)
+}
+
+func (c *countingConn) ResetSession(ctx context.Context) error {
+	return c.Conn.(driver.SessionResetter).ResetSession(ctx)
+}
+
+func (c *countingConn) IsValid() bool {
+	return c.Conn.(driver.Validator).IsValid()
+}
+
+type countingStmt struct {
+	driver.Stmt
+	query   string
+	counter *Counter
+}
+
+func (s *countingStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	s.counter.add(s.query)
+	return s.Stmt.(driver.StmtExecContext).ExecContext(ctx, args)
+}
+
+func (s *countingStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	s.counter.add(s.query)
+	return s.Stmt.(driver.StmtQueryContext).QueryContext(ctx, args)
+}
+
+func (s *countingStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	return s.Stmt.(driver.NamedValueChecker).CheckNamedValue(nv)
+}
@@ -0,0 +1,28 @@
+// Package databasetest connects tests to the MySQL of the config. The tests
+// write to it, so they only run when TWIRP_RPC_CARD_TEST_MYSQL is set, against
+// a database with the migrations applied.
+package databasetest
+
+import (
+	"os"
+	"testing"
+
+	"github.com/rakateja/milo/twirp-rpc-examples/card/config"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/database"
+)
+
+// Open connects to the MySQL of the config, or skips the test.
+func Open(tb testing.TB) *database.MySQL {
+	skipWithoutMySQL(tb)
+	db, err := database.NewMySQL(config.NewConfig())
+	if err != nil {
+		tb.Fatalf("NewMySQL returned error: %v", err)
+	}
+	return db
+}
+
+func skipWithoutMySQL(tb testing.TB) {
+	if os.Getenv("TWIRP_RPC_CARD_TEST_MYSQL") == "" {
+		tb.Skip("TWIRP_RPC_CARD_TEST_MYSQL isn't set")
+	}
+}
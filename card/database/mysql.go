@@ -1,22 +1,31 @@
 package database
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
-	"github.com/pkg/errors"
 	"github.com/rakateja/milo/twirp-rpc-examples/card/config"
 )
 
-type Block func(tx *sqlx.Tx) error
-
 type MySQL struct {
-	db *sqlx.DB
+	db                 *sqlx.DB
+	queryTimeout       time.Duration
+	slowQueryThreshold time.Duration
 }
 
 func NewMySQL(conf config.Config) (*MySQL, error) {
+	return NewMySQLWithDriver(conf, "mysql")
+}
+
+// NewMySQLWithDriver connects through the database/sql driver registered as
+// driverName, which takes the DSN of go-sql-driver/mysql.
+func NewMySQLWithDriver(conf config.Config, driverName string) (*MySQL, error) {
 	credentialString := fmt.Sprintf("%s:%s", conf.MySQLUser, conf.MySQLPassword)
 	if conf.MySQLPassword == "" {
 		credentialString = conf.MySQLUser
@@ -24,8 +33,7 @@ func NewMySQL(conf config.Config) (*MySQL, error) {
 	publicConnString := fmt.Sprintf("%s@tcp(%s:%d)/%s?parseTime=true", conf.MySQLUser, conf.MySQLHost, conf.MySQLPort, conf.MySQLDatabase)
 	log.Printf("Connecting to MySQL %s", publicConnString)
 	connString := fmt.Sprintf("%s@tcp(%s:%d)/%s?parseTime=true", credentialString, conf.MySQLHost, conf.MySQLPort, conf.MySQLDatabase)
-	log.Println(connString)
-	db, err := sqlx.Open("mysql", connString)
+	db, err := sqlx.Open(driverName, connString)
 	if err != nil {
 		return nil, err
 	}
@@ -34,26 +42,7 @@ func NewMySQL(conf config.Config) (*MySQL, error) {
 	if err := db.Ping(); err != nil {
 		return nil, err
 	}
-	return &MySQL{db: db}, nil
-}
-
-func (m *MySQL) WithTransaction(block Block) error {
-	tx, err := m.db.Beginx()
-	if err != nil {
-		return errors.Wrap(err, "can't start DB transaction")
-	}
-	err = block(tx)
-	if err != nil {
-		if e := tx.Rollback(); e != nil {
-			return errors.Wrap(err, "rollback fails")
-		}
-		return err
-	}
-	err = tx.Commit()
-	if err != nil {
-		return errors.Wrap(err, "transaction commit fails")
-	}
-	return nil
+	return &MySQL{db: db, queryTimeout: conf.MySQLQueryTimeout, slowQueryThreshold: conf.MySQLSlowQueryThreshold}, nil
 }
 
 func (m *MySQL) In(query string, params map[string]interface{}) (string, []interface{}, error) {
@@ -64,18 +53,87 @@ func (m *MySQL) In(query string, params map[string]interface{}) (string, []inter
 	return sqlx.In(query, args...)
 }
 
-func (m *MySQL) Get(dest interface{}, query string, args ...interface{}) error {
-	return m.db.Get(dest, query, args...)
+// GetContext runs query in the transaction of ctx, if any, and gives up
+// after the query timeout.
+func (m *MySQL) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	defer m.logSlow(time.Now(), query)
+	return sqlx.GetContext(ctx, m.queryer(ctx), dest, query, args...)
+}
+
+// SelectContext runs query in the transaction of ctx, if any, and gives up
+// after the query timeout.
+func (m *MySQL) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	defer m.logSlow(time.Now(), query)
+	return sqlx.SelectContext(ctx, m.queryer(ctx), dest, query, args...)
 }
 
-func (m *MySQL) Select(dest interface{}, query string, args ...interface{}) error {
-	return m.db.Select(dest, query, args...)
+// ExecContext runs query in the transaction of ctx, if any, and gives up
+// after the query timeout.
+func (m *MySQL) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	defer m.logSlow(time.Now(), query)
+	return m.execer(ctx).ExecContext(ctx, query, args...)
 }
 
 func (m *MySQL) Rebind(query string) string {
 	return m.db.Rebind(query)
 }
 
-func (m *MySQL) Query(query string, args ...interface{}) (*sqlx.Rows, error) {
-	return m.db.Queryx(query, args...)
+// Rows holds the query timeout until it's closed, callers have to close it
+// even after reading every row.
+type Rows struct {
+	*sqlx.Rows
+	cancel context.CancelFunc
+}
+
+func (r *Rows) Close() error {
+	defer r.cancel()
+	return r.Rows.Close()
+}
+
+// QueryContext runs query in the transaction of ctx, if any. The query
+// timeout covers reading the rows too.
+func (m *MySQL) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer m.logSlow(time.Now(), query)
+	rows, err := m.queryer(ctx).QueryxContext(ctx, query, args...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &Rows{Rows: rows, cancel: cancel}, nil
+}
+
+func (m *MySQL) queryer(ctx context.Context) sqlx.QueryerContext {
+	if state := stateFromContext(ctx); state != nil {
+		return state.tx
+	}
+	return m.db
+}
+
+func (m *MySQL) execer(ctx context.Context) sqlx.ExecerContext {
+	if state := stateFromContext(ctx); state != nil {
+		return state.tx
+	}
+	return m.db
+}
+
+func (m *MySQL) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if m.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, m.queryTimeout)
+}
+
+func (m *MySQL) logSlow(start time.Time, query string) {
+	elapsed := time.Since(start)
+	if m.slowQueryThreshold <= 0 || elapsed < m.slowQueryThreshold {
+		return
+	}
+	log.Printf("[WARN] slow query - it tooks %s: %s", elapsed, strings.Join(strings.Fields(query), " "))
 }
@@ -0,0 +1,64 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/rakateja/milo/twirp-rpc-examples/card/config"
+	redis "github.com/redis/go-redis/v9"
+)
+
+const pingTimeout = 5 * time.Second
+
+// NewRedis connects to a standalone Redis, the master behind sentinels or a
+// cluster depending on conf.RedisMode. It doesn't reach Redis, PingRedis does.
+func NewRedis(conf config.Config) (redis.UniversalClient, error) {
+	addrs := conf.RedisAddrs
+	if len(addrs) == 0 {
+		addrs = []string{conf.RedisHost}
+	}
+	log.Printf("Connecting to Redis %s %v", conf.RedisMode, addrs)
+	var client redis.UniversalClient
+	switch conf.RedisMode {
+	case config.RedisStandalone:
+		client = redis.NewClient(&redis.Options{
+			Addr:     addrs[0],
+			Password: conf.RedisPassword,
+			DB:       0,
+		})
+	case config.RedisSentinel:
+		client = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    conf.RedisMasterName,
+			SentinelAddrs: addrs,
+			Password:      conf.RedisPassword,
+			DB:            0,
+		})
+	case config.RedisCluster:
+		client = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:    addrs,
+			Password: conf.RedisPassword,
+		})
+	default:
+		return nil, fmt.Errorf("unknown redis mode %q", conf.RedisMode)
+	}
+	return client, nil
+}
+
+// PingRedis pings every node the client will use: each shard of a cluster, a
+// cluster that can't reach one of them fails the keys in its slots.
+func PingRedis(client redis.UniversalClient) error {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	cluster, ok := client.(*redis.ClusterClient)
+	if !ok {
+		return client.Ping(ctx).Err()
+	}
+	return cluster.ForEachShard(ctx, func(ctx context.Context, shard *redis.Client) error {
+		if err := shard.Ping(ctx).Err(); err != nil {
+			return fmt.Errorf("ping redis shard %s: %w", shard.Options().Addr, err)
+		}
+		return nil
+	})
+}
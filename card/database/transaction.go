@@ -0,0 +1,193 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxTxAttempts is how many times RetryTransaction runs fn before a
+	// deadlock is returned to the caller.
+	maxTxAttempts = 3
+	txRetryDelay  = 50 * time.Millisecond
+
+	errDeadlock        = 1213
+	errLockWaitTimeout = 1205
+	errDuplicateEntry  = 1062
+)
+
+// Transactor runs fn in one transaction, shared by every repository call fn
+// makes with the context it's given.
+type Transactor interface {
+	Transaction(ctx context.Context, fn func(ctx context.Context) error) error
+	RetryTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type txKey struct{}
+
+type txState struct {
+	tx          *sqlx.Tx
+	savepoints  int
+	afterCommit []func()
+	onRollback  []func()
+}
+
+func stateFromContext(ctx context.Context) *txState {
+	state, _ := ctx.Value(txKey{}).(*txState)
+	return state
+}
+
+// Transaction runs fn in a transaction carried by the context it passes to
+// fn. When ctx already carries one, fn runs in a savepoint of it instead, so
+// its failure only rolls back its own writes.
+func (m *MySQL) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if state := stateFromContext(ctx); state != nil {
+		return m.savepoint(ctx, state, fn)
+	}
+	return m.transaction(ctx, fn)
+}
+
+// RetryTransaction is Transaction for fn that reads everything it writes
+// with the context it's given: when the transaction fails on a deadlock or a
+// lock wait timeout, fn runs again in a new one. Inside a transaction it's a
+// savepoint, the outermost RetryTransaction retries.
+func (m *MySQL) RetryTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if state := stateFromContext(ctx); state != nil {
+		return m.savepoint(ctx, state, fn)
+	}
+	delay := txRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := m.transaction(ctx, fn)
+		if err == nil || attempt == maxTxAttempts || !isRetryable(err) {
+			return err
+		}
+		// jitter keeps the transactions that deadlocked on each other from
+		// meeting again
+		wait := delay + time.Duration(rand.Int63n(int64(delay)))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+}
+
+func (m *MySQL) transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "can't start DB transaction")
+	}
+	state := &txState{tx: tx}
+	err = fn(context.WithValue(ctx, txKey{}, state))
+	if err != nil {
+		rollback(state.onRollback)
+		if e := tx.Rollback(); e != nil {
+			return errors.Wrap(err, "rollback fails")
+		}
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		rollback(state.onRollback)
+		return errors.Wrap(err, "transaction commit fails")
+	}
+	for _, f := range state.afterCommit {
+		f()
+	}
+	return nil
+}
+
+func (m *MySQL) savepoint(ctx context.Context, state *txState, fn func(ctx context.Context) error) error {
+	state.savepoints++
+	name := fmt.Sprintf("sp_%d", state.savepoints)
+	pending := len(state.afterCommit)
+	undo := len(state.onRollback)
+	if _, err := state.tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return errors.Wrap(err, "can't create savepoint")
+	}
+	err := fn(ctx)
+	if err != nil {
+		if _, e := state.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); e != nil {
+			return errors.Wrap(err, "rollback to savepoint fails")
+		}
+		// what the rolled back writes would have invalidated is unchanged
+		state.afterCommit = state.afterCommit[:pending]
+		rollback(state.onRollback[undo:])
+		state.onRollback = state.onRollback[:undo]
+		return err
+	}
+	if _, err := state.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+		return errors.Wrap(err, "release savepoint fails")
+	}
+	return nil
+}
+
+// AfterCommit runs fn once the transaction carried by ctx commits, and drops
+// it if the transaction rolls back. Without a transaction fn runs right away.
+func AfterCommit(ctx context.Context, fn func()) {
+	state := stateFromContext(ctx)
+	if state == nil {
+		fn()
+		return
+	}
+	state.afterCommit = append(state.afterCommit, fn)
+}
+
+// OnRollback runs fn when the writes of ctx's transaction, or of the
+// savepoint ctx is in, are rolled back. It undoes what the writes set on the
+// entities they were given, like an allocated key, so storing them again
+// doesn't take the rolled back state for committed. Without a transaction
+// there's nothing to roll back and fn is dropped.
+func OnRollback(ctx context.Context, fn func()) {
+	state := stateFromContext(ctx)
+	if state == nil {
+		return
+	}
+	state.onRollback = append(state.onRollback, fn)
+}
+
+// rollback runs the undo functions, the last registered first.
+func rollback(fns []func()) {
+	for i := len(fns) - 1; i >= 0; i-- {
+		fns[i]()
+	}
+}
+
+// InTransaction reports whether ctx carries a transaction. What's read in one
+// may not be committed yet, so it mustn't be cached.
+func InTransaction(ctx context.Context) bool {
+	return stateFromContext(ctx) != nil
+}
+
+// ForUpdate makes query, a SELECT, a locking read when ctx carries a
+// transaction. It reads the latest committed rows instead of the snapshot of
+// the transaction, and no other transaction changes them until it ends, so
+// an entity read with it can be stored back without losing concurrent writes.
+func ForUpdate(ctx context.Context, query string) string {
+	if !InTransaction(ctx) {
+		return query
+	}
+	return query + " FOR UPDATE"
+}
+
+func isRetryable(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return false
+	}
+	return mysqlErr.Number == errDeadlock || mysqlErr.Number == errLockWaitTimeout
+}
+
+// IsDuplicateEntry reports whether err is a write that broke a unique index,
+// e.g. two requests that both checked a code was free before taking it.
+func IsDuplicateEntry(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == errDuplicateEntry
+}
@@ -0,0 +1,395 @@
+package board
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/apierror"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/cache"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/database"
+)
+
+const (
+	// cachedKey holds the whole board. The card counts of its lists change
+	// with every card write, so they're counted again on each read.
+	cachedKey = "board:%s"
+	// board codes never change, so their mapping to the entity ID is only
+	// invalidated to drop a cached miss
+	cachedCodeKey = "board:code:%s"
+)
+
+type cachedRepository struct {
+	sqlRepo Repository
+	cache   *cache.Cache
+}
+
+// NewCachedRepository shares the cache with the label and custom field
+// decorators, whose writes invalidate boards.
+func NewCachedRepository(repo Repository, c *cache.Cache) Repository {
+	return &cachedRepository{repo, c}
+}
+
+// fetch translates between the board's and the cache's not found errors, and
+// skips the cache inside a transaction.
+func (repo *cachedRepository) fetch(ctx context.Context, key string, load func() (string, error)) (string, error) {
+	if database.InTransaction(ctx) {
+		return load()
+	}
+	val, err := repo.cache.Fetch(ctx, key, func() (string, error) {
+		val, err := load()
+		if apiErr, ok := errors.Cause(err).(apierror.APIError); ok && apiErr.Code == ErrorCodeEntityNotFound {
+			return "", cache.ErrNotFound
+		}
+		return val, err
+	})
+	if err == cache.ErrNotFound {
+		return "", apierror.WithDesc(ErrorCodeEntityNotFound, "board couldn't be found")
+	}
+	return val, err
+}
+
+func (repo *cachedRepository) Store(ctx context.Context, entity *Board) error {
+	err := repo.sqlRepo.Store(ctx, entity)
+	if err != nil {
+		return err
+	}
+	invalidate(ctx, repo.cache, repo.cache.Key(cachedKey, entity.ID), repo.cache.Key(cachedCodeKey, entity.Code))
+	return nil
+}
+
+func (repo *cachedRepository) StoreMember(ctx context.Context, entity BoardMember) error {
+	err := repo.sqlRepo.StoreMember(ctx, entity)
+	if err != nil {
+		return err
+	}
+	invalidate(ctx, repo.cache, repo.cache.Key(cachedKey, entity.BoardID))
+	return nil
+}
+
+// StoreList also invalidates the board the list belonged to before, in case
+// it moved to another board.
+func (repo *cachedRepository) StoreList(ctx context.Context, entity BoardList) error {
+	keys := []string{repo.cache.Key(cachedKey, entity.BoardID)}
+	current, err := repo.sqlRepo.ResolveListByID(ctx, entity.ID)
+	if err != nil {
+		if apiErr, ok := errors.Cause(err).(apierror.APIError); !ok || apiErr.Code != ErrorCodeEntityNotFound {
+			return err
+		}
+	} else if current.BoardID != entity.BoardID {
+		keys = append(keys, repo.cache.Key(cachedKey, current.BoardID))
+	}
+	err = repo.sqlRepo.StoreList(ctx, entity)
+	if err != nil {
+		return err
+	}
+	invalidate(ctx, repo.cache, keys...)
+	return nil
+}
+
+func (repo *cachedRepository) StoreLists(ctx context.Context, lists []BoardList) error {
+	err := repo.sqlRepo.StoreLists(ctx, lists)
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for _, l := range lists {
+		keys = append(keys, repo.cache.Key(cachedKey, l.BoardID))
+	}
+	invalidate(ctx, repo.cache, keys...)
+	return nil
+}
+
+func (repo *cachedRepository) StoreWatchers(ctx context.Context, boardID string, watchers []Watcher) error {
+	err := repo.sqlRepo.StoreWatchers(ctx, boardID, watchers)
+	if err != nil {
+		return err
+	}
+	invalidate(ctx, repo.cache, repo.cache.Key(cachedKey, boardID))
+	return nil
+}
+
+// StoreClone creates a new board, only a cached miss of its code may need to
+// be dropped.
+func (repo *cachedRepository) StoreClone(ctx context.Context, clone Clone, copier CardCopier) error {
+	err := repo.sqlRepo.StoreClone(ctx, clone, copier)
+	if err != nil {
+		return err
+	}
+	invalidate(ctx, repo.cache, repo.cache.Key(cachedCodeKey, clone.Board.Code))
+	return nil
+}
+
+func (repo *cachedRepository) ResolveByID(ctx context.Context, id string) (*Board, error) {
+	return repo.ResolveProjectedByID(ctx, id, nil)
+}
+
+func (repo *cachedRepository) ResolveByIDForUpdate(ctx context.Context, id string) (*Board, error) {
+	return repo.sqlRepo.ResolveByIDForUpdate(ctx, id)
+}
+
+// ResolveProjectedByID caches whole boards, which the projection trims.
+func (repo *cachedRepository) ResolveProjectedByID(ctx context.Context, id string, projection Projection) (*Board, error) {
+	val, err := repo.fetch(ctx, repo.cache.Key(cachedKey, id), func() (string, error) {
+		res, err := repo.sqlRepo.ResolveByID(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		bt, err := json.Marshal(res)
+		if err != nil {
+			return "", err
+		}
+		return string(bt), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var res Board
+	if err := json.Unmarshal([]byte(val), &res); err != nil {
+		return nil, err
+	}
+	projection.Apply(&res)
+	if projection.Includes(CollectionLists) {
+		err = repo.sqlRepo.CountCards(ctx, res.Lists)
+		if err != nil {
+			return nil, errors.Wrap(err, "count cards by list id")
+		}
+	}
+	return &res, nil
+}
+
+func (repo *cachedRepository) ResolveByCode(ctx context.Context, code string, projection Projection) (*Board, error) {
+	id, err := repo.fetch(ctx, repo.cache.Key(cachedCodeKey, code), func() (string, error) {
+		res, err := repo.sqlRepo.ResolveByCode(ctx, code, Projection{})
+		if err != nil {
+			return "", err
+		}
+		return res.ID, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return repo.ResolveProjectedByID(ctx, id, projection)
+}
+
+func (repo *cachedRepository) ExistByCode(ctx context.Context, code string) (bool, error) {
+	return repo.sqlRepo.ExistByCode(ctx, code)
+}
+
+func (repo *cachedRepository) ResolveAllByFilter(ctx context.Context, filter Filter) ([]Board, error) {
+	return repo.sqlRepo.ResolveAllByFilter(ctx, filter)
+}
+
+// ResolveAll reads the page's boards in a single MGET, and only resolves the
+// ones missing from the cache from the SQL repository.
+func (repo *cachedRepository) ResolveAll(ctx context.Context, offset, limit int, projection Projection) ([]Board, error) {
+	if database.InTransaction(ctx) {
+		return repo.sqlRepo.ResolveAll(ctx, offset, limit, projection)
+	}
+	res, err := repo.sqlRepo.ResolveAll(ctx, offset, limit, Projection{})
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 || (projection != nil && len(projection) == 0) {
+		return res, nil
+	}
+	var keys []string
+	for _, b := range res {
+		keys = append(keys, repo.cache.Key(cachedKey, b.ID))
+	}
+	vals, err := repo.cache.MGet(ctx, keys...)
+	if err != nil {
+		return nil, err
+	}
+	var lists []BoardList
+	var missed []string
+	for i, val := range vals {
+		if val == nil {
+			missed = append(missed, res[i].ID)
+			continue
+		}
+		var entity Board
+		if err := json.Unmarshal([]byte(val.(string)), &entity); err != nil {
+			return nil, err
+		}
+		projection.Apply(&entity)
+		res[i] = entity
+		lists = append(lists, entity.Lists...)
+	}
+	if len(missed) > 0 {
+		err = repo.resolveMissed(ctx, res, missed, projection)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// count the cached lists' cards at once, then hand them back
+	err = repo.sqlRepo.CountCards(ctx, lists)
+	if err != nil {
+		return nil, errors.Wrap(err, "count cards by list id")
+	}
+	counts := make(map[string]int, 0)
+	for _, l := range lists {
+		counts[l.ID] = l.CardCount
+	}
+	for i := range res {
+		for j := range res[i].Lists {
+			if count, exist := counts[res[i].Lists[j].ID]; exist {
+				res[i].Lists[j].CardCount = count
+			}
+		}
+	}
+	return res, nil
+}
+
+// resolveMissed loads the boards missing from the cache in one read, then
+// writes them back in one round trip.
+func (repo *cachedRepository) resolveMissed(ctx context.Context, res []Board, missed []string, projection Projection) error {
+	boards, err := repo.sqlRepo.ResolveAllByIDs(ctx, missed)
+	if err != nil {
+		return err
+	}
+	boardMap := make(map[string]Board, 0)
+	writeBack := make(map[string]string, 0)
+	for _, b := range boards {
+		bt, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		writeBack[repo.cache.Key(cachedKey, b.ID)] = string(bt)
+		boardMap[b.ID] = b
+	}
+	repo.cache.SetAll(ctx, writeBack)
+	for i := range res {
+		if b, exist := boardMap[res[i].ID]; exist {
+			projection.Apply(&b)
+			res[i] = b
+		}
+	}
+	return nil
+}
+
+func (repo *cachedRepository) ResolveAllByIDs(ctx context.Context, ids []string) ([]Board, error) {
+	return repo.sqlRepo.ResolveAllByIDs(ctx, ids)
+}
+
+func (repo *cachedRepository) ResolveTotal(ctx context.Context) (int, error) {
+	return repo.sqlRepo.ResolveTotal(ctx)
+}
+
+func (repo *cachedRepository) ResolveListByID(ctx context.Context, listID string) (BoardList, error) {
+	return repo.sqlRepo.ResolveListByID(ctx, listID)
+}
+
+func (repo *cachedRepository) ExistListByPublicID(ctx context.Context, publicID string) (bool, error) {
+	return repo.sqlRepo.ExistListByPublicID(ctx, publicID)
+}
+
+func (repo *cachedRepository) ResolveTemplateByID(ctx context.Context, id string) (*Template, error) {
+	return repo.sqlRepo.ResolveTemplateByID(ctx, id)
+}
+
+func (repo *cachedRepository) ResolveAllTemplates(ctx context.Context) ([]Template, error) {
+	return repo.sqlRepo.ResolveAllTemplates(ctx)
+}
+
+func (repo *cachedRepository) CountCards(ctx context.Context, lists []BoardList) error {
+	return repo.sqlRepo.CountCards(ctx, lists)
+}
+
+// cachedLabelRepository invalidates the cached board of every label it
+// writes.
+type cachedLabelRepository struct {
+	sqlRepo LabelRepository
+	cache   *cache.Cache
+}
+
+func NewCachedLabelRepository(repo LabelRepository, c *cache.Cache) LabelRepository {
+	return &cachedLabelRepository{repo, c}
+}
+
+func (repo *cachedLabelRepository) Store(ctx context.Context, entity *Label) error {
+	err := repo.sqlRepo.Store(ctx, entity)
+	if err != nil {
+		return err
+	}
+	invalidate(ctx, repo.cache, repo.cache.Key(cachedKey, entity.BoardID))
+	return nil
+}
+
+func (repo *cachedLabelRepository) ResolveByID(ctx context.Context, id string) (*Label, error) {
+	return repo.sqlRepo.ResolveByID(ctx, id)
+}
+
+func (repo *cachedLabelRepository) ExistByID(ctx context.Context, id string) (bool, error) {
+	return repo.sqlRepo.ExistByID(ctx, id)
+}
+
+func (repo *cachedLabelRepository) ResolveAllByBoardID(ctx context.Context, boardID string) ([]Label, error) {
+	return repo.sqlRepo.ResolveAllByBoardID(ctx, boardID)
+}
+
+func (repo *cachedLabelRepository) ResolveBySlug(ctx context.Context, slug string) (*Label, error) {
+	return repo.sqlRepo.ResolveBySlug(ctx, slug)
+}
+
+// cachedCustomFieldRepository invalidates the cached board of every custom
+// field it writes.
+type cachedCustomFieldRepository struct {
+	sqlRepo CustomFieldRepository
+	cache   *cache.Cache
+}
+
+func NewCachedCustomFieldRepository(repo CustomFieldRepository, c *cache.Cache) CustomFieldRepository {
+	return &cachedCustomFieldRepository{repo, c}
+}
+
+func (repo *cachedCustomFieldRepository) Store(ctx context.Context, entity *CustomField, removedOptionIDs []string) error {
+	err := repo.sqlRepo.Store(ctx, entity, removedOptionIDs)
+	if err != nil {
+		return err
+	}
+	invalidate(ctx, repo.cache, repo.cache.Key(cachedKey, entity.BoardID))
+	return nil
+}
+
+func (repo *cachedCustomFieldRepository) StorePositions(ctx context.Context, fields []CustomField) error {
+	err := repo.sqlRepo.StorePositions(ctx, fields)
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for _, f := range fields {
+		keys = append(keys, repo.cache.Key(cachedKey, f.BoardID))
+	}
+	invalidate(ctx, repo.cache, keys...)
+	return nil
+}
+
+func (repo *cachedCustomFieldRepository) Delete(ctx context.Context, id string) error {
+	field, err := repo.sqlRepo.ResolveByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	err = repo.sqlRepo.Delete(ctx, id)
+	if err != nil {
+		return err
+	}
+	invalidate(ctx, repo.cache, repo.cache.Key(cachedKey, field.BoardID))
+	return nil
+}
+
+func (repo *cachedCustomFieldRepository) ResolveByID(ctx context.Context, id string) (*CustomField, error) {
+	return repo.sqlRepo.ResolveByID(ctx, id)
+}
+
+func (repo *cachedCustomFieldRepository) ResolveAllByBoardID(ctx context.Context, boardID string) ([]CustomField, error) {
+	return repo.sqlRepo.ResolveAllByBoardID(ctx, boardID)
+}
+
+// invalidate deletes the keys once the transaction of ctx commits, a read in
+// between would otherwise cache the rows again before the commit.
+func invalidate(ctx context.Context, c *cache.Cache, keys ...string) {
+	database.AfterCommit(ctx, func() {
+		c.Del(ctx, keys...)
+	})
+}
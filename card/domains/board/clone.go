@@ -0,0 +1,193 @@
+package board
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// CloneMapping maps the IDs of a source board to the IDs of its clone, so
+// references held by other domains can be rewritten.
+type CloneMapping struct {
+	SourceBoardID string            `json:"source_board_id"`
+	BoardID       string            `json:"board_id"`
+	Lists         map[string]string `json:"lists"`
+	Labels        map[string]string `json:"labels"`
+	CustomFields  map[string]string `json:"custom_fields"`
+	Options       map[string]string `json:"options"`
+}
+
+// CardCopier copies the cards and the card templates of the source board into
+// its clone. It's implemented by the card domain and runs inside the clone
+// transaction, which ctx carries.
+type CardCopier interface {
+	CopyCards(ctx context.Context, mapping CloneMapping) error
+	CopyTemplates(ctx context.Context, mapping CloneMapping) error
+}
+
+// Clone is everything written when a board is cloned. Template is set when
+// the clone is the snapshot of a published template. The card templates of
+// the board always come along, its cards only with IncludeCards.
+type Clone struct {
+	Board        *Board
+	Mapping      CloneMapping
+	Template     *Template
+	IncludeCards bool
+}
+
+type CloneInput struct {
+	Title string `json:"title" validate:"required"`
+	// Code defaults to one derived from the title.
+	Code         string `json:"code"`
+	IncludeCards bool   `json:"include_cards"`
+}
+
+// Clone copies the board's members, lists, labels and custom fields with new
+// IDs. Watchers and archived lists stay with the source board, and the code
+// and the lists' public IDs are left for the service to generate.
+func (b Board) Clone(title string) (*Board, CloneMapping, error) {
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return nil, CloneMapping{}, errors.WithStack(err)
+	}
+	now := time.Now()
+	res := &Board{
+		ID:        id.String(),
+		Title:     title,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	mapping := CloneMapping{
+		SourceBoardID: b.ID,
+		BoardID:       res.ID,
+		Lists:         make(map[string]string, 0),
+		Labels:        make(map[string]string, 0),
+		CustomFields:  make(map[string]string, 0),
+		Options:       make(map[string]string, 0),
+	}
+	for _, m := range b.Members {
+		member, err := NewBoardMember(res.ID, m.UserID)
+		if err != nil {
+			return nil, CloneMapping{}, errors.WithStack(err)
+		}
+		res.Members = append(res.Members, member)
+	}
+	for _, l := range b.Lists {
+		if l.DeletedAt != nil || l.IsArchived() {
+			continue
+		}
+		list, err := NewBoardList(res.ID, ListInput{Title: l.Title, Position: l.Position})
+		if err != nil {
+			return nil, CloneMapping{}, errors.WithStack(err)
+		}
+		list.WipLimit = l.WipLimit
+		list.WipLimitMode = l.WipLimitMode
+		mapping.Lists[l.ID] = list.ID
+		res.Lists = append(res.Lists, list)
+	}
+	for _, l := range b.Labels {
+		labelID, err := uuid.NewUUID()
+		if err != nil {
+			return nil, CloneMapping{}, errors.WithStack(err)
+		}
+		label := l
+		label.ID = labelID.String()
+		label.BoardID = res.ID
+		label.CreatedAt = now
+		label.UpdatedAt = now
+		mapping.Labels[l.ID] = label.ID
+		res.Labels = append(res.Labels, label)
+	}
+	for _, f := range b.CustomFields {
+		fieldID, err := uuid.NewUUID()
+		if err != nil {
+			return nil, CloneMapping{}, errors.WithStack(err)
+		}
+		field := f
+		field.ID = fieldID.String()
+		field.BoardID = res.ID
+		field.Options = nil
+		field.CreatedAt = now
+		field.UpdatedAt = now
+		for _, o := range f.Options {
+			optionID, err := uuid.NewUUID()
+			if err != nil {
+				return nil, CloneMapping{}, errors.WithStack(err)
+			}
+			option := o
+			option.ID = optionID.String()
+			option.FieldID = field.ID
+			option.CreatedAt = now
+			mapping.Options[o.ID] = option.ID
+			field.Options = append(field.Options, option)
+		}
+		mapping.CustomFields[f.ID] = field.ID
+		res.CustomFields = append(res.CustomFields, field)
+	}
+	return res, mapping, nil
+}
+
+// LabelMappingTo maps the board's label IDs to the IDs of the target board's
+// labels with the same slug. Labels without a counterpart are left out.
+func (b Board) LabelMappingTo(target Board) map[string]string {
+	res := make(map[string]string, 0)
+	if b.ID == target.ID {
+		for _, l := range b.Labels {
+			res[l.ID] = l.ID
+		}
+		return res
+	}
+	slugs := make(map[string]string, 0)
+	for _, l := range target.Labels {
+		slugs[l.Slug] = l.ID
+	}
+	for _, l := range b.Labels {
+		if id, exist := slugs[l.Slug]; exist {
+			res[l.ID] = id
+		}
+	}
+	return res
+}
+
+// Template is a published board that new boards can be created from. Its
+// lists, labels, custom fields and card templates live on a snapshot board
+// flagged as template, so later changes to the source board don't leak into it.
+type Template struct {
+	ID          string    `json:"entity_id" db:"entity_id"`
+	BoardID     string    `json:"board_id" db:"board_id"`
+	Name        string    `json:"name" db:"name"`
+	Description string    `json:"description" db:"description"`
+	CreatedBy   string    `json:"created_by" db:"created_by"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+type TemplateInput struct {
+	BoardID     string `json:"board_id" validate:"required"`
+	Name        string `json:"name" validate:"required,max=100"`
+	Description string `json:"description"`
+	UserID      string `json:"user_id" validate:"required"`
+}
+
+func (input TemplateInput) ToEntity(snapshotBoardID string) (*Template, error) {
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &Template{
+		ID:          id.String(),
+		BoardID:     snapshotBoardID,
+		Name:        input.Name,
+		Description: input.Description,
+		CreatedBy:   input.UserID,
+		CreatedAt:   time.Now(),
+	}, nil
+}
+
+type TemplateBoardInput struct {
+	Title string `json:"title" validate:"required"`
+	// Code defaults to one derived from the title.
+	Code    string        `json:"code"`
+	Members []MemberInput `json:"members" validate:"dive"`
+}
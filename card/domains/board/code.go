@@ -0,0 +1,46 @@
+package board
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/rakateja/milo/twirp-rpc-examples/card/apierror"
+)
+
+// codePattern is the shape of a board code, the prefix of its card keys.
+var codePattern = regexp.MustCompile(`^[A-Z][A-Z0-9]{1,9}$`)
+
+// ValidateCode checks a user-chosen code and returns it uppercased.
+func ValidateCode(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if !codePattern.MatchString(code) {
+		return "", apierror.WithDesc(ErrorCodeInvalidInput, "code must be 2 to 10 letters or digits, starting with a letter")
+	}
+	return code, nil
+}
+
+// DeriveCode derives a code from the board title: the initials of a title
+// with several words, the first letters of a single word.
+func DeriveCode(title string) string {
+	words := strings.FieldsFunc(strings.ToUpper(title), func(r rune) bool {
+		return !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	})
+	var code string
+	if len(words) > 1 {
+		for _, w := range words {
+			code += w[:1]
+		}
+	} else if len(words) == 1 {
+		code = words[0]
+	}
+	if len(code) > 4 {
+		code = code[:4]
+	}
+	if code == "" || code[0] < 'A' || code[0] > 'Z' {
+		code = "B" + code
+	}
+	if len(code) < 2 {
+		code += "B"
+	}
+	return code
+}
@@ -0,0 +1,522 @@
+package board
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/apierror"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/formula"
+)
+
+type FieldType string
+
+const (
+	FieldTypeText        FieldType = "text"
+	FieldTypeNumber      FieldType = "number"
+	FieldTypeDate        FieldType = "date"
+	FieldTypeCheckbox    FieldType = "checkbox"
+	FieldTypeSelect      FieldType = "select"
+	FieldTypeMultiSelect FieldType = "multi_select"
+	FieldTypeFormula     FieldType = "formula"
+)
+
+func (t FieldType) IsValid() bool {
+	switch t {
+	case FieldTypeText, FieldTypeNumber, FieldTypeDate, FieldTypeCheckbox, FieldTypeSelect, FieldTypeMultiSelect, FieldTypeFormula:
+		return true
+	}
+	return false
+}
+
+func (t FieldType) HasOptions() bool {
+	return t == FieldTypeSelect || t == FieldTypeMultiSelect
+}
+
+// FieldCards is implemented by the card domain, whose cached cards hold the
+// values of custom fields.
+type FieldCards interface {
+	// ForgetValues has the cards holding values of the field invalidated once
+	// the transaction of ctx commits. It's called before the values change.
+	ForgetValues(ctx context.Context, fieldID string) error
+}
+
+type CustomField struct {
+	ID        string              `json:"entity_id" db:"entity_id"`
+	BoardID   string              `json:"board_id" db:"board_id"`
+	Name      string              `json:"name" db:"name"`
+	Type      FieldType           `json:"type" db:"type"`
+	Position  int                 `json:"position" db:"position"`
+	Formula   string              `json:"formula" db:"formula"`
+	Options   []CustomFieldOption `json:"options"`
+	CreatedAt time.Time           `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time           `json:"updated_at" db:"updated_at"`
+}
+
+// Key is the identifier formulas use to reference the field.
+func (f CustomField) Key() string {
+	return formula.Key(f.Name)
+}
+
+func (f CustomField) OptionExist(optionID string) bool {
+	for _, o := range f.Options {
+		if o.ID == optionID {
+			return true
+		}
+	}
+	return false
+}
+
+// Update renames the field and replaces its options. Options without an ID
+// are created, and the IDs of options that are no longer present are returned
+// so card values referencing them can be cleaned up.
+func (f *CustomField) Update(input CustomFieldUpdateInput) (removedOptionIDs []string, err error) {
+	if err = validator.New().Struct(input); err != nil {
+		return nil, apierror.WithDesc(ErrorCodeInvalidInput, err.Error())
+	}
+	if !f.Type.HasOptions() && len(input.Options) > 0 {
+		return nil, apierror.WithDesc(ErrorCodeInvalidInput, "options are only supported by select fields")
+	}
+	if err = validateFormulaInput(f.Type, input.Formula); err != nil {
+		return nil, err
+	}
+	currentOptions := make(map[string]CustomFieldOption, 0)
+	for _, o := range f.Options {
+		currentOptions[o.ID] = o
+	}
+	now := time.Now()
+	keptOptions := make(map[string]bool, 0)
+	updatedOptions := make([]CustomFieldOption, 0)
+	for i, o := range input.Options {
+		if o.ID == "" {
+			option, err := o.ToEntity(f.ID, i+1)
+			if err != nil {
+				return nil, err
+			}
+			updatedOptions = append(updatedOptions, option)
+			continue
+		}
+		option, exist := currentOptions[o.ID]
+		if !exist {
+			return nil, apierror.WithDesc(ErrorCodeEntityNotFound, "custom field option couldn't be found")
+		}
+		option.Title = o.Title
+		option.Color = o.Color
+		option.Position = i + 1
+		updatedOptions = append(updatedOptions, option)
+		keptOptions[o.ID] = true
+	}
+	for _, o := range f.Options {
+		if !keptOptions[o.ID] {
+			removedOptionIDs = append(removedOptionIDs, o.ID)
+		}
+	}
+	f.Name = input.Name
+	f.Formula = input.Formula
+	f.Options = updatedOptions
+	f.UpdatedAt = now
+	return removedOptionIDs, nil
+}
+
+// ParseValue validates a raw value against the field definition. An empty
+// value and no option IDs yields an empty FieldValue, which clears the field.
+func (f CustomField) ParseValue(value string, optionIDs []string) (FieldValue, error) {
+	var res FieldValue
+	value = strings.TrimSpace(value)
+	if value == "" && len(optionIDs) == 0 {
+		return res, nil
+	}
+	invalidValue := func(format string) error {
+		return apierror.WithDesc(ErrorCodeInvalidInput, fmt.Sprintf("custom field %q expects %s", f.Name, format))
+	}
+	switch f.Type {
+	case FieldTypeFormula:
+		return res, apierror.WithDesc(ErrorCodeInvalidInput, fmt.Sprintf("custom field %q is computed by its formula", f.Name))
+	case FieldTypeText:
+		res.Text = &value
+	case FieldTypeNumber:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return res, invalidValue("a number")
+		}
+		res.Number = &number
+	case FieldTypeDate:
+		date, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			date, err = time.Parse("2006-01-02", value)
+		}
+		if err != nil {
+			return res, invalidValue("an RFC3339 date")
+		}
+		res.Date = &date
+	case FieldTypeCheckbox:
+		checked, err := strconv.ParseBool(value)
+		if err != nil {
+			return res, invalidValue("true or false")
+		}
+		res.Checked = &checked
+	case FieldTypeSelect, FieldTypeMultiSelect:
+		if value != "" {
+			optionIDs = append([]string{value}, optionIDs...)
+		}
+		seen := make(map[string]bool, 0)
+		for _, optionID := range optionIDs {
+			if seen[optionID] {
+				continue
+			}
+			if !f.OptionExist(optionID) {
+				return res, invalidValue("one of its options")
+			}
+			seen[optionID] = true
+			res.OptionIDs = append(res.OptionIDs, optionID)
+		}
+		if f.Type == FieldTypeSelect && len(res.OptionIDs) > 1 {
+			return res, invalidValue("a single option")
+		}
+	}
+	return res, nil
+}
+
+func validateFormulaInput(fieldType FieldType, src string) error {
+	if fieldType != FieldTypeFormula {
+		if src != "" {
+			return apierror.WithDesc(ErrorCodeInvalidInput, "only formula fields have a formula")
+		}
+		return nil
+	}
+	if _, err := formula.Parse(src); err != nil {
+		return apierror.WithDesc(ErrorCodeInvalidInput, "invalid formula: "+err.Error())
+	}
+	return nil
+}
+
+// ValidateCustomFields checks the custom fields of a board as a whole: keys
+// must be valid and unique, and formulas may only reference existing fields
+// without forming a cycle.
+func ValidateCustomFields(fields []CustomField) error {
+	byKey := make(map[string]CustomField, 0)
+	for _, f := range fields {
+		key := f.Key()
+		if !formula.ValidKey(key) {
+			return apierror.WithDesc(ErrorCodeInvalidInput, fmt.Sprintf("custom field name %q can't be referenced by formulas", f.Name))
+		}
+		if other, exist := byKey[key]; exist {
+			if other.Name == f.Name {
+				return apierror.WithDesc(ErrorCodeInvalidInput, fmt.Sprintf("custom field %q already exists", f.Name))
+			}
+			return apierror.WithDesc(ErrorCodeInvalidInput, fmt.Sprintf("custom fields %q and %q have the same formula key %q", other.Name, f.Name, key))
+		}
+		byKey[key] = f
+	}
+	references := make(map[string][]string, 0)
+	for _, f := range fields {
+		if f.Type != FieldTypeFormula {
+			continue
+		}
+		expr, err := formula.Parse(f.Formula)
+		if err != nil {
+			return apierror.WithDesc(ErrorCodeInvalidInput, fmt.Sprintf("invalid formula of %q: %s", f.Name, err.Error()))
+		}
+		for _, key := range expr.References() {
+			if _, exist := byKey[key]; !exist {
+				return apierror.WithDesc(ErrorCodeInvalidInput, fmt.Sprintf("formula of %q references unknown field %q", f.Name, key))
+			}
+		}
+		references[f.Key()] = expr.References()
+	}
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, 0)
+	var visit func(key string, path []string) error
+	visit = func(key string, path []string) error {
+		switch state[key] {
+		case visiting:
+			return apierror.WithDesc(ErrorCodeInvalidInput, "formula cycle: "+strings.Join(append(path, key), " -> "))
+		case visited:
+			return nil
+		}
+		state[key] = visiting
+		for _, ref := range references[key] {
+			if err := visit(ref, append(path, key)); err != nil {
+				return err
+			}
+		}
+		state[key] = visited
+		return nil
+	}
+	for _, f := range fields {
+		if err := visit(f.Key(), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EvaluateFormulas computes the formula fields of a card from its other
+// values. values and both results are keyed by field ID. Formulas that
+// evaluate to nothing are left out, the ones that fail, e.g. on a division by
+// zero or by reading a formula that failed, are in the errors instead.
+func EvaluateFormulas(fields []CustomField, values map[string]FieldValue) (map[string]FieldValue, map[string]error) {
+	byKey := make(map[string]CustomField, 0)
+	for _, f := range fields {
+		byKey[f.Key()] = f
+	}
+	res := make(map[string]FieldValue, 0)
+	errs := make(map[string]error, 0)
+	computed := make(map[string]interface{}, 0)
+	inProgress := make(map[string]bool, 0)
+	var evaluate func(f CustomField) (interface{}, error)
+	evaluate = func(f CustomField) (interface{}, error) {
+		if v, done := computed[f.ID]; done {
+			return v, errs[f.ID]
+		}
+		if inProgress[f.ID] {
+			// cycles are rejected by ValidateCustomFields, this only guards
+			// against definitions stored before that check
+			return nil, fmt.Errorf("formula cycle through %q", f.Name)
+		}
+		inProgress[f.ID] = true
+		defer delete(inProgress, f.ID)
+		result, err := evaluateFormula(f, byKey, values, evaluate)
+		if err != nil {
+			result = nil
+			errs[f.ID] = err
+		}
+		computed[f.ID] = result
+		return result, err
+	}
+	for _, f := range fields {
+		if f.Type != FieldTypeFormula {
+			continue
+		}
+		result, err := evaluate(f)
+		if err != nil {
+			continue
+		}
+		var value FieldValue
+		switch v := result.(type) {
+		case float64:
+			value.Number = &v
+		case string:
+			value.Text = &v
+		case bool:
+			value.Checked = &v
+		case time.Time:
+			value.Date = &v
+		}
+		if !value.IsEmpty() {
+			res[f.ID] = value
+		}
+	}
+	return res, errs
+}
+
+func evaluateFormula(f CustomField, byKey map[string]CustomField, values map[string]FieldValue, evaluate func(f CustomField) (interface{}, error)) (interface{}, error) {
+	expr, err := formula.Parse(f.Formula)
+	if err != nil {
+		return nil, err
+	}
+	var refErr error
+	result, err := expr.Eval(func(key string) (interface{}, bool) {
+		ref, exist := byKey[key]
+		if !exist {
+			return nil, false
+		}
+		if ref.Type != FieldTypeFormula {
+			return ref.formulaValue(values[ref.ID]), true
+		}
+		v, err := evaluate(ref)
+		if err != nil && refErr == nil {
+			refErr = fmt.Errorf("%q can't be computed", ref.Name)
+		}
+		return v, true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if refErr != nil {
+		return nil, refErr
+	}
+	if v, ok := result.(float64); ok && (math.IsNaN(v) || math.IsInf(v, 0)) {
+		return nil, errors.New("result isn't a finite number")
+	}
+	return result, nil
+}
+
+// formulaValue converts a stored value into what formulas operate on. Select
+// fields evaluate to the titles of the chosen options.
+func (f CustomField) formulaValue(v FieldValue) interface{} {
+	switch f.Type {
+	case FieldTypeText:
+		if v.Text != nil {
+			return *v.Text
+		}
+	case FieldTypeNumber:
+		if v.Number != nil {
+			return *v.Number
+		}
+	case FieldTypeDate:
+		if v.Date != nil {
+			return *v.Date
+		}
+	case FieldTypeCheckbox:
+		return v.Checked != nil && *v.Checked
+	case FieldTypeSelect, FieldTypeMultiSelect:
+		if len(v.OptionIDs) == 0 {
+			return nil
+		}
+		var titles []string
+		for _, optionID := range v.OptionIDs {
+			for _, o := range f.Options {
+				if o.ID == optionID {
+					titles = append(titles, o.Title)
+				}
+			}
+		}
+		return strings.Join(titles, ", ")
+	}
+	return nil
+}
+
+type CustomFieldOption struct {
+	ID        string    `json:"entity_id" db:"entity_id"`
+	FieldID   string    `json:"field_id" db:"field_id"`
+	Title     string    `json:"title" db:"title"`
+	Color     string    `json:"color" db:"color"`
+	Position  int       `json:"position" db:"position"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// FieldValue holds a card's value for a custom field. Only the member
+// matching the field type is set.
+type FieldValue struct {
+	Text      *string    `json:"text" db:"value_text"`
+	Number    *float64   `json:"number" db:"value_number"`
+	Date      *time.Time `json:"date" db:"value_date"`
+	Checked   *bool      `json:"checked" db:"value_checked"`
+	OptionIDs OptionIDs  `json:"option_ids" db:"option_ids"`
+}
+
+func (v FieldValue) IsEmpty() bool {
+	return v.Text == nil && v.Number == nil && v.Date == nil && v.Checked == nil && len(v.OptionIDs) == 0
+}
+
+// OptionIDs is stored as a comma separated column so it can be matched with
+// FIND_IN_SET.
+type OptionIDs []string
+
+func (ids OptionIDs) Value() (driver.Value, error) {
+	return strings.Join(ids, ","), nil
+}
+
+func (ids *OptionIDs) Scan(src interface{}) error {
+	var str string
+	switch v := src.(type) {
+	case nil:
+	case string:
+		str = v
+	case []byte:
+		str = string(v)
+	default:
+		return errors.Errorf("unsupported option ids type %T", src)
+	}
+	*ids = nil
+	if str == "" {
+		return nil
+	}
+	*ids = strings.Split(str, ",")
+	return nil
+}
+
+func (ids OptionIDs) Without(optionID string) OptionIDs {
+	var res OptionIDs
+	for _, id := range ids {
+		if id != optionID {
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+type CustomFieldOptionInput struct {
+	ID    string `json:"entity_id"`
+	Title string `json:"title" validate:"required"`
+	Color string `json:"color"`
+}
+
+func (o CustomFieldOptionInput) ToEntity(fieldID string, position int) (CustomFieldOption, error) {
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return CustomFieldOption{}, err
+	}
+	return CustomFieldOption{
+		ID:        id.String(),
+		FieldID:   fieldID,
+		Title:     o.Title,
+		Color:     o.Color,
+		Position:  position,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+type CustomFieldInput struct {
+	Name    string                   `json:"name" validate:"required"`
+	Type    FieldType                `json:"type" validate:"required"`
+	Formula string                   `json:"formula"`
+	Options []CustomFieldOptionInput `json:"options" validate:"dive"`
+}
+
+func (t CustomFieldInput) ToEntity(boardID string, position int) (*CustomField, error) {
+	if err := validator.New().Struct(t); err != nil {
+		return nil, apierror.WithDesc(ErrorCodeInvalidInput, err.Error())
+	}
+	if !t.Type.IsValid() {
+		return nil, apierror.WithDesc(ErrorCodeInvalidInput, "unknown custom field type")
+	}
+	if !t.Type.HasOptions() && len(t.Options) > 0 {
+		return nil, apierror.WithDesc(ErrorCodeInvalidInput, "options are only supported by select fields")
+	}
+	if err := validateFormulaInput(t.Type, t.Formula); err != nil {
+		return nil, err
+	}
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	options := make([]CustomFieldOption, 0)
+	for i, o := range t.Options {
+		option, err := o.ToEntity(id.String(), i+1)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, option)
+	}
+	return &CustomField{
+		ID:        id.String(),
+		BoardID:   boardID,
+		Name:      t.Name,
+		Type:      t.Type,
+		Position:  position,
+		Formula:   t.Formula,
+		Options:   options,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}, nil
+}
+
+type CustomFieldUpdateInput struct {
+	Name    string                   `json:"name" validate:"required"`
+	Formula string                   `json:"formula"`
+	Options []CustomFieldOptionInput `json:"options" validate:"dive"`
+}
@@ -0,0 +1,296 @@
+package board
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/apierror"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/database"
+)
+
+type CustomFieldRepository interface {
+	Store(ctx context.Context, entity *CustomField, removedOptionIDs []string) error
+	StorePositions(ctx context.Context, fields []CustomField) error
+	Delete(ctx context.Context, id string) error
+	ResolveByID(ctx context.Context, id string) (*CustomField, error)
+	ResolveAllByBoardID(ctx context.Context, boardID string) ([]CustomField, error)
+}
+
+type CustomFieldSQLRepository struct {
+	db *database.MySQL
+}
+
+func NewCustomFieldSQLRepository(db *database.MySQL) CustomFieldRepository {
+	return &CustomFieldSQLRepository{db: db}
+}
+
+const (
+	insertCustomFieldQuery = `
+		INSERT INTO custom_field (
+			entity_id,
+			board_id,
+			name,
+			type,
+			position,
+			formula,
+			created_at,
+			updated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	insertCustomFieldsQuery = `
+		INSERT INTO custom_field (
+			entity_id,
+			board_id,
+			name,
+			type,
+			position,
+			formula,
+			created_at,
+			updated_at
+		) VALUES %s
+	`
+	updateCustomFieldQuery = `
+		UPDATE custom_field SET
+			name = ?,
+			position = ?,
+			formula = ?,
+			updated_at = ?
+		WHERE entity_id = ?
+	`
+	selectCustomFieldQuery = `
+		SELECT
+			entity_id,
+			board_id,
+			name,
+			type,
+			position,
+			formula,
+			created_at,
+			updated_at
+		FROM custom_field
+	`
+	countCustomFieldQuery = `
+		SELECT COUNT(entity_id) FROM custom_field
+	`
+	deleteCustomFieldQuery = `
+		DELETE FROM custom_field
+	`
+	insertCustomFieldOptionQuery = `
+		INSERT INTO custom_field_option (
+			entity_id,
+			field_id,
+			title,
+			color,
+			position,
+			created_at
+		) VALUES %s
+	`
+	selectCustomFieldOptionQuery = `
+		SELECT
+			entity_id,
+			field_id,
+			title,
+			color,
+			position,
+			created_at
+		FROM custom_field_option
+	`
+	deleteCustomFieldOptionQuery = `
+		DELETE FROM custom_field_option
+	`
+	// card values live in the card domain, but they must go away together
+	// with the definitions they reference.
+	deleteCustomFieldValueQuery = `
+		DELETE FROM card_custom_field_value
+	`
+	removeCustomFieldValueOptionQuery = `
+		UPDATE card_custom_field_value SET
+			option_ids = TRIM(BOTH ',' FROM REPLACE(CONCAT(',', option_ids, ','), CONCAT(',', ?, ','), ','))
+		WHERE field_id = ? AND FIND_IN_SET(?, option_ids)
+	`
+)
+
+func (repo *CustomFieldSQLRepository) Store(ctx context.Context, entity *CustomField, removedOptionIDs []string) error {
+	exist, err := repo.existByID(ctx, entity.ID)
+	if err != nil {
+		return errors.Wrap(err, "exist custom field by id")
+	}
+	return repo.db.Transaction(ctx, func(ctx context.Context) error {
+		if exist {
+			err = repo.update(ctx, entity)
+		} else {
+			err = repo.insert(ctx, entity)
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		_, err = repo.db.ExecContext(ctx, deleteCustomFieldOptionQuery+" WHERE field_id = ?", entity.ID)
+		if err != nil {
+			return errors.Wrap(err, "delete custom field options")
+		}
+		err = repo.insertOptions(ctx, entity.Options)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		for _, optionID := range removedOptionIDs {
+			_, err = repo.db.ExecContext(ctx, removeCustomFieldValueOptionQuery, optionID, entity.ID, optionID)
+			if err != nil {
+				return errors.Wrap(err, "remove option from custom field values")
+			}
+		}
+		if len(removedOptionIDs) > 0 {
+			_, err = repo.db.ExecContext(ctx, deleteCustomFieldValueQuery+" WHERE field_id = ? AND option_ids = ''", entity.ID)
+			if err != nil {
+				return errors.Wrap(err, "delete empty custom field values")
+			}
+		}
+		return nil
+	})
+}
+
+func (repo *CustomFieldSQLRepository) StorePositions(ctx context.Context, fields []CustomField) error {
+	return repo.db.Transaction(ctx, func(ctx context.Context) error {
+		for i := range fields {
+			err := repo.update(ctx, &fields[i])
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		return nil
+	})
+}
+
+func (repo *CustomFieldSQLRepository) Delete(ctx context.Context, id string) error {
+	return repo.db.Transaction(ctx, func(ctx context.Context) error {
+		_, err := repo.db.ExecContext(ctx, deleteCustomFieldValueQuery+" WHERE field_id = ?", id)
+		if err != nil {
+			return errors.Wrap(err, "delete custom field values")
+		}
+		_, err = repo.db.ExecContext(ctx, deleteCustomFieldOptionQuery+" WHERE field_id = ?", id)
+		if err != nil {
+			return errors.Wrap(err, "delete custom field options")
+		}
+		_, err = repo.db.ExecContext(ctx, deleteCustomFieldQuery+" WHERE entity_id = ?", id)
+		if err != nil {
+			return errors.Wrap(err, "delete custom field")
+		}
+		return nil
+	})
+}
+
+func (repo *CustomFieldSQLRepository) ResolveByID(ctx context.Context, id string) (*CustomField, error) {
+	var res CustomField
+	err := repo.db.GetContext(ctx, &res, selectCustomFieldQuery+" WHERE entity_id = ?", id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, apierror.WithDesc(ErrorCodeEntityNotFound, "custom field couldn't be found")
+		}
+		return nil, errors.Wrap(err, "select custom field by id")
+	}
+	err = repo.db.SelectContext(ctx, &res.Options, selectCustomFieldOptionQuery+" WHERE field_id = ? ORDER BY position", id)
+	if err != nil {
+		return nil, errors.Wrap(err, "select custom field option by field id")
+	}
+	return &res, nil
+}
+
+func (repo *CustomFieldSQLRepository) ResolveAllByBoardID(ctx context.Context, boardID string) ([]CustomField, error) {
+	return repo.resolveAllByBoardIDs(ctx, []string{boardID})
+}
+
+func (repo *CustomFieldSQLRepository) resolveAllByBoardIDs(ctx context.Context, boardIDs []string) ([]CustomField, error) {
+	query, args, err := repo.db.In(selectCustomFieldQuery+" WHERE board_id IN (:board_id) ORDER BY position", map[string]interface{}{
+		"board_id": boardIDs,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var res []CustomField
+	err = repo.db.SelectContext(ctx, &res, repo.db.Rebind(query), args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "select custom field by board id")
+	}
+	if len(res) == 0 {
+		return res, nil
+	}
+	var fieldIDs []string
+	for _, f := range res {
+		fieldIDs = append(fieldIDs, f.ID)
+	}
+	query, args, err = repo.db.In(selectCustomFieldOptionQuery+" WHERE field_id IN (:field_id) ORDER BY position", map[string]interface{}{
+		"field_id": fieldIDs,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var options []CustomFieldOption
+	err = repo.db.SelectContext(ctx, &options, repo.db.Rebind(query), args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "select custom field option by field ids")
+	}
+	optionsMap := make(map[string][]CustomFieldOption, 0)
+	for _, o := range options {
+		optionsMap[o.FieldID] = append(optionsMap[o.FieldID], o)
+	}
+	for i := range res {
+		res[i].Options = optionsMap[res[i].ID]
+	}
+	return res, nil
+}
+
+func (repo *CustomFieldSQLRepository) existByID(ctx context.Context, id string) (bool, error) {
+	var total int
+	err := repo.db.GetContext(ctx, &total, countCustomFieldQuery+" WHERE entity_id = ?", id)
+	if err != nil {
+		return false, errors.Wrap(err, "count custom field by id")
+	}
+	return total > 0, nil
+}
+
+func (repo *CustomFieldSQLRepository) insert(ctx context.Context, entity *CustomField) error {
+	_, err := repo.db.ExecContext(ctx, insertCustomFieldQuery,
+		entity.ID,
+		entity.BoardID,
+		entity.Name,
+		entity.Type,
+		entity.Position,
+		entity.Formula,
+		entity.CreatedAt,
+		entity.UpdatedAt,
+	)
+	if err != nil {
+		return errors.Wrap(err, "insert custom field")
+	}
+	return nil
+}
+
+func (repo *CustomFieldSQLRepository) insertAll(ctx context.Context, fields []CustomField) error {
+	rows := make([][]interface{}, 0, len(fields))
+	for _, f := range fields {
+		rows = append(rows, []interface{}{f.ID, f.BoardID, f.Name, f.Type, f.Position, f.Formula, f.CreatedAt, f.UpdatedAt})
+	}
+	return errors.Wrap(repo.db.BulkExec(ctx, insertCustomFieldsQuery, rows), "insert custom fields")
+}
+
+func (repo *CustomFieldSQLRepository) insertOptions(ctx context.Context, options []CustomFieldOption) error {
+	rows := make([][]interface{}, 0, len(options))
+	for _, o := range options {
+		rows = append(rows, []interface{}{o.ID, o.FieldID, o.Title, o.Color, o.Position, o.CreatedAt})
+	}
+	return errors.Wrap(repo.db.BulkExec(ctx, insertCustomFieldOptionQuery, rows), "insert custom field options")
+}
+
+func (repo *CustomFieldSQLRepository) update(ctx context.Context, entity *CustomField) error {
+	_, err := repo.db.ExecContext(ctx, updateCustomFieldQuery,
+		entity.Name,
+		entity.Position,
+		entity.Formula,
+		entity.UpdatedAt,
+		entity.ID,
+	)
+	if err != nil {
+		return errors.Wrap(err, "update custom field")
+	}
+	return nil
+}
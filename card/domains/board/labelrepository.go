@@ -3,7 +3,6 @@ package board
 import (
 	"context"
 
-	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	"github.com/rakateja/milo/twirp-rpc-examples/card/database"
 )
@@ -36,6 +35,17 @@ const (
 			updated_at
 		) VALUES (?, ?, ?, ?, ?, ?, ?)
 	`
+	insertLabelsQuery = `
+		INSERT INTO label (
+			entity_id,
+			board_id,
+			slug,
+			title,
+			color,
+			created_at,
+			updated_at
+		) VALUES %s
+	`
 	updateLabelQuery = `
 		UPDATE label SET
 			board_id = ?,
@@ -67,17 +77,17 @@ func (repo *LabelSQLRepository) Store(ctx context.Context, entity *Label) error
 	if err != nil {
 		return errors.Wrap(err, "exist label by id")
 	}
-	return repo.db.WithTransaction(func(tx *sqlx.Tx) error {
+	return repo.db.Transaction(ctx, func(ctx context.Context) error {
 		if exist {
-			return repo.update(tx, entity)
+			return repo.update(ctx, entity)
 		}
-		return repo.insert(tx, entity)
+		return repo.insert(ctx, entity)
 	})
 }
 
 func (repo *LabelSQLRepository) ResolveByID(ctx context.Context, id string) (*Label, error) {
 	var res Label
-	err := repo.db.Get(&res, selectLabelQuery+" WHERE entity_id = ?", id)
+	err := repo.db.GetContext(ctx, &res, selectLabelQuery+" WHERE entity_id = ?", id)
 	if err != nil {
 		return nil, errors.Wrap(err, "select label by id")
 	}
@@ -89,8 +99,18 @@ func (repo *LabelSQLRepository) ExistByID(ctx context.Context, id string) (bool,
 }
 
 func (repo *LabelSQLRepository) ResolveAllByBoardID(ctx context.Context, boardID string) ([]Label, error) {
+	return repo.resolveAllByBoardIDs(ctx, []string{boardID})
+}
+
+func (repo *LabelSQLRepository) resolveAllByBoardIDs(ctx context.Context, boardIDs []string) ([]Label, error) {
+	query, args, err := repo.db.In(selectLabelQuery+" WHERE board_id IN (:board_id)", map[string]interface{}{
+		"board_id": boardIDs,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 	var res []Label
-	err := repo.db.Select(&res, selectLabelQuery+" WHERE board_id = ?", boardID)
+	err = repo.db.SelectContext(ctx, &res, repo.db.Rebind(query), args...)
 	if err != nil {
 		return nil, errors.Wrap(err, "select label by board id")
 	}
@@ -98,7 +118,7 @@ func (repo *LabelSQLRepository) ResolveAllByBoardID(ctx context.Context, boardID
 }
 
 func (repo *LabelSQLRepository) ResolveBySlug(ctx context.Context, slug string) (res *Label, err error) {
-	err = repo.db.Get(&res, selectLabelQuery+" WHERE slug = ?", slug)
+	err = repo.db.GetContext(ctx, &res, selectLabelQuery+" WHERE slug = ?", slug)
 	if err != nil {
 		err = errors.Wrap(err, "select label by slug")
 		return
@@ -108,15 +128,15 @@ func (repo *LabelSQLRepository) ResolveBySlug(ctx context.Context, slug string)
 
 func (repo *LabelSQLRepository) existByID(ctx context.Context, id string) (bool, error) {
 	var total int
-	err := repo.db.Get(&total, countLabelQuery+" WHERE entity_id = ?", id)
+	err := repo.db.GetContext(ctx, &total, countLabelQuery+" WHERE entity_id = ?", id)
 	if err != nil {
 		return false, errors.Wrap(err, "count label by id")
 	}
 	return total > 0, nil
 }
 
-func (repo *LabelSQLRepository) insert(tx *sqlx.Tx, entity *Label) error {
-	res, err := tx.Exec(insertLabelQuery,
+func (repo *LabelSQLRepository) insert(ctx context.Context, entity *Label) error {
+	res, err := repo.db.ExecContext(ctx, insertLabelQuery,
 		entity.ID,
 		entity.BoardID,
 		entity.Slug,
@@ -138,8 +158,16 @@ func (repo *LabelSQLRepository) insert(tx *sqlx.Tx, entity *Label) error {
 	return nil
 }
 
-func (repo *LabelSQLRepository) update(tx *sqlx.Tx, entity *Label) error {
-	_, err := tx.Exec(updateLabelQuery,
+func (repo *LabelSQLRepository) insertAll(ctx context.Context, labels []Label) error {
+	rows := make([][]interface{}, 0, len(labels))
+	for _, l := range labels {
+		rows = append(rows, []interface{}{l.ID, l.BoardID, l.Slug, l.Title, l.Color, l.CreatedAt, l.UpdatedAt})
+	}
+	return errors.Wrap(repo.db.BulkExec(ctx, insertLabelsQuery, rows), "insert labels")
+}
+
+func (repo *LabelSQLRepository) update(ctx context.Context, entity *Label) error {
+	_, err := repo.db.ExecContext(ctx, updateLabelQuery,
 		entity.BoardID,
 		entity.Slug,
 		entity.Title,
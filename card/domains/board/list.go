@@ -0,0 +1,256 @@
+package board
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/apierror"
+)
+
+// minListPositionGap is the smallest gap left between two list positions
+// before the board's lists are renumbered.
+const minListPositionGap = 1e-6
+
+// WipLimitMode decides what happens when a card would take a list over its
+// WIP limit: strict rejects the card, warn lets it through with a warning.
+type WipLimitMode string
+
+const (
+	WipLimitModeStrict WipLimitMode = "strict"
+	WipLimitModeWarn   WipLimitMode = "warn"
+)
+
+// ListCardPolicy decides what happens to the cards of a deleted list.
+type ListCardPolicy string
+
+const (
+	// ListCardPolicyNone refuses to delete a list that still has cards.
+	ListCardPolicyNone   ListCardPolicy = ""
+	ListCardPolicyMove   ListCardPolicy = "move"
+	ListCardPolicyDelete ListCardPolicy = "delete"
+)
+
+// ListCards handles the cards of a list that is moved to another board or
+// deleted. It's implemented by the card domain.
+type ListCards interface {
+	CountCards(ctx context.Context, listID string) (int, error)
+	MoveCards(ctx context.Context, listID string, target BoardList, targetBoard Board, labelMapping map[string]string) error
+	DeleteCards(ctx context.Context, listID string) error
+}
+
+type ListRenameInput struct {
+	ListID string `json:"list_id" validate:"required"`
+	Title  string `json:"title" validate:"required,max=50"`
+}
+
+// ListReorderInput places the list right after AfterListID, or first when
+// AfterListID is empty.
+type ListReorderInput struct {
+	ListID      string `json:"list_id" validate:"required"`
+	AfterListID string `json:"after_list_id"`
+}
+
+// ListWipLimitInput removes the list's limit when WipLimit is nil.
+type ListWipLimitInput struct {
+	ListID   string       `json:"list_id" validate:"required"`
+	WipLimit *int         `json:"wip_limit" validate:"omitempty,min=1"`
+	Mode     WipLimitMode `json:"mode" validate:"omitempty,oneof=strict warn"`
+}
+
+type ListArchiveInput struct {
+	ListID   string `json:"list_id" validate:"required"`
+	Archived bool   `json:"archived"`
+}
+
+type ListMoveInput struct {
+	ListID  string `json:"list_id" validate:"required"`
+	BoardID string `json:"board_id" validate:"required"`
+}
+
+// ListDeleteInput TargetListID is required by the move policy and may belong
+// to another board.
+type ListDeleteInput struct {
+	ListID       string         `json:"list_id" validate:"required"`
+	Policy       ListCardPolicy `json:"policy" validate:"omitempty,oneof=move delete"`
+	TargetListID string         `json:"target_list_id" validate:"required_if=Policy move"`
+}
+
+func (l BoardList) IsArchived() bool {
+	return l.ArchivedAt != nil
+}
+
+// ExceedsWipLimit reports whether incoming more cards take the list over its
+// WIP limit.
+func (l BoardList) ExceedsWipLimit(incoming int) bool {
+	return l.WipLimit != nil && incoming > 0 && l.CardCount+incoming > *l.WipLimit
+}
+
+func (b Board) ListByID(listID string) (BoardList, bool) {
+	for _, l := range b.Lists {
+		if l.ID == listID {
+			return l, true
+		}
+	}
+	return BoardList{}, false
+}
+
+// NextListPosition is the position after the board's last list.
+func (b Board) NextListPosition() float64 {
+	var last float64
+	for _, l := range b.Lists {
+		if l.Position > last {
+			last = l.Position
+		}
+	}
+	return last + 1
+}
+
+// AddList appends a new list to the board, after the last one unless the
+// input has a position.
+func (b *Board) AddList(input ListInput) (BoardList, error) {
+	if input.Position == 0 {
+		input.Position = b.NextListPosition()
+	}
+	list, err := NewBoardList(b.ID, input)
+	if err != nil {
+		return BoardList{}, errors.WithStack(err)
+	}
+	b.Lists = append(b.Lists, list)
+	sortLists(b.Lists)
+	return list, nil
+}
+
+func (b *Board) RenameList(listID, title string) (BoardList, error) {
+	for i := range b.Lists {
+		if b.Lists[i].ID == listID {
+			b.Lists[i].Title = title
+			b.Lists[i].UpdatedAt = time.Now()
+			return b.Lists[i], nil
+		}
+	}
+	return BoardList{}, apierror.WithDesc(ErrorCodeEntityNotFound, "board list couldn't be found")
+}
+
+func (b *Board) SetListWipLimit(input ListWipLimitInput) (BoardList, error) {
+	for i := range b.Lists {
+		if b.Lists[i].ID != input.ListID {
+			continue
+		}
+		b.Lists[i].WipLimit = input.WipLimit
+		if input.Mode != "" {
+			b.Lists[i].WipLimitMode = input.Mode
+		}
+		b.Lists[i].UpdatedAt = time.Now()
+		return b.Lists[i], nil
+	}
+	return BoardList{}, apierror.WithDesc(ErrorCodeEntityNotFound, "board list couldn't be found")
+}
+
+func (b *Board) ArchiveList(listID string, archived bool) (BoardList, error) {
+	for i := range b.Lists {
+		if b.Lists[i].ID != listID {
+			continue
+		}
+		now := time.Now()
+		b.Lists[i].ArchivedAt = nil
+		if archived {
+			b.Lists[i].ArchivedAt = &now
+		}
+		b.Lists[i].UpdatedAt = now
+		return b.Lists[i], nil
+	}
+	return BoardList{}, apierror.WithDesc(ErrorCodeEntityNotFound, "board list couldn't be found")
+}
+
+// ReorderList moves the list right after afterListID, or first when it's
+// empty. The list takes a position between its new neighbours, so usually
+// it's the only list that changes; when the gap gets too small every list is
+// renumbered. It returns the lists whose position changed.
+func (b *Board) ReorderList(listID, afterListID string) ([]BoardList, error) {
+	if listID == afterListID {
+		return nil, apierror.WithDesc(ErrorCodeInvalidInput, "a list can't be placed after itself")
+	}
+	sortLists(b.Lists)
+	moved := -1
+	others := make([]int, 0)
+	for i, l := range b.Lists {
+		if l.ID == listID {
+			moved = i
+			continue
+		}
+		others = append(others, i)
+	}
+	if moved < 0 {
+		return nil, apierror.WithDesc(ErrorCodeEntityNotFound, "board list couldn't be found")
+	}
+	// index in others of the list that ends up right after the moved one
+	next := 0
+	if afterListID != "" {
+		next = -1
+		for i, idx := range others {
+			if b.Lists[idx].ID == afterListID {
+				next = i + 1
+				break
+			}
+		}
+		if next < 0 {
+			return nil, apierror.WithDesc(ErrorCodeEntityNotFound, "board list couldn't be found")
+		}
+	}
+	var position float64
+	switch {
+	case len(others) == 0:
+		position = 1
+	case next == 0:
+		position = b.Lists[others[0]].Position / 2
+	case next == len(others):
+		position = b.Lists[others[len(others)-1]].Position + 1
+	default:
+		position = (b.Lists[others[next-1]].Position + b.Lists[others[next]].Position) / 2
+	}
+	now := time.Now()
+	gapTooSmall := next < len(others) && b.Lists[others[next]].Position-position < minListPositionGap
+	if !gapTooSmall {
+		b.Lists[moved].Position = position
+		b.Lists[moved].UpdatedAt = now
+		res := []BoardList{b.Lists[moved]}
+		sortLists(b.Lists)
+		return res, nil
+	}
+	ordered := make([]BoardList, 0, len(b.Lists))
+	for i, idx := range others {
+		if i == next {
+			ordered = append(ordered, b.Lists[moved])
+		}
+		ordered = append(ordered, b.Lists[idx])
+	}
+	if next == len(others) {
+		ordered = append(ordered, b.Lists[moved])
+	}
+	for i := range ordered {
+		ordered[i].Position = float64(i + 1)
+		ordered[i].UpdatedAt = now
+	}
+	b.Lists = ordered
+	return ordered, nil
+}
+
+// RemoveListWatchers drops the watchers subscribed to the list only.
+func (b *Board) RemoveListWatchers(listID string) {
+	updatedWatchers := make([]Watcher, 0)
+	for _, w := range b.Watchers {
+		if w.ListID == listID {
+			continue
+		}
+		updatedWatchers = append(updatedWatchers, w)
+	}
+	b.Watchers = updatedWatchers
+}
+
+func sortLists(ls []BoardList) {
+	sort.SliceStable(ls, func(i, j int) bool {
+		return ls[i].Position < ls[j].Position
+	})
+}
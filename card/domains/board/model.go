@@ -1,6 +1,7 @@
 package board
 
 import (
+	"fmt"
 	"strings"
 	"time"
 
@@ -10,6 +11,11 @@ import (
 
 const (
 	ErrorCodeEntityNotFound = "EntityNotFound"
+	ErrorCodeInvalidInput   = "InvalidInput"
+	ErrorCodeAlreadyExist   = "AlreadyExist"
+	// ErrorCodeWipLimitExceeded is returned when cards would take a list over
+	// its WIP limit.
+	ErrorCodeWipLimitExceeded = "WipLimitExceeded"
 )
 
 type Filter struct {
@@ -22,15 +28,18 @@ func (t Filter) IsEmpty() bool {
 }
 
 type Board struct {
-	ID        string        `json:"entity_id" db:"entity_id"`
-	Code      string        `json:"code" db:"code"`
-	Title     string        `json:"title" db:"title"`
-	Members   []BoardMember `json:"members"`
-	Lists     []BoardList   `json:"lists"`
-	Labels    []Label       `json:"labels"`
-	CreatedAt time.Time     `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time     `json:"updated_at" db:"updated_at"`
-	DeletedAt *time.Time    `json:"deleted_at" db:"deleted_at"`
+	ID           string        `json:"entity_id" db:"entity_id"`
+	Code         string        `json:"code" db:"code"`
+	Title        string        `json:"title" db:"title"`
+	Members      []BoardMember `json:"members"`
+	Lists        []BoardList   `json:"lists"`
+	Labels       []Label       `json:"labels"`
+	Watchers     []Watcher     `json:"watchers"`
+	CustomFields []CustomField `json:"custom_fields"`
+	IsTemplate   bool          `json:"is_template" db:"is_template"`
+	CreatedAt    time.Time     `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time     `json:"updated_at" db:"updated_at"`
+	DeletedAt    *time.Time    `json:"deleted_at" db:"deleted_at"`
 }
 
 func (b Board) HasAccess(userID string) bool {
@@ -60,6 +69,65 @@ func (b *Board) ListExist(listID string) bool {
 	return false
 }
 
+func (b Board) LabelExist(labelID string) bool {
+	for _, l := range b.Labels {
+		if l.ID == labelID {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch subscribes the user to the whole board, or to a single list of the
+// board when listID isn't empty.
+func (b *Board) Watch(userID, listID string) error {
+	if listID != "" && !b.ListExist(listID) {
+		return apierror.WithDesc(ErrorCodeEntityNotFound, "board list couldn't be found")
+	}
+	for _, w := range b.Watchers {
+		if w.UserID == userID && w.ListID == listID {
+			return apierror.New(ErrorCodeAlreadyExist)
+		}
+	}
+	watcher, err := NewWatcher(b.ID, listID, userID)
+	if err != nil {
+		return err
+	}
+	b.Watchers = append(b.Watchers, watcher)
+	return nil
+}
+
+func (b *Board) Unwatch(userID, listID string) {
+	updatedWatchers := make([]Watcher, 0)
+	for _, w := range b.Watchers {
+		if w.UserID == userID && w.ListID == listID {
+			continue
+		}
+		updatedWatchers = append(updatedWatchers, w)
+	}
+	b.Watchers = updatedWatchers
+}
+
+// WatcherUserIDs returns users watching the whole board or the given list.
+func (b Board) WatcherUserIDs(listID string) []string {
+	var res []string
+	for _, w := range b.Watchers {
+		if w.ListID == "" || w.ListID == listID {
+			res = append(res, w.UserID)
+		}
+	}
+	return res
+}
+
+func (b Board) CustomFieldByID(fieldID string) (CustomField, bool) {
+	for _, f := range b.CustomFields {
+		if f.ID == fieldID {
+			return f, true
+		}
+	}
+	return CustomField{}, false
+}
+
 func (b *Board) RemoveMember(userID string) {
 	updatedMembers := make([]BoardMember, 0)
 	for _, m := range b.Members {
@@ -90,6 +158,7 @@ func (b *Board) Update(input UpdateInput) error {
 	for _, list := range mapLists {
 		updatedList = append(updatedList, list)
 	}
+	sortLists(updatedList)
 	b.Title = input.Title
 	b.Lists = updatedList
 	b.UpdatedAt = time.Now()
@@ -105,14 +174,15 @@ type MemberListInput struct {
 }
 
 type ListInput struct {
-	Title    string `json:"title" validate:"required"`
-	Position int    `json:"position" validate:"required"`
+	Title string `json:"title" validate:"required,max=50"`
+	// Position zero appends the list after the last one.
+	Position float64 `json:"position" validate:"gte=0"`
 }
 
 type ListUpdateInput struct {
-	ID       string `json:"entity_id" validate:"required"`
-	Title    string `json:"title" validate:"required"`
-	Position int    `json:"position" validate:"required"`
+	ID       string  `json:"entity_id" validate:"required"`
+	Title    string  `json:"title" validate:"required"`
+	Position float64 `json:"position" validate:"required"`
 }
 
 type UpdateInput struct {
@@ -120,13 +190,41 @@ type UpdateInput struct {
 	Lists []ListUpdateInput `json:"lists"`
 }
 
+// updatePaths are the UpdateInput fields an update mask can name; an empty
+// mask names all of them.
+var updatePaths = []string{"title"}
+
+// UpdateMask is the set of UpdateInput fields an update applies.
+type UpdateMask map[string]bool
+
+func NewUpdateMask(paths []string) (UpdateMask, error) {
+	if len(paths) == 0 {
+		paths = updatePaths
+	}
+	known := make(map[string]bool, 0)
+	for _, p := range updatePaths {
+		known[p] = true
+	}
+	res := make(UpdateMask, 0)
+	for _, p := range paths {
+		if !known[p] {
+			return nil, apierror.WithDesc(ErrorCodeInvalidInput, fmt.Sprintf("%s can't be updated", p))
+		}
+		res[p] = true
+	}
+	return res, nil
+}
+
 type Input struct {
-	Title   string        `json:"title" validate:"required"`
+	Title string `json:"title" validate:"required"`
+	// Code defaults to one derived from the title.
+	Code    string        `json:"code"`
 	Members []MemberInput `json:"members"`
 	Lists   []ListInput   `json:"lists"`
 	Labels  []LabelInput  `json:"labels"`
 }
 
+// ToEntity leaves the code to the service, which makes sure it's unique.
 func (t Input) ToEntity() (res *Board, err error) {
 	id, err := uuid.NewUUID()
 	if err != nil {
@@ -142,16 +240,19 @@ func (t Input) ToEntity() (res *Board, err error) {
 		}
 		members = append(members, boardMember)
 	}
-	for _, l := range t.Lists {
+	for i, l := range t.Lists {
+		if l.Position == 0 {
+			l.Position = float64(i + 1)
+		}
 		boardList, err := NewBoardList(id.String(), l)
 		if err != nil {
 			return res, err
 		}
 		lists = append(lists, boardList)
 	}
+	sortLists(lists)
 	return &Board{
 		ID:        id.String(),
-		Code:      "FOOBAR",
 		Title:     t.Title,
 		Members:   members,
 		Lists:     lists,
@@ -185,14 +286,20 @@ func NewBoardMember(boardID, userID string) (BoardMember, error) {
 }
 
 type BoardList struct {
-	ID        string     `json:"entity_id" db:"entity_id"`
-	BoardID   string     `json:"board_id" db:"board_id"`
-	PublicID  string     `json:"public_id" db:"public_id"`
-	Title     string     `json:"title" db:"title"`
-	Position  int        `json:"position" db:"position"`
-	CreatedAt time.Time  `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
-	DeletedAt *time.Time `json:"deleted_at" db:"deleted_at"`
+	ID       string  `json:"entity_id" db:"entity_id"`
+	BoardID  string  `json:"board_id" db:"board_id"`
+	PublicID string  `json:"public_id" db:"public_id"`
+	Title    string  `json:"title" db:"title"`
+	Position float64 `json:"position" db:"position"`
+	// WipLimit caps the number of cards in the list, nil means no limit.
+	WipLimit     *int         `json:"wip_limit" db:"wip_limit"`
+	WipLimitMode WipLimitMode `json:"wip_limit_mode" db:"wip_limit_mode"`
+	// CardCount is the number of cards in the list, it isn't stored.
+	CardCount  int        `json:"card_count" db:"-"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at" db:"updated_at"`
+	ArchivedAt *time.Time `json:"archived_at" db:"archived_at"`
+	DeletedAt  *time.Time `json:"deleted_at" db:"deleted_at"`
 }
 
 func NewBoardList(boardID string, input ListInput) (BoardList, error) {
@@ -202,15 +309,43 @@ func NewBoardList(boardID string, input ListInput) (BoardList, error) {
 	}
 	now := time.Now()
 	return BoardList{
+		ID:           id.String(),
+		BoardID:      boardID,
+		Title:        input.Title,
+		Position:     input.Position,
+		WipLimitMode: WipLimitModeStrict,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}, nil
+}
+
+type Watcher struct {
+	ID        string    `json:"entity_id" db:"entity_id"`
+	BoardID   string    `json:"board_id" db:"board_id"`
+	ListID    string    `json:"list_id" db:"list_id"`
+	UserID    string    `json:"user_id" db:"user_id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+func NewWatcher(boardID, listID, userID string) (Watcher, error) {
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return Watcher{}, err
+	}
+	return Watcher{
 		ID:        id.String(),
 		BoardID:   boardID,
-		Title:     input.Title,
-		Position:  input.Position,
-		CreatedAt: now,
-		UpdatedAt: now,
+		ListID:    listID,
+		UserID:    userID,
+		CreatedAt: time.Now(),
 	}, nil
 }
 
+type WatcherInput struct {
+	UserID string `json:"user_id" validate:"required"`
+	ListID string `json:"list_id"`
+}
+
 type Label struct {
 	ID        string    `json:"entity_id" db:"entity_id"`
 	BoardID   string    `json:"board_id" db:"board_id"`
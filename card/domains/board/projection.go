@@ -0,0 +1,79 @@
+package board
+
+import (
+	"fmt"
+
+	"github.com/rakateja/milo/twirp-rpc-examples/card/apierror"
+)
+
+// The collections of a board that a read mask can leave out.
+const (
+	CollectionMembers      = "members"
+	CollectionLists        = "lists"
+	CollectionLabels       = "labels"
+	CollectionWatchers     = "watchers"
+	CollectionCustomFields = "custom_fields"
+)
+
+var collections = []string{
+	CollectionMembers,
+	CollectionLists,
+	CollectionLabels,
+	CollectionWatchers,
+	CollectionCustomFields,
+}
+
+// scalarFields are always loaded, so naming them in a read mask is allowed
+// but changes nothing.
+var scalarFields = []string{"id", "code", "title", "created_at", "updated_at"}
+
+// Projection is the set of collections a read loads. A nil projection loads
+// all of them.
+type Projection map[string]bool
+
+// NewProjection builds the projection of a read mask, nil when the mask is
+// empty.
+func NewProjection(paths []string) (Projection, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]bool, 0)
+	for _, f := range collections {
+		known[f] = true
+	}
+	for _, f := range scalarFields {
+		known[f] = true
+	}
+	res := make(Projection, 0)
+	for _, p := range paths {
+		if !known[p] {
+			return nil, apierror.WithDesc(ErrorCodeInvalidInput, fmt.Sprintf("%s isn't a board field", p))
+		}
+		res[p] = true
+	}
+	return res, nil
+}
+
+func (p Projection) Includes(collection string) bool {
+	return p == nil || p[collection]
+}
+
+// Apply drops the collections the projection leaves out from a board that
+// was loaded in full.
+func (p Projection) Apply(b *Board) {
+	if !p.Includes(CollectionMembers) {
+		b.Members = nil
+	}
+	if !p.Includes(CollectionLists) {
+		b.Lists = nil
+	}
+	if !p.Includes(CollectionLabels) {
+		b.Labels = nil
+	}
+	if !p.Includes(CollectionWatchers) {
+		b.Watchers = nil
+	}
+	if !p.Includes(CollectionCustomFields) {
+		b.CustomFields = nil
+	}
+}
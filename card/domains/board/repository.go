@@ -6,9 +6,21 @@ type Repository interface {
 	Store(ctx context.Context, entity *Board) error
 	StoreMember(ctx context.Context, entity BoardMember) error
 	StoreList(ctx context.Context, entity BoardList) error
+	StoreLists(ctx context.Context, lists []BoardList) error
+	StoreWatchers(ctx context.Context, boardID string, watchers []Watcher) error
+	StoreClone(ctx context.Context, clone Clone, copier CardCopier) error
 	ResolveByID(ctx context.Context, id string) (*Board, error)
+	ResolveProjectedByID(ctx context.Context, id string, projection Projection) (*Board, error)
+	ResolveByIDForUpdate(ctx context.Context, id string) (*Board, error)
+	ResolveByCode(ctx context.Context, code string, projection Projection) (*Board, error)
+	ExistByCode(ctx context.Context, code string) (bool, error)
 	ResolveAllByFilter(ctx context.Context, filter Filter) ([]Board, error)
-	ResolveAll(ctx context.Context, offset, limit int) ([]Board, error)
+	ResolveAll(ctx context.Context, offset, limit int, projection Projection) ([]Board, error)
+	ResolveAllByIDs(ctx context.Context, ids []string) ([]Board, error)
 	ResolveTotal(ctx context.Context) (int, error)
 	ResolveListByID(ctx context.Context, listID string) (BoardList, error)
+	ExistListByPublicID(ctx context.Context, publicID string) (bool, error)
+	ResolveTemplateByID(ctx context.Context, id string) (*Template, error)
+	ResolveAllTemplates(ctx context.Context) ([]Template, error)
+	CountCards(ctx context.Context, lists []BoardList) error
 }
@@ -2,17 +2,31 @@ package board
 
 import (
 	"context"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/apierror"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/database"
 )
 
 type Service struct {
-	repo      Repository
-	labelRepo LabelRepository
+	repo            Repository
+	labelRepo       LabelRepository
+	customFieldRepo CustomFieldRepository
+	cardCopier      CardCopier
+	listCards       ListCards
+	fieldCards      FieldCards
+	tx              database.Transactor
 }
 
-func NewService(repo Repository, labelRepo LabelRepository) *Service {
-	return &Service{repo: repo, labelRepo: labelRepo}
+func NewService(repo Repository, labelRepo LabelRepository, customFieldRepo CustomFieldRepository, cardCopier CardCopier, listCards ListCards, fieldCards FieldCards, tx database.Transactor) *Service {
+	return &Service{repo: repo, labelRepo: labelRepo, customFieldRepo: customFieldRepo, cardCopier: cardCopier, listCards: listCards, fieldCards: fieldCards, tx: tx}
 }
 
 func (svc *Service) Create(ctx context.Context, input Input) (res *Board, err error) {
@@ -20,6 +34,14 @@ func (svc *Service) Create(ctx context.Context, input Input) (res *Board, err er
 	if err != nil {
 		return
 	}
+	entity.Code, err = svc.resolveCode(ctx, input.Code, input.Title)
+	if err != nil {
+		return
+	}
+	err = svc.generateListPublicIDs(ctx, entity.Lists)
+	if err != nil {
+		return
+	}
 	err = svc.repo.Store(ctx, entity)
 	if err != nil {
 		err = errors.Wrap(err, "store board")
@@ -28,25 +50,186 @@ func (svc *Service) Create(ctx context.Context, input Input) (res *Board, err er
 	return svc.repo.ResolveByID(ctx, entity.ID)
 }
 
-func (svc *Service) Update(ctx context.Context, id string, input UpdateInput) (res *Board, err error) {
-	entity, err := svc.repo.ResolveByID(ctx, id)
+// Update applies the fields of the input named by the update mask paths, or
+// all of them when there are none.
+func (svc *Service) Update(ctx context.Context, id string, input UpdateInput, paths []string) (res *Board, err error) {
+	mask, err := NewUpdateMask(paths)
 	if err != nil {
-		err = errors.Wrap(err, "resolve by id")
 		return
 	}
-	err = entity.Update(input)
+	// the board is read for update, storing it diffs its members and lists
+	err = svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		entity, err := svc.repo.ResolveByIDForUpdate(ctx, id)
+		if err != nil {
+			return errors.Wrap(err, "resolve by id")
+		}
+		if !mask["title"] {
+			input.Title = entity.Title
+		}
+		err = validator.New().Struct(input)
+		if err != nil {
+			return apierror.WithDesc(ErrorCodeInvalidInput, err.Error())
+		}
+		err = entity.Update(input)
+		if err != nil {
+			return errors.Wrap(err, "update board")
+		}
+		err = svc.repo.Store(ctx, entity)
+		if err != nil {
+			return errors.Wrap(err, "store board")
+		}
+		return nil
+	})
 	if err != nil {
-		err = errors.Wrap(err, "update board")
 		return
 	}
-	err = svc.repo.Store(ctx, entity)
+	return svc.repo.ResolveByID(ctx, id)
+}
+
+// Clone copies the board's lists, labels, custom fields and card templates
+// into a new board, and its cards too when IncludeCards is set. The source is
+// read and the copy written in one transaction.
+func (svc *Service) Clone(ctx context.Context, boardID string, input CloneInput) (res *Board, err error) {
+	err = validator.New().Struct(input)
+	if err != nil {
+		err = apierror.WithDesc(ErrorCodeInvalidInput, err.Error())
+		return
+	}
+	var entity *Board
+	err = svc.tx.RetryTransaction(ctx, func(ctx context.Context) (err error) {
+		source, err := svc.repo.ResolveByID(ctx, boardID)
+		if err != nil {
+			return errors.Wrap(err, "resolve board by id")
+		}
+		if source.IsTemplate {
+			return apierror.WithDesc(ErrorCodeInvalidInput, "templates are instantiated with CreateFromTemplate")
+		}
+		var mapping CloneMapping
+		entity, mapping, err = source.Clone(input.Title)
+		if err != nil {
+			return
+		}
+		entity.Code, err = svc.resolveCode(ctx, input.Code, input.Title)
+		if err != nil {
+			return
+		}
+		err = svc.generateListPublicIDs(ctx, entity.Lists)
+		if err != nil {
+			return
+		}
+		err = svc.repo.StoreClone(ctx, Clone{Board: entity, Mapping: mapping, IncludeCards: input.IncludeCards}, svc.cardCopier)
+		if err != nil {
+			return errors.Wrap(err, "store board clone")
+		}
+		return
+	})
+	if err != nil {
+		return
+	}
+	return svc.repo.ResolveByID(ctx, entity.ID)
+}
+
+// PublishTemplate snapshots the board, with its card templates but without
+// its members and cards, as a template.
+func (svc *Service) PublishTemplate(ctx context.Context, input TemplateInput) (res *Template, err error) {
+	err = validator.New().Struct(input)
+	if err != nil {
+		err = apierror.WithDesc(ErrorCodeInvalidInput, err.Error())
+		return
+	}
+	var template *Template
+	err = svc.tx.RetryTransaction(ctx, func(ctx context.Context) (err error) {
+		source, err := svc.repo.ResolveByID(ctx, input.BoardID)
+		if err != nil {
+			return errors.Wrap(err, "resolve board by id")
+		}
+		if !source.HasAccess(input.UserID) {
+			return apierror.WithDesc(ErrorCodeInvalidInput, "user isn't a member of the board")
+		}
+		snapshot, mapping, err := source.Clone(input.Name)
+		if err != nil {
+			return
+		}
+		snapshot.Code, err = svc.resolveCode(ctx, "", input.Name)
+		if err != nil {
+			return
+		}
+		err = svc.generateListPublicIDs(ctx, snapshot.Lists)
+		if err != nil {
+			return
+		}
+		snapshot.Members = nil
+		snapshot.IsTemplate = true
+		template, err = input.ToEntity(snapshot.ID)
+		if err != nil {
+			return
+		}
+		err = svc.repo.StoreClone(ctx, Clone{Board: snapshot, Mapping: mapping, Template: template}, svc.cardCopier)
+		if err != nil {
+			return errors.Wrap(err, "store board template")
+		}
+		return
+	})
+	if err != nil {
+		return
+	}
+	return svc.repo.ResolveTemplateByID(ctx, template.ID)
+}
+
+// CreateFromTemplate creates a board, with the template's card templates, from
+// the board snapshot of the template.
+func (svc *Service) CreateFromTemplate(ctx context.Context, templateID string, input TemplateBoardInput) (res *Board, err error) {
+	err = validator.New().Struct(input)
+	if err != nil {
+		err = apierror.WithDesc(ErrorCodeInvalidInput, err.Error())
+		return
+	}
+	var entity *Board
+	err = svc.tx.RetryTransaction(ctx, func(ctx context.Context) (err error) {
+		template, err := svc.repo.ResolveTemplateByID(ctx, templateID)
+		if err != nil {
+			return errors.Wrap(err, "resolve board template by id")
+		}
+		snapshot, err := svc.repo.ResolveByID(ctx, template.BoardID)
+		if err != nil {
+			return errors.Wrap(err, "resolve template board by id")
+		}
+		var mapping CloneMapping
+		entity, mapping, err = snapshot.Clone(input.Title)
+		if err != nil {
+			return
+		}
+		entity.Code, err = svc.resolveCode(ctx, input.Code, input.Title)
+		if err != nil {
+			return
+		}
+		err = svc.generateListPublicIDs(ctx, entity.Lists)
+		if err != nil {
+			return
+		}
+		for _, m := range input.Members {
+			member, err := NewBoardMember(entity.ID, m.UserID)
+			if err != nil {
+				return err
+			}
+			entity.Members = append(entity.Members, member)
+		}
+		err = svc.repo.StoreClone(ctx, Clone{Board: entity, Mapping: mapping}, svc.cardCopier)
+		if err != nil {
+			return errors.Wrap(err, "store board from template")
+		}
+		return
+	})
 	if err != nil {
-		err = errors.Wrap(err, "store board")
 		return
 	}
 	return svc.repo.ResolveByID(ctx, entity.ID)
 }
 
+func (svc *Service) ResolveTemplates(ctx context.Context) ([]Template, error) {
+	return svc.repo.ResolveAllTemplates(ctx)
+}
+
 func (svc *Service) AddMember(ctx context.Context, boardID string, input MemberListInput) (res *Board, err error) {
 	boardEntity, err := svc.repo.ResolveByID(ctx, boardID)
 	if err != nil {
@@ -73,37 +256,343 @@ func (svc *Service) AddMember(ctx context.Context, boardID string, input MemberL
 }
 
 func (svc *Service) RemoveMember(ctx context.Context, boardID string, userID string) (res *Board, err error) {
-	boardEntity, err := svc.repo.ResolveByID(ctx, boardID)
+	err = svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		boardEntity, err := svc.repo.ResolveByIDForUpdate(ctx, boardID)
+		if err != nil {
+			return errors.Wrap(err, "resolve board by id")
+		}
+		boardEntity.RemoveMember(userID)
+		err = svc.repo.Store(ctx, boardEntity)
+		if err != nil {
+			return errors.Wrap(err, "store board entity")
+		}
+		return nil
+	})
 	if err != nil {
-		err = errors.Wrap(err, "resolve board by id")
 		return
 	}
-	boardEntity.RemoveMember(userID)
-	err = svc.repo.Store(ctx, boardEntity)
+	return svc.repo.ResolveByID(ctx, boardID)
+}
+
+func (svc *Service) AddList(ctx context.Context, boardID string, listInput ListInput) (res *Board, err error) {
+	err = validator.New().Struct(listInput)
+	if err != nil {
+		err = apierror.WithDesc(ErrorCodeInvalidInput, err.Error())
+		return
+	}
+	// the board is read for update, the new list's position follows its last list
+	err = svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		boardEntity, err := svc.repo.ResolveByIDForUpdate(ctx, boardID)
+		if err != nil {
+			return errors.Wrap(err, "resolve board by id")
+		}
+		boardList, err := boardEntity.AddList(listInput)
+		if err != nil {
+			return err
+		}
+		boardList.PublicID, err = svc.generatePublicID(ctx, 0)
+		if err != nil {
+			return err
+		}
+		return errors.Wrap(svc.repo.StoreList(ctx, boardList), "store board list")
+	})
 	if err != nil {
-		err = errors.Wrap(err, "store board entity")
 		return
 	}
 	return svc.repo.ResolveByID(ctx, boardID)
 }
 
-func (svc *Service) AddList(ctx context.Context, boardID string, listInput ListInput) (res *Board, err error) {
-	_, err = svc.repo.ResolveByID(ctx, boardID)
+func (svc *Service) RenameList(ctx context.Context, input ListRenameInput) (res *Board, err error) {
+	err = validator.New().Struct(input)
 	if err != nil {
-		err = errors.Wrap(err, "resolve board by id")
+		err = apierror.WithDesc(ErrorCodeInvalidInput, err.Error())
 		return
 	}
-	boardList, err := NewBoardList(boardID, listInput)
+	var boardID string
+	err = svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		boardEntity, err := svc.resolveByListIDForUpdate(ctx, input.ListID)
+		if err != nil {
+			return err
+		}
+		boardID = boardEntity.ID
+		boardList, err := boardEntity.RenameList(input.ListID, input.Title)
+		if err != nil {
+			return err
+		}
+		return errors.Wrap(svc.repo.StoreList(ctx, boardList), "store board list")
+	})
 	if err != nil {
 		return
 	}
-	err = svc.repo.StoreList(ctx, boardList)
+	return svc.repo.ResolveByID(ctx, boardID)
+}
+
+func (svc *Service) ReorderList(ctx context.Context, input ListReorderInput) (res *Board, err error) {
+	err = validator.New().Struct(input)
+	if err != nil {
+		err = apierror.WithDesc(ErrorCodeInvalidInput, err.Error())
+		return
+	}
+	var boardID string
+	err = svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		boardEntity, err := svc.resolveByListIDForUpdate(ctx, input.ListID)
+		if err != nil {
+			return err
+		}
+		boardID = boardEntity.ID
+		changed, err := boardEntity.ReorderList(input.ListID, input.AfterListID)
+		if err != nil {
+			return err
+		}
+		return errors.Wrap(svc.repo.StoreLists(ctx, changed), "store board lists")
+	})
 	if err != nil {
 		return
 	}
 	return svc.repo.ResolveByID(ctx, boardID)
 }
 
+func (svc *Service) SetListWipLimit(ctx context.Context, input ListWipLimitInput) (res *Board, err error) {
+	err = validator.New().Struct(input)
+	if err != nil {
+		err = apierror.WithDesc(ErrorCodeInvalidInput, err.Error())
+		return
+	}
+	var boardID string
+	err = svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		boardEntity, err := svc.resolveByListIDForUpdate(ctx, input.ListID)
+		if err != nil {
+			return err
+		}
+		boardID = boardEntity.ID
+		boardList, err := boardEntity.SetListWipLimit(input)
+		if err != nil {
+			return err
+		}
+		return errors.Wrap(svc.repo.StoreList(ctx, boardList), "store board list")
+	})
+	if err != nil {
+		return
+	}
+	return svc.repo.ResolveByID(ctx, boardID)
+}
+
+// ArchiveList hides the list from the board view. Archived lists keep their
+// cards and can be restored.
+func (svc *Service) ArchiveList(ctx context.Context, input ListArchiveInput) (res *Board, err error) {
+	err = validator.New().Struct(input)
+	if err != nil {
+		err = apierror.WithDesc(ErrorCodeInvalidInput, err.Error())
+		return
+	}
+	var boardID string
+	err = svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		boardEntity, err := svc.resolveByListIDForUpdate(ctx, input.ListID)
+		if err != nil {
+			return err
+		}
+		boardID = boardEntity.ID
+		boardList, err := boardEntity.ArchiveList(input.ListID, input.Archived)
+		if err != nil {
+			return err
+		}
+		return errors.Wrap(svc.repo.StoreList(ctx, boardList), "store board list")
+	})
+	if err != nil {
+		return
+	}
+	return svc.repo.ResolveByID(ctx, boardID)
+}
+
+// MoveListToBoard moves the list with its cards to the end of another board.
+// The cards' labels are swapped for the target board's labels with the same
+// slug.
+func (svc *Service) MoveListToBoard(ctx context.Context, input ListMoveInput) (res *Board, err error) {
+	err = validator.New().Struct(input)
+	if err != nil {
+		err = apierror.WithDesc(ErrorCodeInvalidInput, err.Error())
+		return
+	}
+	err = svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		current, err := svc.repo.ResolveListByID(ctx, input.ListID)
+		if err != nil {
+			return errors.Wrap(err, "resolve board list by id")
+		}
+		if current.BoardID == input.BoardID {
+			return apierror.WithDesc(ErrorCodeInvalidInput, "the list already belongs to the board")
+		}
+		boards, err := svc.resolveAllByIDForUpdate(ctx, current.BoardID, input.BoardID)
+		if err != nil {
+			return err
+		}
+		source, target := boards[current.BoardID], boards[input.BoardID]
+		if target.IsTemplate {
+			return apierror.WithDesc(ErrorCodeInvalidInput, "lists can't be moved to a template")
+		}
+		boardList, exist := source.ListByID(input.ListID)
+		if !exist {
+			return apierror.WithDesc(ErrorCodeEntityNotFound, "board list couldn't be found")
+		}
+		boardList.BoardID = target.ID
+		boardList.Position = target.NextListPosition()
+		boardList.UpdatedAt = time.Now()
+		source.RemoveListWatchers(boardList.ID)
+		err = svc.repo.StoreList(ctx, boardList)
+		if err != nil {
+			return errors.Wrap(err, "store board list")
+		}
+		err = svc.repo.StoreWatchers(ctx, source.ID, source.Watchers)
+		if err != nil {
+			return errors.Wrap(err, "store watchers")
+		}
+		err = svc.listCards.MoveCards(ctx, boardList.ID, boardList, *target, source.LabelMappingTo(*target))
+		if err != nil {
+			return errors.Wrap(err, "move list cards")
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	return svc.repo.ResolveByID(ctx, input.BoardID)
+}
+
+// DeleteList removes the list. Its cards are moved to TargetListID or deleted
+// depending on the policy, in the same transaction as the list; without a
+// policy only an empty list is deleted.
+func (svc *Service) DeleteList(ctx context.Context, input ListDeleteInput) (res *Board, err error) {
+	err = validator.New().Struct(input)
+	if err != nil {
+		err = apierror.WithDesc(ErrorCodeInvalidInput, err.Error())
+		return
+	}
+	if input.Policy == ListCardPolicyMove && input.TargetListID == input.ListID {
+		err = apierror.WithDesc(ErrorCodeInvalidInput, "cards can't be moved to the deleted list")
+		return
+	}
+	var boardID string
+	err = svc.tx.RetryTransaction(ctx, func(ctx context.Context) (err error) {
+		current, err := svc.repo.ResolveListByID(ctx, input.ListID)
+		if err != nil {
+			err = errors.Wrap(err, "resolve board list by id")
+			return
+		}
+		boardIDs := []string{current.BoardID}
+		var targetList BoardList
+		if input.Policy == ListCardPolicyMove {
+			targetList, err = svc.repo.ResolveListByID(ctx, input.TargetListID)
+			if err != nil {
+				err = errors.Wrap(err, "resolve board list by id")
+				return
+			}
+			boardIDs = append(boardIDs, targetList.BoardID)
+		}
+		boards, err := svc.resolveAllByIDForUpdate(ctx, boardIDs...)
+		if err != nil {
+			return
+		}
+		boardEntity := boards[current.BoardID]
+		boardID = boardEntity.ID
+		boardList, exist := boardEntity.ListByID(input.ListID)
+		if !exist {
+			err = apierror.WithDesc(ErrorCodeEntityNotFound, "board list couldn't be found")
+			return
+		}
+		switch input.Policy {
+		case ListCardPolicyMove:
+			target := boards[targetList.BoardID]
+			targetList, exist = target.ListByID(input.TargetListID)
+			if !exist || targetList.DeletedAt != nil {
+				err = apierror.WithDesc(ErrorCodeEntityNotFound, "board list couldn't be found")
+				return
+			}
+			if targetList.IsArchived() {
+				err = apierror.WithDesc(ErrorCodeInvalidInput, "cards can't be moved to an archived list")
+				return
+			}
+			err = svc.listCards.MoveCards(ctx, input.ListID, targetList, *target, boardEntity.LabelMappingTo(*target))
+			if err != nil {
+				err = errors.Wrap(err, "move list cards")
+				return
+			}
+		case ListCardPolicyDelete:
+			err = svc.listCards.DeleteCards(ctx, input.ListID)
+			if err != nil {
+				err = errors.Wrap(err, "delete list cards")
+				return
+			}
+		default:
+			var total int
+			total, err = svc.listCards.CountCards(ctx, input.ListID)
+			if err != nil {
+				err = errors.Wrap(err, "count list cards")
+				return
+			}
+			if total > 0 {
+				err = apierror.WithDesc(ErrorCodeInvalidInput, "the list still has cards, choose a policy for them")
+				return
+			}
+		}
+		now := time.Now()
+		boardList.UpdatedAt = now
+		boardList.DeletedAt = &now
+		err = svc.repo.StoreList(ctx, boardList)
+		if err != nil {
+			err = errors.Wrap(err, "store board list")
+			return
+		}
+		boardEntity.RemoveListWatchers(boardList.ID)
+		err = svc.repo.StoreWatchers(ctx, boardEntity.ID, boardEntity.Watchers)
+		if err != nil {
+			err = errors.Wrap(err, "store watchers")
+			return
+		}
+		return
+	})
+	if err != nil {
+		return
+	}
+	return svc.repo.ResolveByID(ctx, boardID)
+}
+
+// resolveAllByIDForUpdate locks the boards in id order, so transactions
+// locking the same boards can't deadlock each other.
+func (svc *Service) resolveAllByIDForUpdate(ctx context.Context, ids ...string) (map[string]*Board, error) {
+	sorted := make([]string, len(ids))
+	copy(sorted, ids)
+	sort.Strings(sorted)
+	res := make(map[string]*Board, len(sorted))
+	for _, id := range sorted {
+		if _, locked := res[id]; locked {
+			continue
+		}
+		boardEntity, err := svc.repo.ResolveByIDForUpdate(ctx, id)
+		if err != nil {
+			return nil, errors.Wrap(err, "resolve board by id")
+		}
+		res[id] = boardEntity
+	}
+	return res, nil
+}
+
+// resolveByListIDForUpdate locks the board of the list. The list is read
+// before the lock, so it's checked again on the locked board in case it was
+// moved meanwhile.
+func (svc *Service) resolveByListIDForUpdate(ctx context.Context, listID string) (*Board, error) {
+	boardList, err := svc.repo.ResolveListByID(ctx, listID)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve board list by id")
+	}
+	boardEntity, err := svc.repo.ResolveByIDForUpdate(ctx, boardList.BoardID)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve board by id")
+	}
+	if _, exist := boardEntity.ListByID(listID); !exist {
+		return nil, apierror.WithDesc(ErrorCodeEntityNotFound, "board list couldn't be found")
+	}
+	return boardEntity, nil
+}
+
 func (svc *Service) CreateLabel(ctx context.Context, boardID string, input LabelInput) (res *Board, err error) {
 	boardEntity, err := svc.repo.ResolveByID(ctx, boardID)
 	if err != nil {
@@ -138,10 +627,273 @@ func (svc *Service) UpdateLabel(ctx context.Context, labelID, title, color strin
 	return svc.repo.ResolveByID(ctx, label.BoardID)
 }
 
+func (svc *Service) Watch(ctx context.Context, boardID string, input WatcherInput) (res *Board, err error) {
+	err = validator.New().Struct(input)
+	if err != nil {
+		err = apierror.WithDesc(ErrorCodeInvalidInput, err.Error())
+		return
+	}
+	// the board is read for update, StoreWatchers replaces the whole set
+	err = svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		boardEntity, err := svc.repo.ResolveByIDForUpdate(ctx, boardID)
+		if err != nil {
+			return errors.Wrap(err, "resolve board by id")
+		}
+		if !boardEntity.HasAccess(input.UserID) {
+			return apierror.WithDesc(ErrorCodeInvalidInput, "user isn't a member of the board")
+		}
+		err = boardEntity.Watch(input.UserID, input.ListID)
+		if err != nil {
+			if f, ok := err.(apierror.APIError); ok && f.Code == ErrorCodeAlreadyExist {
+				return nil
+			}
+			return errors.Wrap(err, "watch board")
+		}
+		return errors.Wrap(svc.repo.StoreWatchers(ctx, boardID, boardEntity.Watchers), "store watchers")
+	})
+	if err != nil {
+		return
+	}
+	return svc.repo.ResolveByID(ctx, boardID)
+}
+
+func (svc *Service) Unwatch(ctx context.Context, boardID string, input WatcherInput) (res *Board, err error) {
+	err = svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		boardEntity, err := svc.repo.ResolveByIDForUpdate(ctx, boardID)
+		if err != nil {
+			return errors.Wrap(err, "resolve board by id")
+		}
+		boardEntity.Unwatch(input.UserID, input.ListID)
+		return errors.Wrap(svc.repo.StoreWatchers(ctx, boardID, boardEntity.Watchers), "store watchers")
+	})
+	if err != nil {
+		return
+	}
+	return svc.repo.ResolveByID(ctx, boardID)
+}
+
+func (svc *Service) CreateCustomField(ctx context.Context, boardID string, input CustomFieldInput) (res *Board, err error) {
+	// the board is read for update, the new field's position and the
+	// validation depend on its other fields
+	err = svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		boardEntity, err := svc.repo.ResolveByIDForUpdate(ctx, boardID)
+		if err != nil {
+			return errors.Wrap(err, "resolve board by id")
+		}
+		field, err := input.ToEntity(boardEntity.ID, len(boardEntity.CustomFields)+1)
+		if err != nil {
+			return err
+		}
+		err = ValidateCustomFields(append(boardEntity.CustomFields, *field))
+		if err != nil {
+			return err
+		}
+		return errors.Wrap(svc.customFieldRepo.Store(ctx, field, nil), "store custom field")
+	})
+	if err != nil {
+		return
+	}
+	return svc.repo.ResolveByID(ctx, boardID)
+}
+
+func (svc *Service) UpdateCustomField(ctx context.Context, fieldID string, input CustomFieldUpdateInput) (res *Board, err error) {
+	field, err := svc.customFieldRepo.ResolveByID(ctx, fieldID)
+	if err != nil {
+		err = errors.Wrap(err, "resolve custom field by id")
+		return
+	}
+	removedOptionIDs, err := field.Update(input)
+	if err != nil {
+		return
+	}
+	fields, err := svc.customFieldRepo.ResolveAllByBoardID(ctx, field.BoardID)
+	if err != nil {
+		err = errors.Wrap(err, "resolve custom fields by board id")
+		return
+	}
+	for i := range fields {
+		if fields[i].ID == field.ID {
+			fields[i] = *field
+		}
+	}
+	err = ValidateCustomFields(fields)
+	if err != nil {
+		return
+	}
+	err = svc.tx.Transaction(ctx, func(ctx context.Context) error {
+		// the cards lose the removed options
+		if len(removedOptionIDs) > 0 {
+			err := svc.fieldCards.ForgetValues(ctx, field.ID)
+			if err != nil {
+				return errors.Wrap(err, "forget custom field values")
+			}
+		}
+		err := svc.customFieldRepo.Store(ctx, field, removedOptionIDs)
+		if err != nil {
+			return errors.Wrap(err, "store custom field")
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	return svc.repo.ResolveByID(ctx, field.BoardID)
+}
+
+// ReorderCustomFields positions the board's custom fields in the given order.
+// Fields that aren't listed keep their relative order after the listed ones.
+func (svc *Service) ReorderCustomFields(ctx context.Context, boardID string, fieldIDs []string) (res *Board, err error) {
+	// the board is locked first so the fields read below are the latest
+	err = svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		_, err := svc.repo.ResolveByIDForUpdate(ctx, boardID)
+		if err != nil {
+			return errors.Wrap(err, "resolve board by id")
+		}
+		fields, err := svc.customFieldRepo.ResolveAllByBoardID(ctx, boardID)
+		if err != nil {
+			return errors.Wrap(err, "resolve custom fields by board id")
+		}
+		fieldMap := make(map[string]CustomField, 0)
+		for _, f := range fields {
+			fieldMap[f.ID] = f
+		}
+		ordered := make([]CustomField, 0)
+		for _, id := range fieldIDs {
+			f, exist := fieldMap[id]
+			if !exist {
+				return apierror.WithDesc(ErrorCodeEntityNotFound, "custom field couldn't be found")
+			}
+			ordered = append(ordered, f)
+			delete(fieldMap, id)
+		}
+		for _, f := range fields {
+			if _, rest := fieldMap[f.ID]; rest {
+				ordered = append(ordered, f)
+			}
+		}
+		now := time.Now()
+		for i := range ordered {
+			ordered[i].Position = i + 1
+			ordered[i].UpdatedAt = now
+		}
+		return errors.Wrap(svc.customFieldRepo.StorePositions(ctx, ordered), "store custom field positions")
+	})
+	if err != nil {
+		return
+	}
+	return svc.repo.ResolveByID(ctx, boardID)
+}
+
+// DeleteCustomField removes the field definition together with every card
+// value stored for it.
+func (svc *Service) DeleteCustomField(ctx context.Context, fieldID string) (res *Board, err error) {
+	field, err := svc.customFieldRepo.ResolveByID(ctx, fieldID)
+	if err != nil {
+		err = errors.Wrap(err, "resolve custom field by id")
+		return
+	}
+	fields, err := svc.customFieldRepo.ResolveAllByBoardID(ctx, field.BoardID)
+	if err != nil {
+		err = errors.Wrap(err, "resolve custom fields by board id")
+		return
+	}
+	remaining := make([]CustomField, 0)
+	for _, f := range fields {
+		if f.ID != field.ID {
+			remaining = append(remaining, f)
+		}
+	}
+	// fails when a formula still references the field
+	err = ValidateCustomFields(remaining)
+	if err != nil {
+		return
+	}
+	err = svc.tx.Transaction(ctx, func(ctx context.Context) error {
+		err := svc.fieldCards.ForgetValues(ctx, field.ID)
+		if err != nil {
+			return errors.Wrap(err, "forget custom field values")
+		}
+		err = svc.customFieldRepo.Delete(ctx, field.ID)
+		if err != nil {
+			return errors.Wrap(err, "delete custom field")
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	return svc.repo.ResolveByID(ctx, field.BoardID)
+}
+
+func (svc *Service) ResolveCustomFieldByID(ctx context.Context, fieldID string) (*CustomField, error) {
+	return svc.customFieldRepo.ResolveByID(ctx, fieldID)
+}
+
 func (svc *Service) ResolveByID(ctx context.Context, id string) (*Board, error) {
 	return svc.repo.ResolveByID(ctx, id)
 }
 
+// ResolveCustomFields resolves the custom fields of the board without its
+// other collections.
+func (svc *Service) ResolveCustomFields(ctx context.Context, boardID string) ([]CustomField, error) {
+	res, err := svc.repo.ResolveProjectedByID(ctx, boardID, Projection{CollectionCustomFields: true})
+	if err != nil {
+		return nil, err
+	}
+	return res.CustomFields, nil
+}
+
+// maxBatchSize caps the number of boards resolved by one batch call.
+const maxBatchSize = 100
+
+// BatchResult is the outcome of a batch call for one requested board. Err is
+// set instead of Board when that board couldn't be resolved.
+type BatchResult struct {
+	ID    string
+	Board *Board
+	Err   error
+}
+
+// BatchResolve resolves the boards by entity ID or code with one result per
+// requested reference, in the same order. Each board is resolved once.
+func (svc *Service) BatchResolve(ctx context.Context, refs []string) ([]BatchResult, error) {
+	if len(refs) == 0 || len(refs) > maxBatchSize {
+		return nil, apierror.WithDesc(ErrorCodeInvalidInput, "a batch takes 1 to 100 boards")
+	}
+	resolved := make(map[string]BatchResult, 0)
+	res := make([]BatchResult, len(refs))
+	for i, ref := range refs {
+		if r, exist := resolved[ref]; exist {
+			res[i] = r
+			continue
+		}
+		entity, err := svc.Resolve(ctx, ref, nil)
+		if err != nil {
+			if apiErr, ok := errors.Cause(err).(apierror.APIError); !ok || apiErr.Code != ErrorCodeEntityNotFound {
+				return nil, err
+			}
+		}
+		res[i] = BatchResult{ID: ref, Board: entity, Err: err}
+		resolved[ref] = res[i]
+	}
+	return res, nil
+}
+
+// Resolve resolves a board by its entity ID or its code, with the
+// collections of the projection.
+func (svc *Service) Resolve(ctx context.Context, ref string, projection Projection) (*Board, error) {
+	ref = strings.TrimSpace(ref)
+	if _, err := uuid.Parse(ref); err == nil {
+		return svc.repo.ResolveProjectedByID(ctx, ref, projection)
+	}
+	return svc.repo.ResolveByCode(ctx, strings.ToUpper(ref), projection)
+}
+
+// ResolveByCode resolves a board by its code, case insensitive.
+func (svc *Service) ResolveByCode(ctx context.Context, code string) (*Board, error) {
+	return svc.repo.ResolveByCode(ctx, strings.ToUpper(strings.TrimSpace(code)), nil)
+}
+
 func (svc *Service) ExistLabelByID(ctx context.Context, labelID string) (bool, error) {
 	return svc.labelRepo.ExistByID(ctx, labelID)
 }
@@ -153,13 +905,18 @@ func (svc *Service) ResolveAllByFilter(ctx context.Context, filter Filter) ([]Bo
 	return svc.repo.ResolveAllByFilter(ctx, filter)
 }
 
-func (svc *Service) ResolvePage(ctx context.Context, pageNum int, pageSize int) (res Page[Board], err error) {
+// ResolvePage only loads the collections the projection names, none when
+// it's nil.
+func (svc *Service) ResolvePage(ctx context.Context, pageNum int, pageSize int, projection Projection) (res Page[Board], err error) {
 	total, err := svc.repo.ResolveTotal(ctx)
 	if err != nil {
 		return
 	}
+	if projection == nil {
+		projection = Projection{}
+	}
 	offset := (pageNum - 1) * pageSize
-	items, err := svc.repo.ResolveAll(ctx, offset, pageSize)
+	items, err := svc.repo.ResolveAll(ctx, offset, pageSize, projection)
 	if err != nil {
 		return
 	}
@@ -174,5 +931,78 @@ func (svc *Service) ResolveListByID(ctx context.Context, id string) (BoardList,
 }
 
 func (svc *Service) generatePublicID(ctx context.Context, retried int) (res string, err error) {
+	letters := []rune("1234567890ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	codeLength := 5
+	b := make([]rune, codeLength)
+	for i := range b {
+		b[i] = letters[rand.Intn(len(letters))]
+	}
+	res = string(b)
+	exist, err := svc.repo.ExistListByPublicID(ctx, res)
+	if err != nil {
+		err = errors.Wrap(err, "exist board list by public_id")
+		return
+	}
+	if exist {
+		if retried >= 3 {
+			err = errors.New("couldn't generate a unique board list public id")
+			return
+		}
+		return svc.generatePublicID(ctx, retried+1)
+	}
 	return
 }
+
+// resolveCode validates the chosen code, or derives one from the title and
+// adds a number until it's free. The unique index on board.code settles
+// races between boards created at the same time.
+func (svc *Service) resolveCode(ctx context.Context, code, title string) (string, error) {
+	if code != "" {
+		code, err := ValidateCode(code)
+		if err != nil {
+			return "", err
+		}
+		exist, err := svc.repo.ExistByCode(ctx, code)
+		if err != nil {
+			return "", errors.Wrap(err, "exist board by code")
+		}
+		if exist {
+			return "", apierror.WithDesc(ErrorCodeAlreadyExist, "board code is already taken")
+		}
+		return code, nil
+	}
+	base := DeriveCode(title)
+	for i := 1; i <= 100; i++ {
+		code = base
+		if i > 1 {
+			code = base + strconv.Itoa(i)
+		}
+		exist, err := svc.repo.ExistByCode(ctx, code)
+		if err != nil {
+			return "", errors.Wrap(err, "exist board by code")
+		}
+		if !exist {
+			return code, nil
+		}
+	}
+	return "", apierror.WithDesc(ErrorCodeAlreadyExist, "couldn't derive a free board code, choose one")
+}
+
+func (svc *Service) generateListPublicIDs(ctx context.Context, lists []BoardList) error {
+	seen := make(map[string]bool, 0)
+	for i := range lists {
+		publicID, err := svc.generatePublicID(ctx, 0)
+		if err != nil {
+			return err
+		}
+		if seen[publicID] {
+			publicID, err = svc.generatePublicID(ctx, 1)
+			if err != nil {
+				return err
+			}
+		}
+		seen[publicID] = true
+		lists[i].PublicID = publicID
+	}
+	return nil
+}
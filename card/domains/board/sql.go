@@ -5,15 +5,15 @@ import (
 	"database/sql"
 	"strings"
 
-	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	"github.com/rakateja/milo/twirp-rpc-examples/card/apierror"
 	"github.com/rakateja/milo/twirp-rpc-examples/card/database"
 )
 
 type SQLRepository struct {
-	db        *database.MySQL
-	labelRepo LabelRepository
+	db              *database.MySQL
+	labelRepo       *LabelSQLRepository
+	customFieldRepo *CustomFieldSQLRepository
 }
 
 const (
@@ -22,15 +22,17 @@ const (
 			entity_id,
 			code,
 			title,
+			is_template,
 			created_at,
 			updated_at,
 			deleted_at
-		) VALUES (?, ?, ?, ?, ?, ?)
+		) VALUES (?, ?, ?, ?, ?, ?, ?)
 	`
 	updateBoardQuery = `
 		UPDATE board SET
 			code = ?,
 			title = ?,
+			is_template = ?,
 			created_at = ?,
 			updated_at = ?,
 			deleted_at = ?
@@ -41,6 +43,7 @@ const (
 			b.entity_id,
 			b.code,
 			b.title,
+			b.is_template,
 			b.created_at,
 			b.updated_at,
 			b.deleted_at
@@ -61,6 +64,16 @@ const (
 			deleted_at
 		) VALUES (?, ?, ?, ?, ?, ?)
 	`
+	insertMembersQuery = `
+		INSERT INTO board_member (
+			entity_id,
+			board_id,
+			user_id,
+			created_at,
+			updated_at,
+			deleted_at
+		) VALUES %s
+	`
 	updateMemberQuery = `
 		UPDATE board_member SET
 			board_id = ?,
@@ -70,7 +83,20 @@ const (
 			deleted_at = ?
 		WHERE entity_id = ?
 	`
-	deleteMemberQuery = `DELETE FROM board_member`
+	upsertMemberQuery = `
+		INSERT INTO board_member (
+			entity_id,
+			board_id,
+			user_id,
+			created_at,
+			updated_at,
+			deleted_at
+		) VALUES %s
+		ON DUPLICATE KEY UPDATE
+			user_id = VALUES(user_id),
+			updated_at = VALUES(updated_at),
+			deleted_at = VALUES(deleted_at)
+	`
 	selectMemberQuery = `
 		SELECT 
 			entity_id,
@@ -93,8 +119,11 @@ const (
 			public_id,
 			title,
 			position,
+			wip_limit,
+			wip_limit_mode,
 			created_at,
 			updated_at,
+			archived_at,
 			deleted_at
 		FROM board_list
 	`
@@ -105,20 +134,66 @@ const (
 			public_id,
 			title,
 			position,
+			wip_limit,
+			wip_limit_mode,
+			created_at,
+			updated_at,
+			archived_at,
+			deleted_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	insertListsQuery = `
+		INSERT INTO board_list (
+			entity_id,
+			board_id,
+			public_id,
+			title,
+			position,
+			wip_limit,
+			wip_limit_mode,
+			created_at,
+			updated_at,
+			archived_at,
+			deleted_at
+		) VALUES %s
+	`
+	upsertListQuery = `
+		INSERT INTO board_list (
+			entity_id,
+			board_id,
+			public_id,
+			title,
+			position,
+			wip_limit,
+			wip_limit_mode,
 			created_at,
 			updated_at,
+			archived_at,
 			deleted_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		) VALUES %s
+		ON DUPLICATE KEY UPDATE
+			board_id = VALUES(board_id),
+			public_id = VALUES(public_id),
+			title = VALUES(title),
+			position = VALUES(position),
+			wip_limit = VALUES(wip_limit),
+			wip_limit_mode = VALUES(wip_limit_mode),
+			updated_at = VALUES(updated_at),
+			archived_at = VALUES(archived_at),
+			deleted_at = VALUES(deleted_at)
 	`
 	updateListQuery = `
-		UPDATE board_list SET 
+		UPDATE board_list SET
 			board_id = ?,
-			public_id = ?, 
+			public_id = ?,
 			title = ?,
 			position = ?,
+			wip_limit = ?,
+			wip_limit_mode = ?,
 			created_at = ?,
-			updated_at = ?, 
-			deleted_at = ?, 
+			updated_at = ?,
+			archived_at = ?,
+			deleted_at = ?
 		WHERE entity_id = ?
 	`
 	countListQuery = `
@@ -126,63 +201,93 @@ const (
 			COUNT(entity_id)
 		FROM board_list
 	`
-	deleteListQuery = `
-		DELETE FROM board_list
+	countListCardQuery = `
+		SELECT
+			list_id,
+			COUNT(entity_id)
+		FROM card
+		WHERE list_id IN (:list_id) AND deleted_at IS NULL
+		GROUP BY list_id
+	`
+	selectWatcherQuery = `
+		SELECT
+			entity_id,
+			board_id,
+			list_id,
+			user_id,
+			created_at
+		FROM board_watcher
+	`
+	insertWatcherQuery = `
+		INSERT INTO board_watcher (
+			entity_id,
+			board_id,
+			list_id,
+			user_id,
+			created_at
+		) VALUES (?, ?, ?, ?, ?)
+	`
+	deleteWatcherQuery = `
+		DELETE FROM board_watcher
+	`
+	insertTemplateQuery = `
+		INSERT INTO board_template (
+			entity_id,
+			board_id,
+			name,
+			description,
+			created_by,
+			created_at
+		) VALUES (?, ?, ?, ?, ?, ?)
+	`
+	selectTemplateQuery = `
+		SELECT
+			entity_id,
+			board_id,
+			name,
+			description,
+			created_by,
+			created_at
+		FROM board_template
 	`
 )
 
 func NewSQLRepository(db *database.MySQL) Repository {
-	return &SQLRepository{db: db, labelRepo: NewLabelSQLRepository(db)}
+	return &SQLRepository{
+		db:              db,
+		labelRepo:       &LabelSQLRepository{db: db},
+		customFieldRepo: &CustomFieldSQLRepository{db: db},
+	}
 }
 
+// Store writes the board with its members and lists. An existing board has
+// them diffed: the ones it no longer has are deleted and the others upserted.
+// The board row itself isn't upserted, its code is unique too and a new
+// board taking a code in use would overwrite the board that has it.
 func (repo *SQLRepository) Store(ctx context.Context, entity *Board) error {
-	exist, err := repo.existByID(entity.ID)
+	exist, err := repo.existByID(ctx, entity.ID)
 	if err != nil {
 		return errors.Wrap(err, "exist by id")
 	}
-	var memberIDs []string
-	for _, m := range entity.Members {
-		memberIDs = append(memberIDs, m.ID)
-	}
-	err = repo.db.WithTransaction(func(tx *sqlx.Tx) error {
+	err = repo.db.Transaction(ctx, func(ctx context.Context) error {
 		if exist {
-			err = repo.deleteMember(tx, entity.ID)
-			if err != nil {
-				return errors.WithStack(err)
-			}
-			for _, m := range entity.Members {
-				err = repo.insertMember(tx, m)
-				if err != nil {
-					return errors.WithStack(err)
-				}
-			}
-			err = repo.deleteLists(tx, entity.ID)
+			err := repo.update(ctx, entity)
 			if err != nil {
 				return errors.WithStack(err)
 			}
-			for _, l := range entity.Lists {
-				err = repo.insertBoardList(tx, l)
-				if err != nil {
-					return errors.WithStack(err)
-				}
-			}
-			return repo.update(tx, entity)
+			return repo.storeCollections(ctx, entity)
 		}
-		err = repo.insert(tx, entity)
+		err := repo.insert(ctx, entity)
 		if err != nil {
 			return errors.WithStack(err)
 		}
-		for _, m := range entity.Members {
-			err = repo.insertMember(tx, m)
-			if err != nil {
-				return errors.WithStack(err)
-			}
+		err = repo.upsertMembers(ctx, entity.Members)
+		if err != nil {
+			return errors.Wrap(err, "insert members")
 		}
-		for _, l := range entity.Lists {
-			err = repo.insertBoardList(tx, l)
-			if err != nil {
-				return errors.WithStack(err)
-			}
+		err = repo.upsertLists(ctx, entity.Lists)
+		if err != nil {
+			return errors.Wrap(err, "insert lists")
 		}
 		return nil
 	})
@@ -193,16 +298,16 @@ func (repo *SQLRepository) Store(ctx context.Context, entity *Board) error {
 }
 
 func (repo *SQLRepository) StoreMember(ctx context.Context, entity BoardMember) error {
-	res, err := repo.existMemberByIDs([]string{entity.ID})
+	res, err := repo.existMemberByIDs(ctx, []string{entity.ID})
 	if err != nil {
 		return errors.WithMessage(err, "exist by id")
 	}
 	exist, _ := res[entity.ID]
-	err = repo.db.WithTransaction(func(tx *sqlx.Tx) error {
+	err = repo.db.Transaction(ctx, func(ctx context.Context) error {
 		if exist {
-			return repo.updateMember(tx, entity)
+			return repo.updateMember(ctx, entity)
 		}
-		return repo.insertMember(tx, entity)
+		return repo.insertMember(ctx, entity)
 	})
 	if err != nil {
 		return errors.WithMessage(err, "store member")
@@ -211,46 +316,302 @@ func (repo *SQLRepository) StoreMember(ctx context.Context, entity BoardMember)
 }
 
 func (repo *SQLRepository) StoreList(ctx context.Context, entity BoardList) error {
-	exist, err := repo.existListByID(entity.ID)
+	exist, err := repo.existListByID(ctx, entity.ID)
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	err = repo.db.WithTransaction(func(tx *sqlx.Tx) error {
+	err = repo.db.Transaction(ctx, func(ctx context.Context) error {
 		if exist {
-			return repo.updateBoardList(tx, entity)
+			return repo.updateBoardList(ctx, entity)
 		}
-		return repo.insertBoardList(tx, entity)
+		return repo.insertBoardList(ctx, entity)
 	})
 	return errors.WithStack(err)
 }
 
+// StoreLists updates existing lists in one transaction.
+func (repo *SQLRepository) StoreLists(ctx context.Context, lists []BoardList) error {
+	err := repo.db.Transaction(ctx, func(ctx context.Context) error {
+		for _, l := range lists {
+			err := repo.updateBoardList(ctx, l)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.WithMessage(err, "store lists")
+	}
+	return nil
+}
+
+func (repo *SQLRepository) StoreWatchers(ctx context.Context, boardID string, watchers []Watcher) error {
+	err := repo.db.Transaction(ctx, func(ctx context.Context) error {
+		err := repo.deleteWatchers(ctx, boardID)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		for _, w := range watchers {
+			err = repo.insertWatcher(ctx, w)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.WithMessage(err, "store watchers")
+	}
+	return nil
+}
+
+// StoreClone writes the cloned board with its lists, labels and custom
+// fields, the copied card templates and cards and the template, all in one
+// transaction.
+func (repo *SQLRepository) StoreClone(ctx context.Context, clone Clone, copier CardCopier) error {
+	err := repo.db.Transaction(ctx, func(ctx context.Context) error {
+		err := repo.insert(ctx, clone.Board)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		err = repo.db.BulkExec(ctx, insertMembersQuery, memberRows(clone.Board.Members))
+		if err != nil {
+			return errors.Wrap(err, "insert members")
+		}
+		err = repo.db.BulkExec(ctx, insertListsQuery, listRows(clone.Board.Lists))
+		if err != nil {
+			return errors.Wrap(err, "insert lists")
+		}
+		err = repo.labelRepo.insertAll(ctx, clone.Board.Labels)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		err = repo.customFieldRepo.insertAll(ctx, clone.Board.CustomFields)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		var options []CustomFieldOption
+		for _, f := range clone.Board.CustomFields {
+			options = append(options, f.Options...)
+		}
+		err = repo.customFieldRepo.insertOptions(ctx, options)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		err = copier.CopyTemplates(ctx, clone.Mapping)
+		if err != nil {
+			return errors.Wrap(err, "copy card templates")
+		}
+		if clone.IncludeCards {
+			err = copier.CopyCards(ctx, clone.Mapping)
+			if err != nil {
+				return errors.Wrap(err, "copy cards")
+			}
+		}
+		if clone.Template != nil {
+			_, err = repo.db.ExecContext(ctx, insertTemplateQuery,
+				clone.Template.ID,
+				clone.Template.BoardID,
+				clone.Template.Name,
+				clone.Template.Description,
+				clone.Template.CreatedBy,
+				clone.Template.CreatedAt,
+			)
+			if err != nil {
+				return errors.Wrap(err, "insert board template")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.WithMessage(err, "store board clone")
+	}
+	return nil
+}
+
+func (repo *SQLRepository) ResolveTemplateByID(ctx context.Context, id string) (*Template, error) {
+	var res Template
+	err := repo.db.GetContext(ctx, &res, selectTemplateQuery+" WHERE entity_id = ?", id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, apierror.WithDesc(ErrorCodeEntityNotFound, "board template couldn't be found")
+		}
+		return nil, errors.Wrap(err, "select board template by id")
+	}
+	return &res, nil
+}
+
+func (repo *SQLRepository) ResolveAllTemplates(ctx context.Context) ([]Template, error) {
+	var res []Template
+	err := repo.db.SelectContext(ctx, &res, selectTemplateQuery+" ORDER BY name")
+	if err != nil {
+		return nil, errors.Wrap(err, "select board templates")
+	}
+	return res, nil
+}
+
 func (repo *SQLRepository) ResolveByID(ctx context.Context, id string) (*Board, error) {
+	return repo.ResolveProjectedByID(ctx, id, nil)
+}
+
+// ResolveProjectedByID only queries the collections the projection includes.
+func (repo *SQLRepository) ResolveProjectedByID(ctx context.Context, id string, projection Projection) (*Board, error) {
+	return repo.resolveByID(ctx, id, projection, false)
+}
+
+// ResolveByIDForUpdate reads the board with its members, lists and watchers
+// locked until the transaction of ctx ends, so storing it back doesn't drop
+// the ones other transactions wrote since. Other reads of the board in a
+// transaction, like the ones of every card write, don't lock it.
+func (repo *SQLRepository) ResolveByIDForUpdate(ctx context.Context, id string) (*Board, error) {
+	if !database.InTransaction(ctx) {
+		return nil, errors.New("boards can only be resolved for update in a transaction")
+	}
+	return repo.resolveByID(ctx, id, nil, true)
+}
+
+func (repo *SQLRepository) resolveByID(ctx context.Context, id string, projection Projection, forUpdate bool) (*Board, error) {
+	query := selectBoardQuery + " WHERE b.entity_id = ?"
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
 	var res Board
-	err := repo.db.Get(&res, selectBoardQuery+" WHERE b.entity_id = ?", id)
+	err := repo.db.GetContext(ctx, &res, query, id)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, apierror.WithDesc(ErrorCodeEntityNotFound, "board couldn't be found")
 		}
 		return nil, errors.WithMessage(err, "select board by id")
 	}
-	var members []BoardMember
-	var lists []BoardList
-	err = repo.db.Select(&members, selectMemberQuery+" WHERE board_id = ?", id)
+	boards := []Board{res}
+	err = repo.loadCollections(ctx, boards, projection, forUpdate)
 	if err != nil {
-		return nil, errors.Wrap(err, "select board member by board id")
+		return nil, err
 	}
-	err = repo.db.Select(&lists, selectListQuery+" WHERE board_id = ?", id)
+	return &boards[0], nil
+}
+
+// loadCollections loads the collections of every board with one query per
+// collection, whatever the number of boards. forUpdate locks the members,
+// lists and watchers, the collections stores write back.
+func (repo *SQLRepository) loadCollections(ctx context.Context, boards []Board, projection Projection, forUpdate bool) error {
+	if len(boards) == 0 {
+		return nil
+	}
+	lock := ""
+	if forUpdate {
+		lock = " FOR UPDATE"
+	}
+	var boardIDs []string
+	for _, b := range boards {
+		boardIDs = append(boardIDs, b.ID)
+	}
+	membersMap := make(map[string][]BoardMember, 0)
+	listsMap := make(map[string][]BoardList, 0)
+	labelsMap := make(map[string][]Label, 0)
+	watchersMap := make(map[string][]Watcher, 0)
+	customFieldsMap := make(map[string][]CustomField, 0)
+	if projection.Includes(CollectionMembers) {
+		var members []BoardMember
+		err := repo.selectByBoardIDs(ctx, &members, selectMemberQuery+" WHERE board_id IN (:board_id)"+lock, boardIDs)
+		if err != nil {
+			return errors.Wrap(err, "select board member by board id")
+		}
+		for _, m := range members {
+			membersMap[m.BoardID] = append(membersMap[m.BoardID], m)
+		}
+	}
+	if projection.Includes(CollectionLists) {
+		var lists []BoardList
+		err := repo.selectByBoardIDs(ctx, &lists, selectListQuery+" WHERE board_id IN (:board_id) AND deleted_at IS NULL ORDER BY position"+lock, boardIDs)
+		if err != nil {
+			return errors.Wrap(err, "select board list by board id")
+		}
+		err = repo.CountCards(ctx, lists)
+		if err != nil {
+			return errors.Wrap(err, "count cards by list id")
+		}
+		for _, l := range lists {
+			listsMap[l.BoardID] = append(listsMap[l.BoardID], l)
+		}
+	}
+	if projection.Includes(CollectionLabels) {
+		labels, err := repo.labelRepo.resolveAllByBoardIDs(ctx, boardIDs)
+		if err != nil {
+			return errors.Wrap(err, "resolve all label by board id")
+		}
+		for _, l := range labels {
+			labelsMap[l.BoardID] = append(labelsMap[l.BoardID], l)
+		}
+	}
+	if projection.Includes(CollectionWatchers) {
+		var watchers []Watcher
+		err := repo.selectByBoardIDs(ctx, &watchers, selectWatcherQuery+" WHERE board_id IN (:board_id)"+lock, boardIDs)
+		if err != nil {
+			return errors.Wrap(err, "select board watcher by board id")
+		}
+		for _, w := range watchers {
+			watchersMap[w.BoardID] = append(watchersMap[w.BoardID], w)
+		}
+	}
+	if projection.Includes(CollectionCustomFields) {
+		customFields, err := repo.customFieldRepo.resolveAllByBoardIDs(ctx, boardIDs)
+		if err != nil {
+			return errors.Wrap(err, "resolve all custom field by board id")
+		}
+		for _, f := range customFields {
+			customFieldsMap[f.BoardID] = append(customFieldsMap[f.BoardID], f)
+		}
+	}
+	for i := range boards {
+		id := boards[i].ID
+		if projection.Includes(CollectionMembers) {
+			boards[i].Members = membersMap[id]
+		}
+		if projection.Includes(CollectionLists) {
+			boards[i].Lists = listsMap[id]
+		}
+		if projection.Includes(CollectionLabels) {
+			boards[i].Labels = labelsMap[id]
+		}
+		if projection.Includes(CollectionWatchers) {
+			boards[i].Watchers = watchersMap[id]
+		}
+		if projection.Includes(CollectionCustomFields) {
+			boards[i].CustomFields = customFieldsMap[id]
+		}
+	}
+	return nil
+}
+
+func (repo *SQLRepository) selectByBoardIDs(ctx context.Context, dest interface{}, sqlQuery string, boardIDs []string) error {
+	query, args, err := repo.db.In(sqlQuery, map[string]interface{}{"board_id": boardIDs})
 	if err != nil {
-		return nil, errors.Wrap(err, "select board list by board id")
+		return errors.WithStack(err)
 	}
-	labels, err := repo.labelRepo.ResolveAllByBoardID(ctx, id)
+	return repo.db.SelectContext(ctx, dest, repo.db.Rebind(query), args...)
+}
+
+func (repo *SQLRepository) ResolveByCode(ctx context.Context, code string, projection Projection) (*Board, error) {
+	var id string
+	err := repo.db.GetContext(ctx, &id, "SELECT entity_id FROM board WHERE code = ?", code)
 	if err != nil {
-		return nil, errors.Wrap(err, "resolve all label by board id")
+		if err == sql.ErrNoRows {
+			return nil, apierror.WithDesc(ErrorCodeEntityNotFound, "board couldn't be found")
+		}
+		return nil, errors.WithMessage(err, "select board by code")
 	}
-	res.Members = members
-	res.Lists = lists
-	res.Labels = labels
-	return &res, nil
+	return repo.ResolveProjectedByID(ctx, id, projection)
+}
+
+func (repo *SQLRepository) ExistByCode(ctx context.Context, code string) (bool, error) {
+	var total int
+	err := repo.db.GetContext(ctx, &total, countBoardQuery+" WHERE code = ?", code)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return total > 0, nil
 }
 
 func (repo *SQLRepository) ResolveAllByFilter(ctx context.Context, filter Filter) ([]Board, error) {
@@ -258,7 +619,7 @@ func (repo *SQLRepository) ResolveAllByFilter(ctx context.Context, filter Filter
 		return nil, nil
 	}
 	values := make(map[string]interface{}, 0)
-	params := make([]string, 0)
+	params := []string{"b.is_template = 0"}
 	var innerJoinQuery string
 	if filter.UserID != nil {
 		innerJoinQuery = "INNER JOIN board_member m ON m.board_id = b.entity_id"
@@ -275,55 +636,101 @@ func (repo *SQLRepository) ResolveAllByFilter(ctx context.Context, filter Filter
 		return nil, err
 	}
 	var res []Board
-	err = repo.db.Select(&res, query, args...)
+	err = repo.db.SelectContext(ctx, &res, query, args...)
 	if err != nil {
 		return nil, errors.Wrap(err, "execute select query")
 	}
 	return res, nil
 }
 
-func (repo *SQLRepository) ResolveAll(ctx context.Context, offset, limit int) ([]Board, error) {
+func (repo *SQLRepository) ResolveAll(ctx context.Context, offset, limit int, projection Projection) ([]Board, error) {
+	var res []Board
+	err := repo.db.SelectContext(ctx, &res, selectBoardQuery+" WHERE b.is_template = 0 LIMIT ? OFFSET ?", limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	err = repo.loadCollections(ctx, res, projection, false)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ResolveAllByIDs reads whole boards, in no particular order.
+func (repo *SQLRepository) ResolveAllByIDs(ctx context.Context, ids []string) ([]Board, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	query, args, err := repo.db.In(selectBoardQuery+" WHERE b.entity_id IN (:id)", map[string]interface{}{"id": ids})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 	var res []Board
-	err := repo.db.Select(&res, selectBoardQuery+" LIMIT ? OFFSET ?", limit, offset)
-	return res, err
+	err = repo.db.SelectContext(ctx, &res, repo.db.Rebind(query), args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "select board by ids")
+	}
+	err = repo.loadCollections(ctx, res, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
 }
 
 func (repo *SQLRepository) ResolveTotal(ctx context.Context) (int, error) {
 	var total int
-	err := repo.db.Get(&total, countBoardQuery)
+	err := repo.db.GetContext(ctx, &total, countBoardQuery+" WHERE is_template = 0")
 	return total, err
 }
 
 func (repo *SQLRepository) ResolveListByID(ctx context.Context, id string) (BoardList, error) {
 	var list BoardList
-	err := repo.db.Get(&list, selectListQuery+" WHERE entity_id = ?", id)
+	err := repo.db.GetContext(ctx, &list, selectListQuery+" WHERE entity_id = ? AND deleted_at IS NULL", id)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return list, apierror.WithDesc(ErrorCodeEntityNotFound, "board list not found")
 		}
 		return list, errors.WithStack(err)
 	}
-	return list, nil
+	lists := []BoardList{list}
+	err = repo.CountCards(ctx, lists)
+	if err != nil {
+		return list, errors.Wrap(err, "count cards by list id")
+	}
+	return lists[0], nil
 }
 
-func (repo *SQLRepository) existByID(id string) (bool, error) {
+func (repo *SQLRepository) ExistListByPublicID(ctx context.Context, publicID string) (bool, error) {
 	var total int
-	err := repo.db.Get(&total, countBoardQuery+" WHERE entity_id = ?", id)
+	err := repo.db.GetContext(ctx, &total, countListQuery+" WHERE public_id = ?", publicID)
 	if err != nil {
 		return false, errors.WithStack(err)
 	}
 	return total > 0, nil
 }
 
-func (repo *SQLRepository) insert(tx *sqlx.Tx, entity *Board) error {
-	res, err := tx.Exec(insertBoardQuery,
+func (repo *SQLRepository) existByID(ctx context.Context, id string) (bool, error) {
+	var total int
+	err := repo.db.GetContext(ctx, &total, countBoardQuery+" WHERE entity_id = ?", id)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return total > 0, nil
+}
+
+func (repo *SQLRepository) insert(ctx context.Context, entity *Board) error {
+	res, err := repo.db.ExecContext(ctx, insertBoardQuery,
 		entity.ID,
 		entity.Code,
 		entity.Title,
+		entity.IsTemplate,
 		entity.CreatedAt,
 		entity.UpdatedAt,
 		entity.DeletedAt,
 	)
+	if database.IsDuplicateEntry(err) {
+		return apierror.WithDesc(ErrorCodeAlreadyExist, "board code is already taken")
+	}
 	if err != nil {
 		return errors.WithMessage(err, "insert board")
 	}
@@ -337,15 +744,19 @@ func (repo *SQLRepository) insert(tx *sqlx.Tx, entity *Board) error {
 	return nil
 }
 
-func (repo *SQLRepository) update(tx *sqlx.Tx, entity *Board) error {
-	res, err := tx.Exec(updateBoardQuery,
+func (repo *SQLRepository) update(ctx context.Context, entity *Board) error {
+	res, err := repo.db.ExecContext(ctx, updateBoardQuery,
 		entity.Code,
 		entity.Title,
+		entity.IsTemplate,
 		entity.CreatedAt,
 		entity.UpdatedAt,
 		entity.DeletedAt,
 		entity.ID,
 	)
+	if database.IsDuplicateEntry(err) {
+		return apierror.WithDesc(ErrorCodeAlreadyExist, "board code is already taken")
+	}
 	if err != nil {
 		return errors.WithMessage(err, "update board")
 	}
@@ -356,7 +767,7 @@ func (repo *SQLRepository) update(tx *sqlx.Tx, entity *Board) error {
 	return nil
 }
 
-func (repo *SQLRepository) existMemberByIDs(ids []string) (map[string]bool, error) {
+func (repo *SQLRepository) existMemberByIDs(ctx context.Context, ids []string) (map[string]bool, error) {
 	sqlQuery := `
 		SELECT
 			entity_id,
@@ -370,10 +781,11 @@ func (repo *SQLRepository) existMemberByIDs(ids []string) (map[string]bool, erro
 		return res, errors.WithStack(err)
 	}
 	query = repo.db.Rebind(query)
-	rows, err := repo.db.Query(query, args...)
+	rows, err := repo.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return res, errors.WithStack(err)
 	}
+	defer rows.Close()
 	for rows.Next() {
 		var id string
 		var total int
@@ -390,8 +802,8 @@ func (repo *SQLRepository) existMemberByIDs(ids []string) (map[string]bool, erro
 	return res, nil
 }
 
-func (repo *SQLRepository) insertMember(tx *sqlx.Tx, entity BoardMember) error {
-	res, err := tx.Exec(insertMemberQuery,
+func (repo *SQLRepository) insertMember(ctx context.Context, entity BoardMember) error {
+	res, err := repo.db.ExecContext(ctx, insertMemberQuery,
 		entity.ID,
 		entity.BoardID,
 		entity.UserID,
@@ -412,24 +824,74 @@ func (repo *SQLRepository) insertMember(tx *sqlx.Tx, entity BoardMember) error {
 	return nil
 }
 
-func (repo *SQLRepository) deleteMember(tx *sqlx.Tx, boardID string) error {
-	_, err := tx.Exec(deleteMemberQuery+" WHERE board_id = ?", boardID)
+func (repo *SQLRepository) storeCollections(ctx context.Context, entity *Board) error {
+	boardIDs := []string{entity.ID}
+	var memberIDs []string
+	for _, m := range entity.Members {
+		memberIDs = append(memberIDs, m.ID)
+	}
+	err := repo.db.DeleteExcept(ctx, "board_member", "board_id IN (?)", boardIDs, memberIDs)
 	if err != nil {
 		return errors.Wrap(err, "delete members")
 	}
-	return nil
-}
-
-func (repo *SQLRepository) deleteLists(tx *sqlx.Tx, boardID string) error {
-	_, err := tx.Exec(deleteListQuery+" WHERE board_id = ?", boardID)
+	err = repo.upsertMembers(ctx, entity.Members)
+	if err != nil {
+		return errors.Wrap(err, "upsert members")
+	}
+	var listIDs []string
+	for _, l := range entity.Lists {
+		listIDs = append(listIDs, l.ID)
+	}
+	// deleted lists are kept for the cards still pointing at them
+	err = repo.db.DeleteExcept(ctx, "board_list", "board_id IN (?) AND deleted_at IS NULL", boardIDs, listIDs)
 	if err != nil {
 		return errors.Wrap(err, "delete lists")
 	}
+	err = repo.upsertLists(ctx, entity.Lists)
+	if err != nil {
+		return errors.Wrap(err, "upsert lists")
+	}
 	return nil
 }
 
-func (repo *SQLRepository) updateMember(tx *sqlx.Tx, entity BoardMember) error {
-	res, err := tx.Exec(updateMemberQuery,
+func (repo *SQLRepository) upsertMembers(ctx context.Context, members []BoardMember) error {
+	return repo.db.BulkExec(ctx, upsertMemberQuery, memberRows(members))
+}
+
+func (repo *SQLRepository) upsertLists(ctx context.Context, lists []BoardList) error {
+	return repo.db.BulkExec(ctx, upsertListQuery, listRows(lists))
+}
+
+func memberRows(members []BoardMember) [][]interface{} {
+	rows := make([][]interface{}, 0, len(members))
+	for _, m := range members {
+		rows = append(rows, []interface{}{m.ID, m.BoardID, m.UserID, m.CreatedAt, m.UpdatedAt, m.DeletedAt})
+	}
+	return rows
+}
+
+func listRows(lists []BoardList) [][]interface{} {
+	rows := make([][]interface{}, 0, len(lists))
+	for _, l := range lists {
+		rows = append(rows, []interface{}{
+			l.ID,
+			l.BoardID,
+			l.PublicID,
+			l.Title,
+			l.Position,
+			l.WipLimit,
+			l.WipLimitMode,
+			l.CreatedAt,
+			l.UpdatedAt,
+			l.ArchivedAt,
+			l.DeletedAt,
+		})
+	}
+	return rows
+}
+
+func (repo *SQLRepository) updateMember(ctx context.Context, entity BoardMember) error {
+	res, err := repo.db.ExecContext(ctx, updateMemberQuery,
 		entity.BoardID,
 		entity.UserID,
 		entity.CreatedAt,
@@ -447,24 +909,27 @@ func (repo *SQLRepository) updateMember(tx *sqlx.Tx, entity BoardMember) error {
 	return nil
 }
 
-func (repo *SQLRepository) existListByID(id string) (bool, error) {
+func (repo *SQLRepository) existListByID(ctx context.Context, id string) (bool, error) {
 	var total int
-	err := repo.db.Get(&total, countListQuery+" WHERE entity_id = ?", id)
+	err := repo.db.GetContext(ctx, &total, countListQuery+" WHERE entity_id = ?", id)
 	if err != nil {
 		return false, errors.WithStack(err)
 	}
 	return total > 0, nil
 }
 
-func (repo *SQLRepository) insertBoardList(tx *sqlx.Tx, entity BoardList) error {
-	res, err := tx.Exec(insertListQuery,
+func (repo *SQLRepository) insertBoardList(ctx context.Context, entity BoardList) error {
+	res, err := repo.db.ExecContext(ctx, insertListQuery,
 		entity.ID,
 		entity.BoardID,
 		entity.PublicID,
 		entity.Title,
 		entity.Position,
+		entity.WipLimit,
+		entity.WipLimitMode,
 		entity.CreatedAt,
 		entity.UpdatedAt,
+		entity.ArchivedAt,
 		entity.DeletedAt,
 	)
 	if err != nil {
@@ -480,14 +945,17 @@ func (repo *SQLRepository) insertBoardList(tx *sqlx.Tx, entity BoardList) error
 	return nil
 }
 
-func (repo *SQLRepository) updateBoardList(tx *sqlx.Tx, entity BoardList) error {
-	res, err := tx.Exec(updateListQuery,
+func (repo *SQLRepository) updateBoardList(ctx context.Context, entity BoardList) error {
+	res, err := repo.db.ExecContext(ctx, updateListQuery,
 		entity.BoardID,
 		entity.PublicID,
 		entity.Title,
 		entity.Position,
+		entity.WipLimit,
+		entity.WipLimitMode,
 		entity.CreatedAt,
 		entity.UpdatedAt,
+		entity.ArchivedAt,
 		entity.DeletedAt,
 		entity.ID,
 	)
@@ -500,3 +968,60 @@ func (repo *SQLRepository) updateBoardList(tx *sqlx.Tx, entity BoardList) error
 	}
 	return nil
 }
+
+// CountCards sets the number of cards in each list.
+func (repo *SQLRepository) CountCards(ctx context.Context, lists []BoardList) error {
+	if len(lists) == 0 {
+		return nil
+	}
+	var listIDs []string
+	for _, l := range lists {
+		listIDs = append(listIDs, l.ID)
+	}
+	query, args, err := repo.db.In(countListCardQuery, map[string]interface{}{"list_id": listIDs})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	query = repo.db.Rebind(query)
+	rows, err := repo.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer rows.Close()
+	counts := make(map[string]int, 0)
+	for rows.Next() {
+		var listID string
+		var total int
+		err = rows.Scan(&listID, &total)
+		if err != nil {
+			return errors.Wrap(err, "scan sql rows")
+		}
+		counts[listID] = total
+	}
+	for i := range lists {
+		lists[i].CardCount = counts[lists[i].ID]
+	}
+	return nil
+}
+
+func (repo *SQLRepository) insertWatcher(ctx context.Context, entity Watcher) error {
+	_, err := repo.db.ExecContext(ctx, insertWatcherQuery,
+		entity.ID,
+		entity.BoardID,
+		entity.ListID,
+		entity.UserID,
+		entity.CreatedAt,
+	)
+	if err != nil {
+		return errors.WithMessage(err, "insert watcher")
+	}
+	return nil
+}
+
+func (repo *SQLRepository) deleteWatchers(ctx context.Context, boardID string) error {
+	_, err := repo.db.ExecContext(ctx, deleteWatcherQuery+" WHERE board_id = ?", boardID)
+	if err != nil {
+		return errors.Wrap(err, "delete watchers")
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package card
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/apierror"
+)
+
+// maxBatchSize caps the number of cards read or updated by one batch call.
+const maxBatchSize = 100
+
+// BatchResult is the outcome of a batch call for one requested ID. Err is
+// set instead of Card when that card couldn't be resolved or updated.
+type BatchResult struct {
+	ID   string
+	Card *Card
+	Err  error
+}
+
+// Patch is a partial update of a card; nil fields are left unchanged and an
+// empty due date clears it.
+type Patch struct {
+	CardID             string  `json:"card_id" validate:"required"`
+	Title              *string `json:"title"`
+	Description        *string `json:"description"`
+	DueDateFrom        *string `json:"due_date_from"`
+	DueDateUntil       *string `json:"due_date_until"`
+	DueDateIsCompleted *bool   `json:"due_date_is_completed"`
+}
+
+func (c *Card) ApplyPatch(p Patch) error {
+	if p.Title != nil {
+		if strings.TrimSpace(*p.Title) == "" {
+			return apierror.WithDesc(ErrorCodeInvalidInput, "title is mandatory")
+		}
+		c.Title = *p.Title
+	}
+	if p.Description != nil {
+		c.Description = *p.Description
+	}
+	if p.DueDateFrom != nil {
+		dueDateFrom, err := parseDueDate(*p.DueDateFrom)
+		if err != nil {
+			return errors.Wrap(err, "parse due date from")
+		}
+		c.DueDateFrom = dueDateFrom
+	}
+	if p.DueDateUntil != nil {
+		dueDateUntil, err := parseDueDate(*p.DueDateUntil)
+		if err != nil {
+			return errors.Wrap(err, "parse due date until")
+		}
+		c.DueDateUntil = dueDateUntil
+	}
+	now := time.Now()
+	if p.DueDateIsCompleted != nil {
+		if !*p.DueDateIsCompleted {
+			c.DueDateCompletedAt = nil
+		} else if c.DueDateCompletedAt == nil {
+			c.DueDateCompletedAt = &now
+		}
+	}
+	c.UpdatedAt = now
+	return nil
+}
+
+func parseDueDate(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	res, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, apierror.WithDesc(ErrorCodeInvalidInput, "due date must be RFC 3339")
+	}
+	return &res, nil
+}
+
+func validateBatchSize(size int) error {
+	if size == 0 || size > maxBatchSize {
+		return apierror.WithDesc(ErrorCodeInvalidInput, "a batch takes 1 to 100 cards")
+	}
+	return nil
+}
@@ -3,22 +3,59 @@ package card
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 
-	redis "github.com/redis/go-redis/v9"
+	"github.com/pkg/errors"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/apierror"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/cache"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/database"
 )
 
 type cachedRepository struct {
 	sqlRepo Repository
-	client  *redis.Client
+	cache   *cache.Cache
 }
 
 const (
 	cachedKey = "card:%s"
+	// public IDs and keys never move to another card, so their mapping to
+	// the entity ID is only invalidated to drop a cached miss
+	cachedPublicIDKey = "card:public_id:%s"
+	cachedCardKeyKey  = "card:key:%s"
 )
 
-func NewCachedRepository(repo Repository, redisClient *redis.Client) Repository {
-	return &cachedRepository{repo, redisClient}
+func NewCachedRepository(repo Repository, c *cache.Cache) Repository {
+	return &cachedRepository{repo, c}
+}
+
+// fetch translates between the card's and the cache's not found errors, and
+// skips the cache inside a transaction.
+func (repo *cachedRepository) fetch(ctx context.Context, key string, load func() (string, error)) (string, error) {
+	if database.InTransaction(ctx) {
+		return load()
+	}
+	val, err := repo.cache.Fetch(ctx, key, func() (string, error) {
+		val, err := load()
+		if apiErr, ok := errors.Cause(err).(apierror.APIError); ok && apiErr.Code == ErrorCodeEntityNotFound {
+			return "", cache.ErrNotFound
+		}
+		return val, err
+	})
+	if err == cache.ErrNotFound {
+		return "", apierror.WithDesc(ErrorCodeEntityNotFound, "card couldn't be found")
+	}
+	return val, err
+}
+
+// keys are the keys to invalidate when the card is written.
+func (repo *cachedRepository) keys(entity Card) []string {
+	keys := []string{
+		repo.cache.Key(cachedKey, entity.ID),
+		repo.cache.Key(cachedPublicIDKey, entity.PublicID),
+	}
+	if entity.Key != "" {
+		keys = append(keys, repo.cache.Key(cachedCardKeyKey, entity.Key))
+	}
+	return keys
 }
 
 func (repo *cachedRepository) Store(ctx context.Context, entity *Card) error {
@@ -26,10 +63,20 @@ func (repo *cachedRepository) Store(ctx context.Context, entity *Card) error {
 	if err != nil {
 		return err
 	}
-	err = repo.client.Del(ctx, fmt.Sprintf(cachedKey, entity.ID)).Err()
+	invalidate(ctx, repo.cache, repo.keys(*entity)...)
+	return nil
+}
+
+func (repo *cachedRepository) StoreAll(ctx context.Context, entities []Card) error {
+	err := repo.sqlRepo.StoreAll(ctx, entities)
 	if err != nil {
 		return err
 	}
+	var keys []string
+	for _, entity := range entities {
+		keys = append(keys, repo.keys(entity)...)
+	}
+	invalidate(ctx, repo.cache, keys...)
 	return nil
 }
 
@@ -38,87 +85,143 @@ func (repo *cachedRepository) StoreLabels(ctx context.Context, cardID string, la
 	if err != nil {
 		return err
 	}
-	err = repo.client.Del(ctx, fmt.Sprintf(cachedKey, cardID)).Err()
+	invalidate(ctx, repo.cache, repo.cache.Key(cachedKey, cardID))
+	return nil
+}
+
+// StoreRelation invalidates both cards since each of them shows the relation.
+func (repo *cachedRepository) StoreRelation(ctx context.Context, relation Relation) error {
+	err := repo.sqlRepo.StoreRelation(ctx, relation)
 	if err != nil {
 		return err
 	}
+	invalidate(ctx, repo.cache, repo.cache.Key(cachedKey, relation.CardID), repo.cache.Key(cachedKey, relation.RelatedCardID))
 	return nil
 }
 
-func (repo *cachedRepository) ResolveByID(ctx context.Context, id string) (*Card, error) {
-	val, err := repo.client.Get(ctx, fmt.Sprintf(cachedKey, id)).Result()
+func (repo *cachedRepository) DeleteRelation(ctx context.Context, relation Relation) error {
+	err := repo.sqlRepo.DeleteRelation(ctx, relation)
 	if err != nil {
-		if err != redis.Nil {
-			return nil, err
+		return err
+	}
+	invalidate(ctx, repo.cache, repo.cache.Key(cachedKey, relation.CardID), repo.cache.Key(cachedKey, relation.RelatedCardID))
+	return nil
+}
+
+func (repo *cachedRepository) ResolveRelationsByCardIDs(ctx context.Context, cardIDs []string) ([]Relation, error) {
+	return repo.sqlRepo.ResolveRelationsByCardIDs(ctx, cardIDs)
+}
+
+func (repo *cachedRepository) ResolveIDByPublicID(ctx context.Context, publicID string) (string, error) {
+	return repo.fetch(ctx, repo.cache.Key(cachedPublicIDKey, publicID), func() (string, error) {
+		return repo.sqlRepo.ResolveIDByPublicID(ctx, publicID)
+	})
+}
+
+func (repo *cachedRepository) ResolveIDByKey(ctx context.Context, key string) (string, error) {
+	return repo.fetch(ctx, repo.cache.Key(cachedCardKeyKey, key), func() (string, error) {
+		return repo.sqlRepo.ResolveIDByKey(ctx, key)
+	})
+}
+
+func (repo *cachedRepository) ResolveIDsByCustomFieldID(ctx context.Context, fieldID string) ([]string, error) {
+	return repo.sqlRepo.ResolveIDsByCustomFieldID(ctx, fieldID)
+}
+
+func (repo *cachedRepository) ResolveByID(ctx context.Context, id string) (*Card, error) {
+	val, err := repo.fetch(ctx, repo.cache.Key(cachedKey, id), func() (string, error) {
+		res, err := repo.sqlRepo.ResolveByID(ctx, id)
+		if err != nil {
+			return "", err
 		}
-	} else {
-		var res Card
-		if err := json.Unmarshal([]byte(val), &res); err != nil {
-			return nil, err
+		bt, err := json.Marshal(res)
+		if err != nil {
+			return "", err
 		}
-		return &res, nil
-	}
-	res, err := repo.sqlRepo.ResolveByID(ctx, id)
-	if err != nil {
-		return nil, err
-	}
-	bt, err := json.Marshal(res)
+		return string(bt), nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	err = repo.client.Set(ctx, fmt.Sprintf(cachedKey, id), string(bt), 0).Err()
-	if err != nil {
+	var res Card
+	if err := json.Unmarshal([]byte(val), &res); err != nil {
 		return nil, err
 	}
-	return res, nil
+	return &res, nil
 }
 
 func (repo *cachedRepository) ResolveAllIDsByFilter(ctx context.Context, filter Filter) ([]string, error) {
 	return repo.sqlRepo.ResolveAllIDsByFilter(ctx, filter)
 }
 
+// ResolveAllByFilter pages and sorts with the ID query, then reads the cards
+// of the page from the cache, loading the misses from SQL in one query and
+// writing them back in one round trip.
 func (repo *cachedRepository) ResolveAllByFilter(ctx context.Context, filter Filter) (res []Card, err error) {
+	// an empty filter reads every card, it's only served a page at a time
+	if filter.IsEmpty() && filter.Limit <= 0 {
+		return nil, nil
+	}
+	if database.InTransaction(ctx) {
+		return repo.sqlRepo.ResolveAllByFilter(ctx, filter)
+	}
 	ids, err := repo.sqlRepo.ResolveAllIDsByFilter(ctx, filter)
 	if err != nil {
-		return
+		return nil, err
 	}
-	var keys []string
-	for _, id := range ids {
-		keys = append(keys, fmt.Sprintf(cachedKey, id))
+	if len(ids) == 0 {
+		return nil, nil
 	}
-	if len(keys) == 0 {
-		return
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = repo.cache.Key(cachedKey, id)
 	}
-	bts, err := repo.client.MGet(ctx, keys...).Result()
+	vals, err := repo.cache.MGet(ctx, keys...)
 	if err != nil {
-		return
+		return nil, err
 	}
-	cardMap := make(map[string]Card, 0)
-	for _, bt := range bts {
-		if bt == nil {
+	cardMap := make(map[string]Card, len(ids))
+	var missed []string
+	for i, val := range vals {
+		if val == nil {
+			missed = append(missed, ids[i])
 			continue
 		}
 		var card Card
-		if err := json.Unmarshal([]byte(bt.(string)), &card); err != nil {
-			return res, err
+		if err := json.Unmarshal([]byte(val.(string)), &card); err != nil {
+			return nil, err
 		}
-		cardMap[card.ID] = card
+		filter.Projection.Apply(&card)
+		cardMap[ids[i]] = card
 	}
-	var noCached []string
-	for _, id := range ids {
-		_, exist := cardMap[id]
-		if !exist {
-			noCached = append(noCached, id)
+	if len(missed) > 0 {
+		cards, err := repo.sqlRepo.ResolveAllByFilter(ctx, Filter{IDs: missed, Projection: filter.Projection})
+		if err != nil {
+			return nil, err
 		}
+		writeBack := make(map[string]string, 0)
+		for _, card := range cards {
+			cardMap[card.ID] = card
+			// a projected card lacks collections other readers expect
+			if filter.Projection != nil {
+				continue
+			}
+			bt, err := json.Marshal(card)
+			if err != nil {
+				return nil, err
+			}
+			writeBack[repo.cache.Key(cachedKey, card.ID)] = string(bt)
+		}
+		repo.cache.SetAll(ctx, writeBack)
 	}
-	cards, err := repo.sqlRepo.ResolveAllByFilter(ctx, Filter{IDs: noCached})
-	if err != nil {
-		return
-	}
-	for _, val := range cardMap {
-		cards = append(cards, val)
+	// keep the order of the ID query, which honours Filter.Sort
+	res = make([]Card, 0, len(ids))
+	for _, id := range ids {
+		if card, exist := cardMap[id]; exist {
+			res = append(res, card)
+		}
 	}
-	return cards, nil
+	return res, nil
 }
 
 func (repo *cachedRepository) ResolveIDsByFilter(ctx context.Context, filter Filter, limit int) ([]string, error) {
@@ -128,3 +231,23 @@ func (repo *cachedRepository) ResolveIDsByFilter(ctx context.Context, filter Fil
 func (repo *cachedRepository) CountByFilter(ctx context.Context, filter Filter) (int, error) {
 	return repo.sqlRepo.CountByFilter(ctx, filter)
 }
+
+func (repo *cachedRepository) CountListCardsForUpdate(ctx context.Context, listID string) (int, error) {
+	return repo.sqlRepo.CountListCardsForUpdate(ctx, listID)
+}
+
+func (repo *cachedRepository) ResolveAllByIDsForUpdate(ctx context.Context, ids []string) ([]Card, error) {
+	return repo.sqlRepo.ResolveAllByIDsForUpdate(ctx, ids)
+}
+
+func (repo *cachedRepository) LockByIDs(ctx context.Context, ids []string) error {
+	return repo.sqlRepo.LockByIDs(ctx, ids)
+}
+
+// invalidate deletes the keys once the transaction of ctx commits, a read in
+// between would otherwise cache the rows again before the commit.
+func invalidate(ctx context.Context, c *cache.Cache, keys ...string) {
+	database.AfterCommit(ctx, func() {
+		c.Del(ctx, keys...)
+	})
+}
@@ -0,0 +1,64 @@
+package card
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+type Checklist struct {
+	ID        string          `json:"entity_id" db:"entity_id"`
+	CardID    string          `json:"card_id" db:"card_id"`
+	Title     string          `json:"title" db:"title"`
+	Position  int             `json:"position" db:"position"`
+	Items     []ChecklistItem `json:"items"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+}
+
+type ChecklistItem struct {
+	ID          string    `json:"entity_id" db:"entity_id"`
+	ChecklistID string    `json:"checklist_id" db:"checklist_id"`
+	CardID      string    `json:"card_id" db:"card_id"`
+	Title       string    `json:"title" db:"title"`
+	Checked     bool      `json:"checked" db:"checked"`
+	Position    int       `json:"position" db:"position"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type ChecklistInput struct {
+	Title string   `json:"title" validate:"required"`
+	Items []string `json:"items"`
+}
+
+func (input ChecklistInput) ToEntity(cardID string, position int) (Checklist, error) {
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return Checklist{}, errors.WithStack(err)
+	}
+	now := time.Now()
+	checklist := Checklist{
+		ID:        id.String(),
+		CardID:    cardID,
+		Title:     input.Title,
+		Position:  position,
+		CreatedAt: now,
+	}
+	for i, title := range input.Items {
+		itemID, err := uuid.NewUUID()
+		if err != nil {
+			return Checklist{}, errors.WithStack(err)
+		}
+		checklist.Items = append(checklist.Items, ChecklistItem{
+			ID:          itemID.String(),
+			ChecklistID: checklist.ID,
+			CardID:      cardID,
+			Title:       title,
+			Position:    i,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		})
+	}
+	return checklist, nil
+}
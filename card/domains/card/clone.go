@@ -0,0 +1,312 @@
+package card
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/database"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/domains/board"
+)
+
+// BoardCardCopier copies the cards and card templates of a board into its
+// clone as part of the board clone transaction.
+type BoardCardCopier struct {
+	repo         *SQLRepository
+	templateRepo *TemplateSQLRepository
+}
+
+func NewBoardCardCopier(db *database.MySQL) board.CardCopier {
+	return &BoardCardCopier{repo: &SQLRepository{db: db}, templateRepo: &TemplateSQLRepository{db: db}}
+}
+
+func (c *BoardCardCopier) CopyTemplates(ctx context.Context, mapping board.CloneMapping) error {
+	templates, err := c.templateRepo.ResolveAllByBoardID(ctx, mapping.SourceBoardID)
+	if err != nil {
+		return errors.Wrap(err, "resolve card templates by board id")
+	}
+	for _, source := range templates {
+		template, err := source.CloneTo(mapping)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		err = c.templateRepo.Store(ctx, template)
+		if err != nil {
+			return errors.Wrap(err, "store card template")
+		}
+	}
+	return nil
+}
+
+func (c *BoardCardCopier) CopyCards(ctx context.Context, mapping board.CloneMapping) error {
+	cards, err := c.repo.ResolveAllByFilter(ctx, Filter{BoardIDs: []string{mapping.SourceBoardID}})
+	if err != nil {
+		return errors.Wrap(err, "resolve cards by board id")
+	}
+	cardIDs := make(map[string]string, 0)
+	clones := make([]Card, 0)
+	for _, source := range cards {
+		if source.DeletedAt != nil {
+			continue
+		}
+		if _, exist := mapping.Lists[source.ListID]; !exist {
+			continue
+		}
+		entity, err := source.CloneTo(mapping)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		entity.PublicID, err = generateCode(ctx, c.repo, 0)
+		if err != nil {
+			return errors.Wrap(err, "generate card public id")
+		}
+		clones = append(clones, *entity)
+		cardIDs[source.ID] = entity.ID
+	}
+	err = c.repo.insertAll(ctx, clones)
+	if err != nil {
+		return errors.Wrap(err, "insert cards")
+	}
+	// only relations between cards of the board come along, each once
+	seen := make(map[string]bool, 0)
+	for _, source := range cards {
+		for _, r := range source.Relations {
+			cardID, exist := cardIDs[r.CardID]
+			relatedCardID, relatedExist := cardIDs[r.RelatedCardID]
+			if !exist || !relatedExist || seen[r.ID] {
+				continue
+			}
+			seen[r.ID] = true
+			relation, err := NewRelation(cardID, relatedCardID, r.Type)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			_, err = c.repo.db.ExecContext(ctx, insertRelationQuery,
+				relation.ID,
+				relation.CardID,
+				relation.RelatedCardID,
+				relation.Type,
+				relation.CreatedAt,
+			)
+			if err != nil {
+				return errors.Wrap(err, "insert card relation")
+			}
+		}
+	}
+	return nil
+}
+
+// CloneTo copies the card, with new IDs, into the cloned board. Labels,
+// custom field values and options are remapped to their copies; watchers
+// aren't copied.
+func (c Card) CloneTo(mapping board.CloneMapping) (*Card, error) {
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	now := time.Now()
+	res := &Card{
+		ID:                 id.String(),
+		ListID:             mapping.Lists[c.ListID],
+		BoardID:            mapping.BoardID,
+		Title:              c.Title,
+		Description:        c.Description,
+		DueDateFrom:        c.DueDateFrom,
+		DueDateUntil:       c.DueDateUntil,
+		DueDateCompletedAt: c.DueDateCompletedAt,
+		CreatedAt:          now,
+		UpdatedAt:          now,
+	}
+	newID := func() (string, error) {
+		id, err := uuid.NewUUID()
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		return id.String(), nil
+	}
+	for _, m := range c.Members {
+		m.ID, err = newID()
+		if err != nil {
+			return nil, err
+		}
+		m.CardID = res.ID
+		m.CreatedAt = now
+		res.Members = append(res.Members, m)
+	}
+	for _, a := range c.Attachments {
+		a.ID, err = newID()
+		if err != nil {
+			return nil, err
+		}
+		a.CardID = res.ID
+		a.CreatedAt = now
+		a.UpdatedAt = now
+		res.Attachments = append(res.Attachments, a)
+	}
+	for _, l := range c.Labels {
+		labelID, exist := mapping.Labels[l.LabelID]
+		if !exist {
+			continue
+		}
+		l.ID, err = newID()
+		if err != nil {
+			return nil, err
+		}
+		l.CardID = res.ID
+		l.LabelID = labelID
+		l.CreatedAt = now
+		res.Labels = append(res.Labels, l)
+	}
+	for _, v := range c.CustomFields {
+		fieldID, exist := mapping.CustomFields[v.FieldID]
+		if !exist {
+			continue
+		}
+		v.ID, err = newID()
+		if err != nil {
+			return nil, err
+		}
+		v.CardID = res.ID
+		v.FieldID = fieldID
+		optionIDs := make(board.OptionIDs, 0)
+		for _, optionID := range v.OptionIDs {
+			if mapped, exist := mapping.Options[optionID]; exist {
+				optionIDs = append(optionIDs, mapped)
+			}
+		}
+		v.OptionIDs = optionIDs
+		v.CreatedAt = now
+		v.UpdatedAt = now
+		res.CustomFields = append(res.CustomFields, v)
+	}
+	for _, checklist := range c.Checklists {
+		checklist.ID, err = newID()
+		if err != nil {
+			return nil, err
+		}
+		checklist.CardID = res.ID
+		checklist.CreatedAt = now
+		items := make([]ChecklistItem, 0)
+		for _, item := range checklist.Items {
+			item.ID, err = newID()
+			if err != nil {
+				return nil, err
+			}
+			item.ChecklistID = checklist.ID
+			item.CardID = res.ID
+			item.CreatedAt = now
+			item.UpdatedAt = now
+			items = append(items, item)
+		}
+		checklist.Items = items
+		res.Checklists = append(res.Checklists, checklist)
+	}
+	return res, nil
+}
+
+// CloneTo copies the template, with a new ID, into the cloned board. Its
+// labels are remapped to their copies.
+func (t Template) CloneTo(mapping board.CloneMapping) (*Template, error) {
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	now := time.Now()
+	res := t
+	res.ID = id.String()
+	res.BoardID = mapping.BoardID
+	res.LabelIDs = make(StringList, 0)
+	for _, labelID := range t.LabelIDs {
+		if mapped, exist := mapping.Labels[labelID]; exist {
+			res.LabelIDs = append(res.LabelIDs, mapped)
+		}
+	}
+	res.CreatedAt = now
+	res.UpdatedAt = now
+	return &res, nil
+}
+
+type CopyOptions struct {
+	KeepMembers     bool `json:"keep_members"`
+	KeepLabels      bool `json:"keep_labels"`
+	KeepAttachments bool `json:"keep_attachments"`
+	KeepChecklists  bool `json:"keep_checklists"`
+}
+
+type CopyInput struct {
+	CardID string `json:"card_id" validate:"required"`
+	ListID string `json:"list_id" validate:"required"`
+	// Title defaults to the title of the copied card.
+	Title string `json:"title"`
+	CopyOptions
+}
+
+// Copy duplicates the card into the target list, which may belong to another
+// board. Custom field values only come along within the same board.
+func (c Card) Copy(list board.BoardList, labelMapping map[string]string, options CopyOptions) (*Card, error) {
+	mapping := board.CloneMapping{
+		SourceBoardID: c.BoardID,
+		BoardID:       list.BoardID,
+		Lists:         map[string]string{c.ListID: list.ID},
+		Labels:        labelMapping,
+	}
+	if c.BoardID == list.BoardID {
+		mapping.CustomFields = make(map[string]string, 0)
+		for _, v := range c.CustomFields {
+			mapping.CustomFields[v.FieldID] = v.FieldID
+		}
+		mapping.Options = make(map[string]string, 0)
+		for _, v := range c.CustomFields {
+			for _, optionID := range v.OptionIDs {
+				mapping.Options[optionID] = optionID
+			}
+		}
+	}
+	res, err := c.CloneTo(mapping)
+	if err != nil {
+		return nil, err
+	}
+	res.DueDateCompletedAt = nil
+	if !options.KeepMembers {
+		res.Members = nil
+	}
+	if !options.KeepLabels {
+		res.Labels = nil
+	}
+	if !options.KeepAttachments {
+		res.Attachments = nil
+	}
+	if !options.KeepChecklists {
+		res.Checklists = nil
+	}
+	return res, nil
+}
+
+// MoveTo moves the card to a list of any board. When the board changes the
+// labels are swapped for the target board's labels with the same slug, and
+// custom field values, which belong to the old board's fields, are dropped.
+func (c *Card) MoveTo(list board.BoardList, labelMapping map[string]string) {
+	if c.BoardID != list.BoardID {
+		labels := make([]Label, 0)
+		for _, l := range c.Labels {
+			labelID, exist := labelMapping[l.LabelID]
+			if !exist {
+				continue
+			}
+			l.LabelID = labelID
+			labels = append(labels, l)
+		}
+		c.Labels = labels
+		c.CustomFields = nil
+		c.BoardID = list.BoardID
+		c.Key = ""
+	}
+	c.ListID = list.ID
+	c.UpdatedAt = time.Now()
+}
+
+type BulkMoveInput struct {
+	CardIDs []string `json:"card_ids" validate:"required,min=1"`
+	ListID  string   `json:"list_id" validate:"required"`
+}
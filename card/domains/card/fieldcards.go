@@ -0,0 +1,36 @@
+package card
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/cache"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/domains/board"
+)
+
+// BoardFieldCards invalidates the cached cards whose custom field values the
+// board domain changes, when it removes options or whole fields.
+type BoardFieldCards struct {
+	repo  Repository
+	cache *cache.Cache
+}
+
+func NewBoardFieldCards(repo Repository, c *cache.Cache) board.FieldCards {
+	return &BoardFieldCards{repo: repo, cache: c}
+}
+
+func (c *BoardFieldCards) ForgetValues(ctx context.Context, fieldID string) error {
+	cardIDs, err := c.repo.ResolveIDsByCustomFieldID(ctx, fieldID)
+	if err != nil {
+		return errors.Wrap(err, "resolve card ids by custom field id")
+	}
+	if len(cardIDs) == 0 {
+		return nil
+	}
+	keys := make([]string, len(cardIDs))
+	for i, id := range cardIDs {
+		keys[i] = c.cache.Key(cachedKey, id)
+	}
+	invalidate(ctx, c.cache, keys...)
+	return nil
+}
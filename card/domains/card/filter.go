@@ -1,14 +1,39 @@
 package card
 
+import "github.com/rakateja/milo/twirp-rpc-examples/card/domains/board"
+
 type Filter struct {
-	IDs       []string `json:"ids"`
-	PublicIDs []string `json:"public_ids"`
-	CardIDs   []string `json:"card_ids"`
-	ListIDs   []string `json:"list_ids"`
-	BoardIDs  []string `json:"board_ids"`
-	UserIDs   []string `json:"user_ids"`
+	IDs          []string            `json:"ids"`
+	PublicIDs    []string            `json:"public_ids"`
+	CardIDs      []string            `json:"card_ids"`
+	ListIDs      []string            `json:"list_ids"`
+	BoardIDs     []string            `json:"board_ids"`
+	UserIDs      []string            `json:"user_ids"`
+	CustomFields []CustomFieldFilter `json:"custom_fields"`
+	// Blocked keeps only cards with at least one blocker that isn't completed.
+	Blocked bool `json:"blocked"`
+	Sort    Sort `json:"sort"`
+	// Projection limits the collections loaded with the cards.
+	Projection Projection `json:"-"`
+	// Limit pages the cards when it's set, skipping the first Offset.
+	Offset int `json:"-"`
+	Limit  int `json:"-"`
 }
 
 func (t Filter) IsEmpty() bool {
-	return len(t.IDs) == 0 && len(t.PublicIDs) == 0 && len(t.CardIDs) == 0 && len(t.ListIDs) == 0 && len(t.BoardIDs) == 0 && len(t.UserIDs) == 0
+	return len(t.IDs) == 0 && len(t.PublicIDs) == 0 && len(t.CardIDs) == 0 && len(t.ListIDs) == 0 && len(t.BoardIDs) == 0 && len(t.UserIDs) == 0 && len(t.CustomFields) == 0 && !t.Blocked
+}
+
+// CustomFieldFilter matches cards whose value for the field equals Value. For
+// select fields every option in Value must be set on the card.
+type CustomFieldFilter struct {
+	FieldID string           `json:"field_id"`
+	Value   board.FieldValue `json:"value"`
+}
+
+// Sort orders cards by their value of a custom field. Cards without a value
+// come first in ascending order.
+type Sort struct {
+	CustomFieldID string `json:"custom_field_id"`
+	Descending    bool   `json:"descending"`
 }
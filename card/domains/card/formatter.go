@@ -5,6 +5,8 @@ import (
 
 	timestampPb "github.com/golang/protobuf/ptypes/timestamp"
 	pb "github.com/rakateja/milo/twirp-rpc-examples/card/proto/rpcproto"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/servers"
+	"github.com/twitchtv/twirp"
 )
 
 func ToCardPagePb(t CardPage) *pb.CardPage {
@@ -19,6 +21,8 @@ func ToCardPb(t Card) *pb.Card {
 		Id:                 t.ID,
 		ListId:             t.ListID,
 		PublicId:           t.PublicID,
+		Key:                t.Key,
+		PreviousKeys:       t.PreviousKeys,
 		Title:              t.Title,
 		Description:        t.Description,
 		DueDateFrom:        ToTimestampPb(t.DueDateFrom),
@@ -26,6 +30,11 @@ func ToCardPb(t Card) *pb.Card {
 		DueDateCompletedAt: ToTimestampPb(t.DueDateCompletedAt),
 		Members:            ToCardMembers(t.Members),
 		Attachments:        ToCardAttachments(t.Attachments),
+		Labels:             ToCardLabels(t.Labels),
+		Watchers:           ToCardWatchers(t.Watchers),
+		CustomFields:       ToCardCustomFieldValues(t.CustomFields),
+		Relations:          ToCardRelations(t.Relations),
+		Checklists:         ToCardChecklists(t.Checklists),
 		CreatedAt:          ToTimestampPb(&t.CreatedAt),
 		UpdatedAt:          ToTimestampPb(&t.UpdatedAt),
 		DeletedAt:          ToTimestampPb(t.DeletedAt),
@@ -40,6 +49,44 @@ func ToCardListPb(ls []Card) []*pb.Card {
 	return res
 }
 
+func ToBoardViewPb(t View) *pb.BoardView {
+	res := &pb.BoardView{
+		BoardId: t.Board.ID,
+		GroupBy: string(t.GroupBy),
+	}
+	for _, l := range t.Board.Lists {
+		res.Lists = append(res.Lists, &pb.BoardList{
+			Id:           l.ID,
+			BoardId:      l.BoardID,
+			PublicId:     l.PublicID,
+			Title:        l.Title,
+			Position:     l.Position,
+			WipLimit:     ToInt32Pb(l.WipLimit),
+			WipLimitMode: string(l.WipLimitMode),
+			CardCount:    int32(l.CardCount),
+			CreatedAt:    ToTimestampPb(&l.CreatedAt),
+			UpdatedAt:    ToTimestampPb(&l.UpdatedAt),
+			ArchivedAt:   ToTimestampPb(l.ArchivedAt),
+		})
+	}
+	for _, lane := range t.Swimlanes {
+		swimlane := &pb.Swimlane{
+			Key:       lane.Key,
+			Title:     lane.Title,
+			CardCount: int32(lane.CardCount),
+		}
+		for _, cell := range lane.Cells {
+			swimlane.Cells = append(swimlane.Cells, &pb.SwimlaneCell{
+				ListId:    cell.ListID,
+				CardCount: int32(len(cell.Cards)),
+				Cards:     ToCardListPb(cell.Cards),
+			})
+		}
+		res.Swimlanes = append(res.Swimlanes, swimlane)
+	}
+	return res
+}
+
 func ToCardMembers(ls []Member) (res []*pb.CardMember) {
 	for _, t := range ls {
 		res = append(res, &pb.CardMember{
@@ -67,12 +114,105 @@ func ToCardAttachments(ls []Attachment) (res []*pb.CardAttachment) {
 	return
 }
 
+func ToCardLabels(ls []Label) (res []*pb.CardLabel) {
+	for _, t := range ls {
+		res = append(res, &pb.CardLabel{
+			Id:        t.ID,
+			CardId:    t.CardID,
+			LabelId:   t.LabelID,
+			CreatedAt: ToTimestampPb(&t.CreatedAt),
+		})
+	}
+	return
+}
+
+func ToCardChecklists(ls []Checklist) (res []*pb.CardChecklist) {
+	for _, t := range ls {
+		checklist := &pb.CardChecklist{
+			Id:        t.ID,
+			CardId:    t.CardID,
+			Title:     t.Title,
+			Position:  int32(t.Position),
+			CreatedAt: ToTimestampPb(&t.CreatedAt),
+		}
+		for _, item := range t.Items {
+			checklist.Items = append(checklist.Items, &pb.CardChecklistItem{
+				Id:          item.ID,
+				ChecklistId: item.ChecklistID,
+				Title:       item.Title,
+				Checked:     item.Checked,
+				Position:    int32(item.Position),
+			})
+		}
+		res = append(res, checklist)
+	}
+	return
+}
+
+func ToCardWatchers(ls []Watcher) (res []*pb.CardWatcher) {
+	for _, t := range ls {
+		res = append(res, &pb.CardWatcher{
+			Id:        t.ID,
+			CardId:    t.CardID,
+			UserId:    t.UserID,
+			CreatedAt: ToTimestampPb(&t.CreatedAt),
+		})
+	}
+	return
+}
+
+func ToCardRelations(ls []Relation) (res []*pb.CardRelation) {
+	for _, t := range ls {
+		res = append(res, &pb.CardRelation{
+			Id:            t.ID,
+			CardId:        t.CardID,
+			RelatedCardId: t.RelatedCardID,
+			Type:          string(t.Type),
+			CreatedAt:     ToTimestampPb(&t.CreatedAt),
+		})
+	}
+	return
+}
+
+func ToRelationInput(pbInput *pb.CardRelationInput) RelationInput {
+	return RelationInput{
+		CardID:        pbInput.CardId,
+		RelatedCardID: pbInput.RelatedCardId,
+		Type:          RelationType(pbInput.Type),
+	}
+}
+
+func ToCardCustomFieldValues(ls []CustomFieldValue) (res []*pb.CardCustomFieldValue) {
+	for _, t := range ls {
+		value := &pb.CardCustomFieldValue{
+			Id:        t.ID,
+			CardId:    t.CardID,
+			FieldId:   t.FieldID,
+			DateValue: ToTimestampPb(t.Date),
+			OptionIds: t.OptionIDs,
+			UpdatedAt: ToTimestampPb(&t.UpdatedAt),
+			Error:     t.Error,
+		}
+		if t.Text != nil {
+			value.TextValue = *t.Text
+		}
+		if t.Number != nil {
+			value.NumberValue = *t.Number
+		}
+		if t.Checked != nil {
+			value.Checked = *t.Checked
+		}
+		res = append(res, value)
+	}
+	return
+}
+
 func ToCardInput(pbInput *pb.CardInput) CardInput {
 	var dueDateFrom, dueDateUntil *string
-	if pbInput.DueDateFrom == "" {
+	if pbInput.DueDateFrom != "" {
 		dueDateFrom = &pbInput.DueDateFrom
 	}
-	if pbInput.DueDateUntil == "" {
+	if pbInput.DueDateUntil != "" {
 		dueDateUntil = &pbInput.DueDateUntil
 	}
 	return CardInput{
@@ -84,6 +224,123 @@ func ToCardInput(pbInput *pb.CardInput) CardInput {
 		DueDateUntil:       dueDateUntil,
 		DueDateIsCompleted: pbInput.DueDateIsCompleted,
 		Members:            ToCardMemberInputFromPb(pbInput.Members),
+		CustomFields:       ToCustomFieldValueInputs(pbInput.CustomFields),
+		LabelIDs:           pbInput.LabelIds,
+		Checklists:         ToChecklistInputs(pbInput.Checklists),
+	}
+}
+
+func ToChecklistInputs(ls []*pb.ChecklistInput) []ChecklistInput {
+	res := make([]ChecklistInput, 0)
+	for _, inputPb := range ls {
+		res = append(res, ChecklistInput{
+			Title: inputPb.Title,
+			Items: inputPb.Items,
+		})
+	}
+	return res
+}
+
+func ToChecklistInputsPb(ls []TemplateChecklist) (res []*pb.ChecklistInput) {
+	for _, t := range ls {
+		res = append(res, &pb.ChecklistInput{
+			Title: t.Title,
+			Items: t.Items,
+		})
+	}
+	return
+}
+
+func ToCardTemplatePb(t Template) *pb.CardTemplate {
+	res := &pb.CardTemplate{
+		Id:           t.ID,
+		BoardId:      t.BoardID,
+		Name:         t.Name,
+		TitlePattern: t.TitlePattern,
+		Description:  t.Description,
+		LabelIds:     t.LabelIDs,
+		MemberIds:    t.MemberIDs,
+		Checklists:   ToChecklistInputsPb(t.Checklists),
+		CreatedAt:    ToTimestampPb(&t.CreatedAt),
+		UpdatedAt:    ToTimestampPb(&t.UpdatedAt),
+	}
+	if t.DueInDays != nil {
+		dueInDays := int32(*t.DueInDays)
+		res.DueInDays = &dueInDays
+	}
+	return res
+}
+
+func ToCardTemplateListPb(ls []Template) *pb.CardTemplateList {
+	res := &pb.CardTemplateList{}
+	for _, t := range ls {
+		res.Templates = append(res.Templates, ToCardTemplatePb(t))
+	}
+	return res
+}
+
+func ToTemplateInput(pbInput *pb.CardTemplateInput) TemplateInput {
+	input := TemplateInput{
+		BoardID:      pbInput.BoardId,
+		Name:         pbInput.Name,
+		TitlePattern: pbInput.TitlePattern,
+		Description:  pbInput.Description,
+		LabelIDs:     pbInput.LabelIds,
+		MemberIDs:    pbInput.MemberIds,
+	}
+	for _, c := range pbInput.Checklists {
+		input.Checklists = append(input.Checklists, TemplateChecklist{Title: c.Title, Items: c.Items})
+	}
+	if pbInput.DueInDays != nil {
+		dueInDays := int(*pbInput.DueInDays)
+		input.DueInDays = &dueInDays
+	}
+	return input
+}
+
+func ToTemplateCardInput(pbInput *pb.CreateFromTemplateInput) TemplateCardInput {
+	values := make(map[string]string, 0)
+	for _, v := range pbInput.Values {
+		values[v.Key] = v.Value
+	}
+	return TemplateCardInput{
+		TemplateID: pbInput.TemplateId,
+		ListID:     pbInput.ListId,
+		Values:     values,
+	}
+}
+
+func ToCopyInput(pbInput *pb.CopyCardInput) CopyInput {
+	return CopyInput{
+		CardID: pbInput.CardId,
+		ListID: pbInput.ListId,
+		Title:  pbInput.Title,
+		CopyOptions: CopyOptions{
+			KeepMembers:     pbInput.KeepMembers,
+			KeepLabels:      pbInput.KeepLabels,
+			KeepAttachments: pbInput.KeepAttachments,
+			KeepChecklists:  pbInput.KeepChecklists,
+		},
+	}
+}
+
+func ToCustomFieldValueInputs(ls []*pb.CustomFieldValueInput) []CustomFieldValueInput {
+	res := make([]CustomFieldValueInput, 0)
+	for _, inputPb := range ls {
+		res = append(res, CustomFieldValueInput{
+			FieldID:   inputPb.FieldId,
+			Value:     inputPb.Value,
+			OptionIDs: inputPb.OptionIds,
+		})
+	}
+	return res
+}
+
+func ToAttachmentInput(pbInput *pb.CardAttachmentInput) AttachmentInput {
+	return AttachmentInput{
+		LinkName: pbInput.LinkName,
+		FileType: pbInput.FileType,
+		FileURL:  pbInput.FileUrl,
 	}
 }
 
@@ -97,6 +354,14 @@ func ToCardMemberInputFromPb(ls []*pb.AddMemberInput) []MemberInput {
 	return res
 }
 
+func ToInt32Pb(v *int) *int32 {
+	if v == nil {
+		return nil
+	}
+	res := int32(*v)
+	return &res
+}
+
 func ToTimestampPb(ts *time.Time) *timestampPb.Timestamp {
 	if ts == nil {
 		return nil
@@ -106,3 +371,39 @@ func ToTimestampPb(ts *time.Time) *timestampPb.Timestamp {
 		Nanos:   int32(ts.Nanosecond()),
 	}
 }
+
+// ToPatches turns the patch inputs of a batch update into patches, applied
+// in the order they're given.
+func ToPatches(ls []*pb.CardPatchInput) []Patch {
+	res := make([]Patch, 0)
+	for _, p := range ls {
+		res = append(res, Patch{
+			CardID:             p.Id,
+			Title:              p.Title,
+			Description:        p.Description,
+			DueDateFrom:        p.DueDateFrom,
+			DueDateUntil:       p.DueDateUntil,
+			DueDateIsCompleted: p.DueDateIsCompleted,
+		})
+	}
+	return res
+}
+
+func ToCardBatchResultPb(ls []BatchResult) *pb.CardBatchResult {
+	res := &pb.CardBatchResult{}
+	for _, r := range ls {
+		item := &pb.CardBatchItem{Id: r.ID}
+		if r.Err != nil {
+			item.Error = ToBatchErrorPb(r.Err)
+		} else {
+			item.Card = ToCardPb(*r.Card)
+		}
+		res.Items = append(res.Items, item)
+	}
+	return res
+}
+
+func ToBatchErrorPb(err error) *pb.BatchError {
+	twerr := servers.ToTwirpError(err).(twirp.Error)
+	return &pb.BatchError{Code: string(twerr.Code()), Msg: twerr.Msg()}
+}
@@ -0,0 +1,73 @@
+package card
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/domains/board"
+)
+
+// BoardListCards handles the cards of lists that are moved to another board
+// or deleted.
+type BoardListCards struct {
+	repo Repository
+}
+
+func NewBoardListCards(repo Repository) board.ListCards {
+	return &BoardListCards{repo: repo}
+}
+
+func (c *BoardListCards) CountCards(ctx context.Context, listID string) (int, error) {
+	cards, err := c.repo.ResolveAllByFilter(ctx, Filter{ListIDs: []string{listID}})
+	if err != nil {
+		return 0, errors.Wrap(err, "resolve cards by list id")
+	}
+	var total int
+	for _, entity := range cards {
+		if entity.DeletedAt == nil {
+			total++
+		}
+	}
+	return total, nil
+}
+
+func (c *BoardListCards) MoveCards(ctx context.Context, listID string, target board.BoardList, targetBoard board.Board, labelMapping map[string]string) error {
+	cards, err := c.repo.ResolveAllByFilter(ctx, Filter{ListIDs: []string{listID}})
+	if err != nil {
+		return errors.Wrap(err, "resolve cards by list id")
+	}
+	if len(cards) == 0 {
+		return nil
+	}
+	err = checkWipLimit(ctx, c.repo, target, incomingCards(cards, target.ID))
+	if err != nil {
+		return err
+	}
+	for i := range cards {
+		cards[i].MoveTo(target, labelMapping)
+		cards[i].Members = membersWithAccess(cards[i].Members, &targetBoard)
+	}
+	return c.repo.StoreAll(ctx, cards)
+}
+
+func (c *BoardListCards) DeleteCards(ctx context.Context, listID string) error {
+	cards, err := c.repo.ResolveAllByFilter(ctx, Filter{ListIDs: []string{listID}})
+	if err != nil {
+		return errors.Wrap(err, "resolve cards by list id")
+	}
+	now := time.Now()
+	deleted := make([]Card, 0)
+	for _, entity := range cards {
+		if entity.DeletedAt != nil {
+			continue
+		}
+		entity.DeletedAt = &now
+		entity.UpdatedAt = now
+		deleted = append(deleted, entity)
+	}
+	if len(deleted) == 0 {
+		return nil
+	}
+	return c.repo.StoreAll(ctx, deleted)
+}
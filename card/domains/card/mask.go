@@ -0,0 +1,73 @@
+package card
+
+import (
+	"fmt"
+
+	"github.com/rakateja/milo/twirp-rpc-examples/card/apierror"
+)
+
+// updatePaths are the CardInput fields an update mask can name. An empty
+// mask names all of them, so every one is overwritten. list_id moves the card
+// within its board, the board only changes by moving the card to a list of
+// another one.
+var updatePaths = []string{
+	"list_id",
+	"title",
+	"description",
+	"due_date_from",
+	"due_date_until",
+	"due_date_is_completed",
+	"members",
+	"label_ids",
+	"custom_fields",
+}
+
+// UpdateMask is the set of CardInput fields an update applies.
+type UpdateMask map[string]bool
+
+func NewUpdateMask(paths []string) (UpdateMask, error) {
+	if len(paths) == 0 {
+		paths = updatePaths
+	}
+	known := make(map[string]bool, 0)
+	for _, p := range updatePaths {
+		known[p] = true
+	}
+	res := make(UpdateMask, 0)
+	for _, p := range paths {
+		if !known[p] {
+			return nil, apierror.WithDesc(ErrorCodeInvalidInput, fmt.Sprintf("%s can't be updated", p))
+		}
+		res[p] = true
+	}
+	return res, nil
+}
+
+// ToPatch takes the masked fields of the input. A masked due date that's
+// empty clears the card's due date.
+func (input CardInput) ToPatch(cardID string, mask UpdateMask) Patch {
+	res := Patch{CardID: cardID}
+	var cleared string
+	if mask["title"] {
+		res.Title = &input.Title
+	}
+	if mask["description"] {
+		res.Description = &input.Description
+	}
+	if mask["due_date_from"] {
+		res.DueDateFrom = &cleared
+		if input.DueDateFrom != nil {
+			res.DueDateFrom = input.DueDateFrom
+		}
+	}
+	if mask["due_date_until"] {
+		res.DueDateUntil = &cleared
+		if input.DueDateUntil != nil {
+			res.DueDateUntil = input.DueDateUntil
+		}
+	}
+	if mask["due_date_is_completed"] {
+		res.DueDateIsCompleted = &input.DueDateIsCompleted
+	}
+	return res
+}
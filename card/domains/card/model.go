@@ -1,38 +1,70 @@
 package card
 
 import (
-	"fmt"
 	"strings"
 	"time"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"github.com/rakateja/milo/twirp-rpc-examples/card/apierror"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/domains/board"
 )
 
 const (
 	ErrorCodeEntityNotFound = "EntityNotFound"
 	ErrorCodeInvalidInput   = "InvalidInput"
 	ErrorCodeAlreadyExist   = "AlreadyExist"
+	// ErrorCodeWipLimitExceeded is returned when a card would take a list
+	// over its WIP limit.
+	ErrorCodeWipLimitExceeded = "WipLimitExceeded"
 )
 
 type Card struct {
-	ID                 string       `json:"entity_id" db:"entity_id"`
-	ListID             string       `json:"list_id" db:"list_id"`
-	BoardID            string       `json:"board_id" db:"board_id"`
-	PublicID           string       `json:"public_id" db:"public_id"`
-	Title              string       `json:"title" db:"title"`
-	Description        string       `json:"description" db:"description"`
-	DueDateFrom        *time.Time   `json:"due_date_from" db:"due_date_from"`
-	DueDateUntil       *time.Time   `json:"due_date_until" db:"due_date_until"`
-	DueDateCompletedAt *time.Time   `json:"due_date_completed_at" db:"due_date_completed_at"`
-	Members            []Member     `json:"members"`
-	Attachments        []Attachment `json:"attachments"`
-	Labels             []Label      `json:"labels"`
-	CreatedAt          time.Time    `json:"created_at" db:"created_at"`
-	UpdatedAt          time.Time    `json:"updated_at" db:"updated_at"`
-	DeletedAt          *time.Time   `json:"deleted_at" db:"deleted_at"`
+	ID       string `json:"entity_id" db:"entity_id"`
+	ListID   string `json:"list_id" db:"list_id"`
+	BoardID  string `json:"board_id" db:"board_id"`
+	PublicID string `json:"public_id" db:"public_id"`
+	// Key is the board-scoped key, e.g. PROJ-12. It's allocated when the card
+	// is stored without one, which also happens after it moves boards.
+	Key string `json:"key" db:"card_key"`
+	// PreviousKeys are the keys the card had on other boards.
+	PreviousKeys       []string           `json:"previous_keys"`
+	Title              string             `json:"title" db:"title"`
+	Description        string             `json:"description" db:"description"`
+	DueDateFrom        *time.Time         `json:"due_date_from" db:"due_date_from"`
+	DueDateUntil       *time.Time         `json:"due_date_until" db:"due_date_until"`
+	DueDateCompletedAt *time.Time         `json:"due_date_completed_at" db:"due_date_completed_at"`
+	Members            []Member           `json:"members"`
+	Attachments        []Attachment       `json:"attachments"`
+	Labels             []Label            `json:"labels"`
+	Watchers           []Watcher          `json:"watchers"`
+	CustomFields       []CustomFieldValue `json:"custom_fields"`
+	Checklists         []Checklist        `json:"checklists"`
+	Relations          []Relation         `json:"relations"`
+	CreatedAt          time.Time          `json:"created_at" db:"created_at"`
+	UpdatedAt          time.Time          `json:"updated_at" db:"updated_at"`
+	DeletedAt          *time.Time         `json:"deleted_at" db:"deleted_at"`
+}
+
+// deepCopy copies the card with its collections, so the copy can be changed
+// without touching the card.
+func (c Card) deepCopy() Card {
+	res := c
+	res.PreviousKeys = append([]string(nil), c.PreviousKeys...)
+	res.Members = append([]Member(nil), c.Members...)
+	res.Attachments = append([]Attachment(nil), c.Attachments...)
+	res.Labels = append([]Label(nil), c.Labels...)
+	res.Watchers = append([]Watcher(nil), c.Watchers...)
+	res.Relations = append([]Relation(nil), c.Relations...)
+	res.CustomFields = append([]CustomFieldValue(nil), c.CustomFields...)
+	for i := range res.CustomFields {
+		res.CustomFields[i].OptionIDs = append(board.OptionIDs(nil), c.CustomFields[i].OptionIDs...)
+	}
+	res.Checklists = append([]Checklist(nil), c.Checklists...)
+	for i := range res.Checklists {
+		res.Checklists[i].Items = append([]ChecklistItem(nil), c.Checklists[i].Items...)
+	}
+	return res
 }
 
 func (c *Card) MoveList(listID string) error {
@@ -63,7 +95,6 @@ func (c *Card) AddAttachment(fileURL, fileType, linkName string) error {
 func (c *Card) DeleteAttachment(attachmentID string) error {
 	updatedAttachments := make([]Attachment, 0)
 	for _, t := range c.Attachments {
-		fmt.Printf("%s %s\n", t.ID, attachmentID)
 		if strings.Trim(t.ID, " ") == strings.Trim(attachmentID, " ") {
 			continue
 		}
@@ -94,36 +125,6 @@ func (c *Card) UpdateLinkName(attachmentID string, linkName string) error {
 	return nil
 }
 
-func (c *Card) Update(input CardInput) error {
-	validate := validator.New()
-	err := validate.Struct(input)
-	if err != nil {
-		return errors.Wrap(err, "validate card input")
-	}
-	c.Title = input.Title
-	c.Description = input.Description
-	c.ListID = input.ListID
-	c.BoardID = input.BoardID
-	if input.DueDateFrom != nil && input.DueDateUntil != nil {
-		dueDateFrom, err := time.Parse(time.RFC3339, *input.DueDateFrom)
-		if err != nil {
-			return errors.Wrap(err, "parse due date from")
-		}
-		dueDateUntil, err := time.Parse(time.RFC3339, *input.DueDateUntil)
-		if err != nil {
-			return errors.Wrap(err, "parse due date until")
-		}
-		c.DueDateFrom = &dueDateFrom
-		c.DueDateUntil = &dueDateUntil
-	}
-	now := time.Now()
-	if c.DueDateCompletedAt == nil && input.DueDateIsCompleted {
-		c.DueDateCompletedAt = &now
-	}
-	c.UpdatedAt = now
-	return nil
-}
-
 func (c *Card) UpdateMembers(members []MemberInput) error {
 	currentMemberMap := make(map[string]Member, 0)
 	for _, m := range c.Members {
@@ -185,6 +186,36 @@ func (c *Card) AddLabel(labelID string) error {
 	return nil
 }
 
+// SetLabels replaces the card's labels, keeping the ones it already has.
+func (c *Card) SetLabels(labelIDs []string) error {
+	current := make(map[string]Label, 0)
+	for _, l := range c.Labels {
+		current[l.LabelID] = l
+	}
+	labels := make([]Label, 0)
+	now := time.Now()
+	for _, labelID := range labelIDs {
+		if l, exist := current[labelID]; exist {
+			labels = append(labels, l)
+			delete(current, labelID)
+			continue
+		}
+		id, err := uuid.NewUUID()
+		if err != nil {
+			return err
+		}
+		labels = append(labels, Label{
+			ID:        id.String(),
+			CardID:    c.ID,
+			LabelID:   labelID,
+			CreatedAt: now,
+		})
+	}
+	c.Labels = labels
+	c.UpdatedAt = now
+	return nil
+}
+
 func (c *Card) RemoveLabel(labelID string) {
 	var updatedLabels []Label
 	for _, label := range c.Labels {
@@ -193,6 +224,141 @@ func (c *Card) RemoveLabel(labelID string) {
 		}
 		updatedLabels = append(updatedLabels, label)
 	}
+	c.Labels = updatedLabels
+}
+
+func (c *Card) Watch(userID string) error {
+	for _, w := range c.Watchers {
+		if w.UserID == userID {
+			return apierror.New(ErrorCodeAlreadyExist)
+		}
+	}
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return err
+	}
+	c.Watchers = append(c.Watchers, Watcher{
+		ID:        id.String(),
+		CardID:    c.ID,
+		UserID:    userID,
+		CreatedAt: time.Now(),
+	})
+	return nil
+}
+
+func (c *Card) Unwatch(userID string) {
+	updatedWatchers := make([]Watcher, 0)
+	for _, w := range c.Watchers {
+		if w.UserID == userID {
+			continue
+		}
+		updatedWatchers = append(updatedWatchers, w)
+	}
+	c.Watchers = updatedWatchers
+}
+
+// SetCustomFieldValues validates the inputs against the board's custom field
+// definitions and stores them on the card. An input with an empty value clears
+// the field.
+func (c *Card) SetCustomFieldValues(fields []board.CustomField, inputs []CustomFieldValueInput) error {
+	fieldMap := make(map[string]board.CustomField, 0)
+	for _, f := range fields {
+		fieldMap[f.ID] = f
+	}
+	valueMap := make(map[string]CustomFieldValue, 0)
+	for _, v := range c.CustomFields {
+		valueMap[v.FieldID] = v
+	}
+	now := time.Now()
+	for _, input := range inputs {
+		field, exist := fieldMap[input.FieldID]
+		if !exist {
+			return apierror.WithDesc(ErrorCodeInvalidInput, "custom field doesn't belong to the card's board")
+		}
+		value, err := field.ParseValue(input.Value, input.OptionIDs)
+		if err != nil {
+			return err
+		}
+		if value.IsEmpty() {
+			delete(valueMap, field.ID)
+			continue
+		}
+		current, exist := valueMap[field.ID]
+		if !exist {
+			id, err := uuid.NewUUID()
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			current = CustomFieldValue{
+				ID:        id.String(),
+				CardID:    c.ID,
+				FieldID:   field.ID,
+				CreatedAt: now,
+			}
+		}
+		current.FieldValue = value
+		current.UpdatedAt = now
+		valueMap[field.ID] = current
+	}
+	updatedValues := make([]CustomFieldValue, 0)
+	for _, f := range fields {
+		if v, exist := valueMap[f.ID]; exist {
+			updatedValues = append(updatedValues, v)
+		}
+	}
+	c.CustomFields = updatedValues
+	c.EvaluateFormulas(fields)
+	c.UpdatedAt = now
+	return nil
+}
+
+// EvaluateFormulas recomputes the card's formula field values from its other
+// custom field values. A formula that fails keeps an empty value flagged
+// with the error.
+func (c *Card) EvaluateFormulas(fields []board.CustomField) {
+	values := make(map[string]board.FieldValue, 0)
+	currentMap := make(map[string]CustomFieldValue, 0)
+	for _, v := range c.CustomFields {
+		values[v.FieldID] = v.FieldValue
+		currentMap[v.FieldID] = v
+	}
+	computed, errs := board.EvaluateFormulas(fields, values)
+	now := time.Now()
+	updatedValues := make([]CustomFieldValue, 0)
+	for _, f := range fields {
+		current, exist := currentMap[f.ID]
+		if f.Type != board.FieldTypeFormula {
+			if exist {
+				updatedValues = append(updatedValues, current)
+			}
+			continue
+		}
+		value, computedExist := computed[f.ID]
+		evalErr, failed := errs[f.ID]
+		if !computedExist && !failed {
+			continue
+		}
+		if !exist {
+			id, err := uuid.NewUUID()
+			if err != nil {
+				continue
+			}
+			current = CustomFieldValue{
+				ID:        id.String(),
+				CardID:    c.ID,
+				FieldID:   f.ID,
+				CreatedAt: now,
+				UpdatedAt: now,
+			}
+		}
+		current.FieldValue = value
+		current.Error = ""
+		if failed {
+			current.Error = evalErr.Error()
+		}
+		updatedValues = append(updatedValues, current)
+	}
+	c.CustomFields = updatedValues
 }
 
 type Member struct {
@@ -219,16 +385,44 @@ type Label struct {
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
 
+type Watcher struct {
+	ID        string    `json:"entity_id" db:"entity_id"`
+	CardID    string    `json:"card_id" db:"card_id"`
+	UserID    string    `json:"user_id" db:"user_id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type CustomFieldValue struct {
+	ID      string `json:"entity_id" db:"entity_id"`
+	CardID  string `json:"card_id" db:"card_id"`
+	FieldID string `json:"field_id" db:"field_id"`
+	board.FieldValue
+	// Error is why a formula value couldn't be computed, such a value isn't
+	// stored.
+	Error     string    `json:"error,omitempty" db:"-"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type CustomFieldValueInput struct {
+	FieldID   string   `json:"field_id" validate:"required"`
+	Value     string   `json:"value"`
+	OptionIDs []string `json:"option_ids"`
+}
+
 type CardInput struct {
-	ListID             string            `json:"list_id" validate:"required"`
-	BoardID            string            `json:"board_id" validate:"required"`
-	Title              string            `json:"title" validate:"required"`
-	Description        string            `json:"description"`
-	DueDateFrom        *string           `json:"due_date_from"`
-	DueDateUntil       *string           `json:"due_date_until"`
-	DueDateIsCompleted bool              `json:"due_date_is_completed"`
-	Members            []MemberInput     `json:"members"`
-	Attachments        []AttachmentInput `json:"attachments"`
+	ListID             string                  `json:"list_id" validate:"required"`
+	BoardID            string                  `json:"board_id" validate:"required"`
+	Title              string                  `json:"title" validate:"required"`
+	Description        string                  `json:"description"`
+	DueDateFrom        *string                 `json:"due_date_from"`
+	DueDateUntil       *string                 `json:"due_date_until"`
+	DueDateIsCompleted bool                    `json:"due_date_is_completed"`
+	Members            []MemberInput           `json:"members"`
+	Attachments        []AttachmentInput       `json:"attachments"`
+	CustomFields       []CustomFieldValueInput `json:"custom_fields"`
+	LabelIDs           []string                `json:"label_ids"`
+	Checklists         []ChecklistInput        `json:"checklists" validate:"dive"`
 }
 
 func (input CardInput) ToEntity() (*Card, error) {
@@ -239,6 +433,23 @@ func (input CardInput) ToEntity() (*Card, error) {
 	now := time.Now()
 	var members []Member
 	var attachments []Attachment
+	var labels []Label
+	var checklists []Checklist
+	var dueDateFrom, dueDateUntil *time.Time
+	if input.DueDateFrom != nil {
+		t, err := time.Parse(time.RFC3339, *input.DueDateFrom)
+		if err != nil {
+			return nil, apierror.WithDesc(ErrorCodeInvalidInput, "due_date_from must be RFC3339")
+		}
+		dueDateFrom = &t
+	}
+	if input.DueDateUntil != nil {
+		t, err := time.Parse(time.RFC3339, *input.DueDateUntil)
+		if err != nil {
+			return nil, apierror.WithDesc(ErrorCodeInvalidInput, "due_date_until must be RFC3339")
+		}
+		dueDateUntil = &t
+	}
 	for _, m := range input.Members {
 		memberID, err := uuid.NewUUID()
 		if err != nil {
@@ -264,17 +475,38 @@ func (input CardInput) ToEntity() (*Card, error) {
 			UpdatedAt: now,
 		})
 	}
+	for _, labelID := range input.LabelIDs {
+		labelEntityID, err := uuid.NewUUID()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		labels = append(labels, Label{
+			ID:        labelEntityID.String(),
+			CardID:    id.String(),
+			LabelID:   labelID,
+			CreatedAt: now,
+		})
+	}
+	for i, c := range input.Checklists {
+		checklist, err := c.ToEntity(id.String(), i)
+		if err != nil {
+			return nil, err
+		}
+		checklists = append(checklists, checklist)
+	}
 	return &Card{
 		ID:                 id.String(),
 		BoardID:            input.BoardID,
 		ListID:             input.ListID,
 		Title:              input.Title,
 		Description:        input.Description,
-		DueDateFrom:        nil,
-		DueDateUntil:       nil,
+		DueDateFrom:        dueDateFrom,
+		DueDateUntil:       dueDateUntil,
 		DueDateCompletedAt: nil,
 		Members:            members,
 		Attachments:        attachments,
+		Labels:             labels,
+		Checklists:         checklists,
 		CreatedAt:          now,
 		UpdatedAt:          now,
 	}, nil
@@ -289,8 +521,9 @@ type MemberListInput struct {
 }
 
 type AttachmentInput struct {
-	LinkName   string `json:"link_name" validate:"required"`
-	FileBase64 string `json:"file_base64" validate:"base64"`
+	LinkName string `json:"link_name" validate:"required"`
+	FileType string `json:"file_type"`
+	FileURL  string `json:"file_url" validate:"required,url"`
 }
 
 type CardPage struct {
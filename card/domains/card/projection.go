@@ -0,0 +1,108 @@
+package card
+
+import (
+	"fmt"
+
+	"github.com/rakateja/milo/twirp-rpc-examples/card/apierror"
+)
+
+// The collections of a card that a read mask can leave out.
+const (
+	CollectionMembers      = "members"
+	CollectionAttachments  = "attachments"
+	CollectionLabels       = "labels"
+	CollectionWatchers     = "watchers"
+	CollectionCustomFields = "custom_fields"
+	CollectionChecklists   = "checklists"
+	CollectionRelations    = "relations"
+	CollectionPreviousKeys = "previous_keys"
+)
+
+var collections = []string{
+	CollectionMembers,
+	CollectionAttachments,
+	CollectionLabels,
+	CollectionWatchers,
+	CollectionCustomFields,
+	CollectionChecklists,
+	CollectionRelations,
+	CollectionPreviousKeys,
+}
+
+// scalarFields are always loaded, so naming them in a read mask is allowed
+// but changes nothing.
+var scalarFields = []string{
+	"id",
+	"list_id",
+	"board_id",
+	"public_id",
+	"key",
+	"title",
+	"description",
+	"due_date_from",
+	"due_date_until",
+	"due_date_completed_at",
+	"created_at",
+	"updated_at",
+	"deleted_at",
+}
+
+// Projection is the set of collections a read loads. A nil projection loads
+// all of them.
+type Projection map[string]bool
+
+// NewProjection builds the projection of a read mask, nil when the mask is
+// empty.
+func NewProjection(paths []string) (Projection, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]bool, 0)
+	for _, f := range collections {
+		known[f] = true
+	}
+	for _, f := range scalarFields {
+		known[f] = true
+	}
+	res := make(Projection, 0)
+	for _, p := range paths {
+		if !known[p] {
+			return nil, apierror.WithDesc(ErrorCodeInvalidInput, fmt.Sprintf("%s isn't a card field", p))
+		}
+		res[p] = true
+	}
+	return res, nil
+}
+
+func (p Projection) Includes(collection string) bool {
+	return p == nil || p[collection]
+}
+
+// Apply drops the collections the projection leaves out from a card that was
+// loaded in full.
+func (p Projection) Apply(c *Card) {
+	if !p.Includes(CollectionMembers) {
+		c.Members = nil
+	}
+	if !p.Includes(CollectionAttachments) {
+		c.Attachments = nil
+	}
+	if !p.Includes(CollectionLabels) {
+		c.Labels = nil
+	}
+	if !p.Includes(CollectionWatchers) {
+		c.Watchers = nil
+	}
+	if !p.Includes(CollectionCustomFields) {
+		c.CustomFields = nil
+	}
+	if !p.Includes(CollectionChecklists) {
+		c.Checklists = nil
+	}
+	if !p.Includes(CollectionRelations) {
+		c.Relations = nil
+	}
+	if !p.Includes(CollectionPreviousKeys) {
+		c.PreviousKeys = nil
+	}
+}
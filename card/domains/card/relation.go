@@ -0,0 +1,91 @@
+package card
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/apierror"
+)
+
+type RelationType string
+
+const (
+	// RelationTypeBlockedBy means the card can't progress until the related
+	// card is completed.
+	RelationTypeBlockedBy  RelationType = "blocked_by"
+	RelationTypeDuplicates RelationType = "duplicates"
+	RelationTypeRelatesTo  RelationType = "relates_to"
+)
+
+func (t RelationType) IsValid() bool {
+	switch t {
+	case RelationTypeBlockedBy, RelationTypeDuplicates, RelationTypeRelatesTo:
+		return true
+	}
+	return false
+}
+
+// Relation is a directed link from CardID to RelatedCardID. A card's
+// Relations hold both the links it owns and the ones pointing at it.
+type Relation struct {
+	ID            string       `json:"entity_id" db:"entity_id"`
+	CardID        string       `json:"card_id" db:"card_id"`
+	RelatedCardID string       `json:"related_card_id" db:"related_card_id"`
+	Type          RelationType `json:"type" db:"type"`
+	CreatedAt     time.Time    `json:"created_at" db:"created_at"`
+}
+
+func (r Relation) Connects(cardID, relatedCardID string) bool {
+	if r.CardID == cardID && r.RelatedCardID == relatedCardID {
+		return true
+	}
+	// relates_to has no direction
+	return r.Type == RelationTypeRelatesTo && r.CardID == relatedCardID && r.RelatedCardID == cardID
+}
+
+func NewRelation(cardID, relatedCardID string, relationType RelationType) (Relation, error) {
+	if !relationType.IsValid() {
+		return Relation{}, apierror.WithDesc(ErrorCodeInvalidInput, "unknown relation type")
+	}
+	if cardID == relatedCardID {
+		return Relation{}, apierror.WithDesc(ErrorCodeInvalidInput, "a card can't be related to itself")
+	}
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return Relation{}, errors.WithStack(err)
+	}
+	return Relation{
+		ID:            id.String(),
+		CardID:        cardID,
+		RelatedCardID: relatedCardID,
+		Type:          relationType,
+		CreatedAt:     time.Now(),
+	}, nil
+}
+
+func (c Card) FindRelation(relatedCardID string, relationType RelationType) (Relation, bool) {
+	for _, r := range c.Relations {
+		if r.Type == relationType && r.Connects(c.ID, relatedCardID) {
+			return r, true
+		}
+	}
+	return Relation{}, false
+}
+
+// Blockers returns the IDs of the cards this card is blocked by.
+func (c Card) Blockers() []string {
+	var res []string
+	for _, r := range c.Relations {
+		if r.Type == RelationTypeBlockedBy && r.CardID == c.ID {
+			res = append(res, r.RelatedCardID)
+		}
+	}
+	return res
+}
+
+type RelationInput struct {
+	CardID        string       `json:"card_id" validate:"required"`
+	RelatedCardID string       `json:"related_card_id" validate:"required"`
+	Type          RelationType `json:"type" validate:"required"`
+}
@@ -4,10 +4,20 @@ import "context"
 
 type Repository interface {
 	Store(ctx context.Context, entity *Card) error
+	StoreAll(ctx context.Context, entities []Card) error
 	StoreLabels(ctx context.Context, cardID string, labels []Label) error
+	StoreRelation(ctx context.Context, relation Relation) error
+	DeleteRelation(ctx context.Context, relation Relation) error
 	ResolveByID(ctx context.Context, id string) (*Card, error)
+	ResolveIDByPublicID(ctx context.Context, publicID string) (string, error)
+	ResolveIDByKey(ctx context.Context, key string) (string, error)
+	ResolveIDsByCustomFieldID(ctx context.Context, fieldID string) ([]string, error)
+	ResolveRelationsByCardIDs(ctx context.Context, cardIDs []string) ([]Relation, error)
 	ResolveAllByFilter(ctx context.Context, filter Filter) ([]Card, error)
+	ResolveAllByIDsForUpdate(ctx context.Context, ids []string) ([]Card, error)
 	ResolveAllIDsByFilter(ctx context.Context, filter Filter) ([]string, error)
 	ResolveIDsByFilter(ctx context.Context, filter Filter, limit int) ([]string, error)
 	CountByFilter(ctx context.Context, filter Filter) (int, error)
+	CountListCardsForUpdate(ctx context.Context, listID string) (int, error)
+	LockByIDs(ctx context.Context, ids []string) error
 }
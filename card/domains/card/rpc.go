@@ -6,6 +6,8 @@ import (
 	"time"
 
 	pb "github.com/rakateja/milo/twirp-rpc-examples/card/proto/rpcproto"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/servers"
+	"github.com/twitchtv/twirp"
 )
 
 type CardServer struct {
@@ -24,7 +26,7 @@ func (svc *CardServer) Create(ctx context.Context, input *pb.CardInput) (*pb.Car
 	res, err := svc.cardSvc.Create(ctx, ToCardInput(input))
 	if err != nil {
 		log.Printf("[ERROR] %+v", err)
-		return nil, err
+		return nil, servers.ToTwirpError(err)
 	}
 	return ToCardPb(*res), nil
 }
@@ -34,10 +36,13 @@ func (svc *CardServer) Update(ctx context.Context, updateInput *pb.CardUpdateInp
 	defer func(now time.Time) {
 		log.Printf("[INFO] Update() - it tooks %s", time.Since(now))
 	}(now)
-	res, err := svc.cardSvc.Update(ctx, updateInput.Id, ToCardInput(updateInput.Input))
+	if updateInput.Input == nil {
+		return nil, twirp.RequiredArgumentError("input")
+	}
+	res, err := svc.cardSvc.Update(ctx, updateInput.Id, ToCardInput(updateInput.Input), updateInput.UpdateMask.GetPaths())
 	if err != nil {
 		log.Printf("[ERROR] %+v", err)
-		return nil, err
+		return nil, servers.ToTwirpError(err)
 	}
 	return ToCardPb(*res), nil
 }
@@ -50,7 +55,7 @@ func (svc *CardServer) MoveList(ctx context.Context, input *pb.CardMoveListInput
 	res, err := svc.cardSvc.MoveList(ctx, input.CardID, input.ListID)
 	if err != nil {
 		log.Printf("[ERROR] %+v", err)
-		return nil, err
+		return nil, servers.ToTwirpError(err)
 	}
 	return ToCardPb(*res), nil
 }
@@ -60,10 +65,27 @@ func (svc *CardServer) GetByID(ctx context.Context, input *pb.GetByIDInput) (*pb
 	defer func(now time.Time) {
 		log.Printf("[INFO] GetByID() - it tooks %s", time.Since(now))
 	}(now)
-	res, err := svc.cardSvc.ResolveByID(ctx, input.Id)
+	projection, err := NewProjection(input.ReadMask.GetPaths())
+	if err != nil {
+		return nil, servers.ToTwirpError(err)
+	}
+	res, err := svc.cardSvc.Resolve(ctx, input.Id, projection)
 	if err != nil {
 		log.Printf("[ERROR] %+v", err)
-		return nil, err
+		return nil, servers.ToTwirpError(err)
+	}
+	return ToCardPb(*res), nil
+}
+
+func (svc *CardServer) GetByKey(ctx context.Context, input *pb.GetByKeyInput) (*pb.Card, error) {
+	now := time.Now()
+	defer func(now time.Time) {
+		log.Printf("[INFO] GetByKey() - it tooks %s", time.Since(now))
+	}(now)
+	res, err := svc.cardSvc.ResolveByKey(ctx, input.Key)
+	if err != nil {
+		log.Printf("[ERROR] %+v", err)
+		return nil, servers.ToTwirpError(err)
 	}
 	return ToCardPb(*res), nil
 }
@@ -73,14 +95,31 @@ func (svc *CardServer) Search(ctx context.Context, input *pb.GetPageInput) (*pb.
 	defer func(now time.Time) {
 		log.Printf("[INFO] Search() - it tooks %s", time.Since(now))
 	}(now)
-	var boardIDs []string
+	projection, err := NewProjection(input.ReadMask.GetPaths())
+	if err != nil {
+		return nil, servers.ToTwirpError(err)
+	}
+	filter := Filter{Projection: projection}
 	if input.Filter != nil {
-		boardIDs = input.Filter.BoardIds
+		customFields, err := svc.cardSvc.ParseCustomFieldFilters(ctx, ToCustomFieldValueInputs(input.Filter.CustomFields))
+		if err != nil {
+			log.Printf("[ERROR] %+v", err)
+			return nil, servers.ToTwirpError(err)
+		}
+		filter.BoardIDs = input.Filter.BoardIds
+		filter.CustomFields = customFields
+		filter.Blocked = input.Filter.Blocked
+	}
+	if input.Sort != nil {
+		filter.Sort = Sort{
+			CustomFieldID: input.Sort.CustomFieldId,
+			Descending:    input.Sort.Descending,
+		}
 	}
-	res, err := svc.cardSvc.Search(ctx, input.Page, input.Limit, Filter{BoardIDs: boardIDs})
+	res, err := svc.cardSvc.Search(ctx, input.Page, input.Limit, filter)
 	if err != nil {
 		log.Printf("[ERROR] %+v", err)
-		return nil, err
+		return nil, servers.ToTwirpError(err)
 	}
 	return ToCardPagePb(res), nil
 }
@@ -90,10 +129,291 @@ func (svc *CardServer) GetAll(ctx context.Context, filter *pb.CardFilter) (*pb.C
 	defer func(now time.Time) {
 		log.Printf("[INFO] GetAll() - it tooks %s", time.Since(now))
 	}(now)
-	res, err := svc.cardSvc.ResolveAllByFilter(ctx, Filter{IDs: filter.Ids})
+	projection, err := NewProjection(filter.ReadMask.GetPaths())
+	if err != nil {
+		return nil, servers.ToTwirpError(err)
+	}
+	res, err := svc.cardSvc.ResolveByIDs(ctx, filter.Ids, projection)
 	if err != nil {
 		log.Printf("[ERROR] %+v", err)
-		return nil, err
+		return nil, servers.ToTwirpError(err)
 	}
 	return &pb.CardList{Cards: ToCardListPb(res)}, nil
 }
+
+func (svc *CardServer) BatchGetCards(ctx context.Context, input *pb.BatchGetInput) (*pb.CardBatchResult, error) {
+	now := time.Now()
+	defer func(now time.Time) {
+		log.Printf("[INFO] BatchGetCards() - it tooks %s", time.Since(now))
+	}(now)
+	res, err := svc.cardSvc.BatchResolve(ctx, input.Ids)
+	if err != nil {
+		log.Printf("[ERROR] %+v", err)
+		return nil, servers.ToTwirpError(err)
+	}
+	return ToCardBatchResultPb(res), nil
+}
+
+func (svc *CardServer) BatchUpdateCards(ctx context.Context, input *pb.BatchUpdateCardsInput) (*pb.CardBatchResult, error) {
+	now := time.Now()
+	defer func(now time.Time) {
+		log.Printf("[INFO] BatchUpdateCards() - it tooks %s", time.Since(now))
+	}(now)
+	res, err := svc.cardSvc.BatchUpdate(ctx, ToPatches(input.Patches))
+	if err != nil {
+		log.Printf("[ERROR] %+v", err)
+		return nil, servers.ToTwirpError(err)
+	}
+	return ToCardBatchResultPb(res), nil
+}
+
+func (svc *CardServer) WatchCard(ctx context.Context, input *pb.CardWatchInput) (*pb.Card, error) {
+	now := time.Now()
+	defer func(now time.Time) {
+		log.Printf("[INFO] WatchCard() - it tooks %s", time.Since(now))
+	}(now)
+	res, err := svc.cardSvc.Watch(ctx, input.CardId, input.UserId)
+	if err != nil {
+		log.Printf("[ERROR] %+v", err)
+		return nil, servers.ToTwirpError(err)
+	}
+	return ToCardPb(*res), nil
+}
+
+func (svc *CardServer) UpdateMembers(ctx context.Context, input *pb.CardMembersInput) (*pb.Card, error) {
+	now := time.Now()
+	defer func(now time.Time) {
+		log.Printf("[INFO] UpdateMembers() - it tooks %s", time.Since(now))
+	}(now)
+	res, err := svc.cardSvc.UpdateMembers(ctx, input.CardId, ToCardMemberInputFromPb(input.Members))
+	if err != nil {
+		log.Printf("[ERROR] %+v", err)
+		return nil, servers.ToTwirpError(err)
+	}
+	return ToCardPb(*res), nil
+}
+
+func (svc *CardServer) AddAttachment(ctx context.Context, input *pb.CardAttachmentInput) (*pb.Card, error) {
+	now := time.Now()
+	defer func(now time.Time) {
+		log.Printf("[INFO] AddAttachment() - it tooks %s", time.Since(now))
+	}(now)
+	res, err := svc.cardSvc.AddAttachment(ctx, input.CardId, ToAttachmentInput(input))
+	if err != nil {
+		log.Printf("[ERROR] %+v", err)
+		return nil, servers.ToTwirpError(err)
+	}
+	return ToCardPb(*res), nil
+}
+
+func (svc *CardServer) DeleteAttachment(ctx context.Context, input *pb.CardAttachmentDeleteInput) (*pb.Card, error) {
+	now := time.Now()
+	defer func(now time.Time) {
+		log.Printf("[INFO] DeleteAttachment() - it tooks %s", time.Since(now))
+	}(now)
+	res, err := svc.cardSvc.DeleteAttachment(ctx, input.CardId, input.AttachmentId)
+	if err != nil {
+		log.Printf("[ERROR] %+v", err)
+		return nil, servers.ToTwirpError(err)
+	}
+	return ToCardPb(*res), nil
+}
+
+func (svc *CardServer) UnwatchCard(ctx context.Context, input *pb.CardWatchInput) (*pb.Card, error) {
+	now := time.Now()
+	defer func(now time.Time) {
+		log.Printf("[INFO] UnwatchCard() - it tooks %s", time.Since(now))
+	}(now)
+	res, err := svc.cardSvc.Unwatch(ctx, input.CardId, input.UserId)
+	if err != nil {
+		log.Printf("[ERROR] %+v", err)
+		return nil, servers.ToTwirpError(err)
+	}
+	return ToCardPb(*res), nil
+}
+
+func (svc *CardServer) UpdateCustomFields(ctx context.Context, input *pb.CardCustomFieldsInput) (*pb.Card, error) {
+	now := time.Now()
+	defer func(now time.Time) {
+		log.Printf("[INFO] UpdateCustomFields() - it tooks %s", time.Since(now))
+	}(now)
+	res, err := svc.cardSvc.UpdateCustomFields(ctx, input.CardId, ToCustomFieldValueInputs(input.Values))
+	if err != nil {
+		log.Printf("[ERROR] %+v", err)
+		return nil, servers.ToTwirpError(err)
+	}
+	return ToCardPb(*res), nil
+}
+
+func (svc *CardServer) LinkCards(ctx context.Context, input *pb.CardRelationInput) (*pb.Card, error) {
+	now := time.Now()
+	defer func(now time.Time) {
+		log.Printf("[INFO] LinkCards() - it tooks %s", time.Since(now))
+	}(now)
+	res, err := svc.cardSvc.Link(ctx, ToRelationInput(input))
+	if err != nil {
+		log.Printf("[ERROR] %+v", err)
+		return nil, servers.ToTwirpError(err)
+	}
+	return ToCardPb(*res), nil
+}
+
+func (svc *CardServer) UnlinkCards(ctx context.Context, input *pb.CardRelationInput) (*pb.Card, error) {
+	now := time.Now()
+	defer func(now time.Time) {
+		log.Printf("[INFO] UnlinkCards() - it tooks %s", time.Since(now))
+	}(now)
+	res, err := svc.cardSvc.Unlink(ctx, ToRelationInput(input))
+	if err != nil {
+		log.Printf("[ERROR] %+v", err)
+		return nil, servers.ToTwirpError(err)
+	}
+	return ToCardPb(*res), nil
+}
+
+func (svc *CardServer) ListRelations(ctx context.Context, input *pb.GetByIDInput) (*pb.CardRelationList, error) {
+	now := time.Now()
+	defer func(now time.Time) {
+		log.Printf("[INFO] ListRelations() - it tooks %s", time.Since(now))
+	}(now)
+	res, err := svc.cardSvc.ResolveRelations(ctx, input.Id)
+	if err != nil {
+		log.Printf("[ERROR] %+v", err)
+		return nil, servers.ToTwirpError(err)
+	}
+	return &pb.CardRelationList{Relations: ToCardRelations(res)}, nil
+}
+
+func (svc *CardServer) ListRecipients(ctx context.Context, input *pb.GetByIDInput) (*pb.CardRecipientList, error) {
+	now := time.Now()
+	defer func(now time.Time) {
+		log.Printf("[INFO] ListRecipients() - it tooks %s", time.Since(now))
+	}(now)
+	res, err := svc.cardSvc.ResolveRecipients(ctx, input.Id)
+	if err != nil {
+		log.Printf("[ERROR] %+v", err)
+		return nil, servers.ToTwirpError(err)
+	}
+	return &pb.CardRecipientList{UserIds: res}, nil
+}
+
+func (svc *CardServer) CreateTemplate(ctx context.Context, input *pb.CardTemplateInput) (*pb.CardTemplate, error) {
+	now := time.Now()
+	defer func(now time.Time) {
+		log.Printf("[INFO] CreateTemplate() - it tooks %s", time.Since(now))
+	}(now)
+	res, err := svc.cardSvc.CreateTemplate(ctx, ToTemplateInput(input))
+	if err != nil {
+		log.Printf("[ERROR] %+v", err)
+		return nil, servers.ToTwirpError(err)
+	}
+	return ToCardTemplatePb(*res), nil
+}
+
+func (svc *CardServer) UpdateTemplate(ctx context.Context, input *pb.CardTemplateUpdateInput) (*pb.CardTemplate, error) {
+	now := time.Now()
+	defer func(now time.Time) {
+		log.Printf("[INFO] UpdateTemplate() - it tooks %s", time.Since(now))
+	}(now)
+	res, err := svc.cardSvc.UpdateTemplate(ctx, input.Id, ToTemplateInput(input.Input))
+	if err != nil {
+		log.Printf("[ERROR] %+v", err)
+		return nil, servers.ToTwirpError(err)
+	}
+	return ToCardTemplatePb(*res), nil
+}
+
+func (svc *CardServer) DeleteTemplate(ctx context.Context, input *pb.GetByIDInput) (*pb.CardTemplateList, error) {
+	now := time.Now()
+	defer func(now time.Time) {
+		log.Printf("[INFO] DeleteTemplate() - it tooks %s", time.Since(now))
+	}(now)
+	res, err := svc.cardSvc.DeleteTemplate(ctx, input.Id)
+	if err != nil {
+		log.Printf("[ERROR] %+v", err)
+		return nil, servers.ToTwirpError(err)
+	}
+	return ToCardTemplateListPb(res), nil
+}
+
+func (svc *CardServer) GetTemplates(ctx context.Context, input *pb.CardTemplateFilter) (*pb.CardTemplateList, error) {
+	now := time.Now()
+	defer func(now time.Time) {
+		log.Printf("[INFO] GetTemplates() - it tooks %s", time.Since(now))
+	}(now)
+	res, err := svc.cardSvc.ResolveTemplatesByBoardID(ctx, input.BoardId)
+	if err != nil {
+		log.Printf("[ERROR] %+v", err)
+		return nil, servers.ToTwirpError(err)
+	}
+	return ToCardTemplateListPb(res), nil
+}
+
+func (svc *CardServer) CreateFromTemplate(ctx context.Context, input *pb.CreateFromTemplateInput) (*pb.Card, error) {
+	now := time.Now()
+	defer func(now time.Time) {
+		log.Printf("[INFO] CreateFromTemplate() - it tooks %s", time.Since(now))
+	}(now)
+	res, err := svc.cardSvc.CreateFromTemplate(ctx, ToTemplateCardInput(input))
+	if err != nil {
+		log.Printf("[ERROR] %+v", err)
+		return nil, servers.ToTwirpError(err)
+	}
+	return ToCardPb(*res), nil
+}
+
+func (svc *CardServer) CopyCard(ctx context.Context, input *pb.CopyCardInput) (*pb.Card, error) {
+	now := time.Now()
+	defer func(now time.Time) {
+		log.Printf("[INFO] CopyCard() - it tooks %s", time.Since(now))
+	}(now)
+	res, err := svc.cardSvc.CopyCard(ctx, ToCopyInput(input))
+	if err != nil {
+		log.Printf("[ERROR] %+v", err)
+		return nil, servers.ToTwirpError(err)
+	}
+	return ToCardPb(*res), nil
+}
+
+func (svc *CardServer) BulkMoveCards(ctx context.Context, input *pb.BulkMoveCardsInput) (*pb.CardList, error) {
+	now := time.Now()
+	defer func(now time.Time) {
+		log.Printf("[INFO] BulkMoveCards() - it tooks %s", time.Since(now))
+	}(now)
+	res, err := svc.cardSvc.BulkMove(ctx, BulkMoveInput{CardIDs: input.CardIds, ListID: input.ListId})
+	if err != nil {
+		log.Printf("[ERROR] %+v", err)
+		return nil, servers.ToTwirpError(err)
+	}
+	return &pb.CardList{Cards: ToCardListPb(res)}, nil
+}
+
+func (svc *CardServer) MoveAllCardsInList(ctx context.Context, input *pb.MoveAllCardsInListInput) (*pb.CardList, error) {
+	now := time.Now()
+	defer func(now time.Time) {
+		log.Printf("[INFO] MoveAllCardsInList() - it tooks %s", time.Since(now))
+	}(now)
+	res, err := svc.cardSvc.MoveAllInList(ctx, input.SourceListId, input.ListId)
+	if err != nil {
+		log.Printf("[ERROR] %+v", err)
+		return nil, servers.ToTwirpError(err)
+	}
+	return &pb.CardList{Cards: ToCardListPb(res)}, nil
+}
+
+func (svc *CardServer) GetBoardView(ctx context.Context, input *pb.BoardViewInput) (*pb.BoardView, error) {
+	now := time.Now()
+	defer func(now time.Time) {
+		log.Printf("[INFO] GetBoardView() - it tooks %s", time.Since(now))
+	}(now)
+	res, err := svc.cardSvc.ResolveView(ctx, ViewInput{
+		BoardID:       input.BoardId,
+		GroupBy:       GroupBy(input.GroupBy),
+		CustomFieldID: input.CustomFieldId,
+	})
+	if err != nil {
+		log.Printf("[ERROR] %+v", err)
+		return nil, servers.ToTwirpError(err)
+	}
+	return ToBoardViewPb(*res), nil
+}
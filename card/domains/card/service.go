@@ -3,142 +3,776 @@ package card
 import (
 	"context"
 	"math/rand"
+	"strings"
+	"time"
 
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"github.com/rakateja/milo/twirp-rpc-examples/card/apierror"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/database"
 	"github.com/rakateja/milo/twirp-rpc-examples/card/domains/board"
 )
 
 type Service struct {
 	repo         Repository
+	templateRepo TemplateRepository
 	boardService *board.Service
+	tx           database.Transactor
 }
 
-func NewService(repo Repository, boardService *board.Service) *Service {
+func NewService(repo Repository, templateRepo TemplateRepository, boardService *board.Service, tx database.Transactor) *Service {
 	return &Service{
 		repo:         repo,
+		templateRepo: templateRepo,
 		boardService: boardService,
+		tx:           tx,
 	}
 }
 
+// Create checks the list and generates the public ID in the transaction that
+// stores the card, which builds the card again when it's retried.
 func (svc *Service) Create(ctx context.Context, input CardInput) (*Card, error) {
-	entity, err := input.ToEntity()
+	var cardID string
+	err := svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		entity, err := input.ToEntity()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		code, err := svc.generateCode(ctx, 0)
+		if err != nil {
+			return errors.Wrap(err, "geneate card public id")
+		}
+		entity.PublicID = code
+		list, err := svc.boardService.ResolveListByID(ctx, input.ListID)
+		if err != nil {
+			return errors.Wrap(err, "resolve board list by id")
+		}
+		if list.BoardID != input.BoardID {
+			return apierror.WithDesc(ErrorCodeInvalidInput, "the board list doesn't associate with the board")
+		}
+		if list.IsArchived() {
+			return apierror.WithDesc(ErrorCodeInvalidInput, "the board list is archived")
+		}
+		err = checkWipLimit(ctx, svc.repo, list, 1)
+		if err != nil {
+			return err
+		}
+		if len(input.CustomFields) > 0 || len(input.LabelIDs) > 0 {
+			boardEntity, err := svc.boardService.ResolveByID(ctx, input.BoardID)
+			if err != nil {
+				return errors.Wrap(err, "resolve board by id")
+			}
+			for _, labelID := range input.LabelIDs {
+				if !boardEntity.LabelExist(labelID) {
+					return apierror.WithDesc(ErrorCodeInvalidInput, "label doesn't belong to the card's board")
+				}
+			}
+			err = entity.SetCustomFieldValues(boardEntity.CustomFields, input.CustomFields)
+			if err != nil {
+				return err
+			}
+		}
+		cardID = entity.ID
+		return errors.WithStack(svc.repo.Store(ctx, entity))
+	})
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, err
 	}
-	code, err := svc.generateCode(ctx, 0)
+	return svc.ResolveByID(ctx, cardID)
+}
+
+// Update applies the fields of the input named by the update mask paths, or
+// all of them when there are none.
+func (svc *Service) Update(ctx context.Context, cardID string, input CardInput, paths []string) (*Card, error) {
+	mask, err := NewUpdateMask(paths)
+	if err != nil {
+		return nil, err
+	}
+	err = svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		entity, err := svc.repo.ResolveByID(ctx, cardID)
+		if err != nil {
+			return errors.Wrap(err, "resolve card by id")
+		}
+		if entity.DeletedAt != nil {
+			return apierror.WithDesc(ErrorCodeEntityNotFound, "card couldn't be found")
+		}
+		if mask["list_id"] && input.ListID != "" && input.ListID != entity.ListID {
+			err = svc.checkTargetList(ctx, entity, input.ListID)
+			if err != nil {
+				return err
+			}
+			err = entity.MoveList(input.ListID)
+			if err != nil {
+				return err
+			}
+		}
+		err = entity.ApplyPatch(input.ToPatch(cardID, mask))
+		if err != nil {
+			return err
+		}
+		if mask["members"] {
+			err = entity.UpdateMembers(input.Members)
+			if err != nil {
+				return errors.Wrap(err, "update members")
+			}
+		}
+		if mask["label_ids"] || mask["custom_fields"] {
+			boardEntity, err := svc.boardService.ResolveByID(ctx, entity.BoardID)
+			if err != nil {
+				return errors.Wrap(err, "resolve board by id")
+			}
+			if mask["label_ids"] {
+				for _, labelID := range input.LabelIDs {
+					if !boardEntity.LabelExist(labelID) {
+						return apierror.WithDesc(ErrorCodeInvalidInput, "label doesn't belong to the card's board")
+					}
+				}
+				err = entity.SetLabels(input.LabelIDs)
+				if err != nil {
+					return errors.Wrap(err, "set labels")
+				}
+			}
+			if mask["custom_fields"] {
+				err = entity.SetCustomFieldValues(boardEntity.CustomFields, input.CustomFields)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return errors.Wrap(svc.repo.Store(ctx, entity), "store card")
+	})
 	if err != nil {
-		return nil, errors.Wrap(err, "geneate card public id")
+		return nil, err
 	}
-	entity.PublicID = code
-	err = svc.repo.Store(ctx, entity)
+	return svc.ResolveByID(ctx, cardID)
+}
+
+func (svc *Service) MoveList(ctx context.Context, cardID, listID string) (*Card, error) {
+	err := svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		entity, err := svc.repo.ResolveByID(ctx, cardID)
+		if err != nil {
+			return err
+		}
+		err = svc.checkTargetList(ctx, entity, listID)
+		if err != nil {
+			return err
+		}
+		if err := entity.MoveList(listID); err != nil {
+			return err
+		}
+		return svc.repo.Store(ctx, entity)
+	})
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, err
 	}
-	return svc.repo.ResolveByID(ctx, entity.ID)
+	return svc.ResolveByID(ctx, cardID)
 }
 
-func (svc *Service) Update(ctx context.Context, cardID string, input CardInput) (*Card, error) {
-	boardEntity, err := svc.boardService.ResolveByID(ctx, cardID)
+// checkTargetList makes sure the card can move to the list: it's a list of
+// the card's board, it isn't archived and it has room under its WIP limit.
+func (svc *Service) checkTargetList(ctx context.Context, entity *Card, listID string) error {
+	list, err := svc.boardService.ResolveListByID(ctx, listID)
 	if err != nil {
-		return nil, errors.Wrap(err, "resolve board by id")
+		return err
 	}
-	if !boardEntity.ListExist(input.ListID) {
-		return nil, apierror.WithDesc(ErrorCodeInvalidInput, "invalid list ID")
+	if entity.BoardID != list.BoardID {
+		return apierror.WithDesc(
+			ErrorCodeInvalidInput,
+			"the board list doesn't associate with the board")
 	}
-	entity, err := svc.repo.ResolveByID(ctx, cardID)
-	if err != nil {
-		return nil, errors.Wrap(err, "resolve card by id")
+	if list.IsArchived() {
+		return apierror.WithDesc(ErrorCodeInvalidInput, "the board list is archived")
 	}
+	return checkWipLimit(ctx, svc.repo, list, incomingCards([]Card{*entity}, list.ID))
+}
 
-	if entity.ListID != input.ListID {
+// CopyCard duplicates a card into any list, on the same board or another
+// one. Labels are matched by slug and members without access to the target
+// board are left out.
+func (svc *Service) CopyCard(ctx context.Context, input CopyInput) (*Card, error) {
+	err := validator.New().Struct(input)
+	if err != nil {
+		return nil, apierror.WithDesc(ErrorCodeInvalidInput, err.Error())
 	}
-	err = entity.Update(input)
+	var cardID string
+	err = svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		source, err := svc.repo.ResolveByID(ctx, input.CardID)
+		if err != nil {
+			return errors.Wrap(err, "resolve card by id")
+		}
+		list, targetBoard, err := svc.resolveTargetList(ctx, input.ListID)
+		if err != nil {
+			return err
+		}
+		err = checkWipLimit(ctx, svc.repo, list, 1)
+		if err != nil {
+			return err
+		}
+		labelMapping, err := svc.labelMapping(ctx, source.BoardID, targetBoard)
+		if err != nil {
+			return err
+		}
+		entity, err := source.Copy(list, labelMapping, input.CopyOptions)
+		if err != nil {
+			return errors.Wrap(err, "copy card")
+		}
+		if input.Title != "" {
+			entity.Title = input.Title
+		}
+		entity.Members = membersWithAccess(entity.Members, targetBoard)
+		entity.PublicID, err = svc.generateCode(ctx, 0)
+		if err != nil {
+			return errors.Wrap(err, "geneate card public id")
+		}
+		cardID = entity.ID
+		return errors.Wrap(svc.repo.Store(ctx, entity), "store card")
+	})
 	if err != nil {
-		return nil, errors.Wrap(err, "update card")
+		return nil, err
 	}
-	return entity, nil
+	return svc.ResolveByID(ctx, cardID)
 }
 
-func (svc *Service) MoveList(ctx context.Context, cardID, listID string) (*Card, error) {
-	entity, err := svc.repo.ResolveByID(ctx, cardID)
+// BulkMove moves the cards to the list, which may belong to another board,
+// in one transaction that reads the cards for update and checks the list.
+func (svc *Service) BulkMove(ctx context.Context, input BulkMoveInput) ([]Card, error) {
+	err := validator.New().Struct(input)
+	if err != nil {
+		return nil, apierror.WithDesc(ErrorCodeInvalidInput, err.Error())
+	}
+	// a card listed twice is moved once
+	cardIDs := make([]string, 0, len(input.CardIDs))
+	seen := make(map[string]bool, 0)
+	for _, id := range input.CardIDs {
+		if !seen[id] {
+			seen[id] = true
+			cardIDs = append(cardIDs, id)
+		}
+	}
+	err = svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		cards, err := svc.repo.ResolveAllByIDsForUpdate(ctx, cardIDs)
+		if err != nil {
+			return errors.Wrap(err, "resolve cards by ids")
+		}
+		if len(cards) != len(cardIDs) {
+			return apierror.WithDesc(ErrorCodeEntityNotFound, "card couldn't be found")
+		}
+		list, targetBoard, err := svc.resolveTargetList(ctx, input.ListID)
+		if err != nil {
+			return err
+		}
+		err = checkWipLimit(ctx, svc.repo, list, incomingCards(cards, list.ID))
+		if err != nil {
+			return err
+		}
+		labelMappings := make(map[string]map[string]string, 0)
+		for i := range cards {
+			labelMapping, exist := labelMappings[cards[i].BoardID]
+			if !exist {
+				labelMapping, err = svc.labelMapping(ctx, cards[i].BoardID, targetBoard)
+				if err != nil {
+					return err
+				}
+				labelMappings[cards[i].BoardID] = labelMapping
+			}
+			cards[i].MoveTo(list, labelMapping)
+			cards[i].Members = membersWithAccess(cards[i].Members, targetBoard)
+		}
+		return errors.Wrap(svc.repo.StoreAll(ctx, cards), "store cards")
+	})
 	if err != nil {
 		return nil, err
 	}
+	return svc.ResolveAllByFilter(ctx, Filter{IDs: cardIDs})
+}
+
+func (svc *Service) MoveAllInList(ctx context.Context, sourceListID, listID string) ([]Card, error) {
+	cardIDs, err := svc.repo.ResolveAllIDsByFilter(ctx, Filter{ListIDs: []string{sourceListID}})
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve card ids by list id")
+	}
+	if len(cardIDs) == 0 {
+		return nil, nil
+	}
+	return svc.BulkMove(ctx, BulkMoveInput{CardIDs: cardIDs, ListID: listID})
+}
+
+func (svc *Service) resolveTargetList(ctx context.Context, listID string) (board.BoardList, *board.Board, error) {
 	list, err := svc.boardService.ResolveListByID(ctx, listID)
+	if err != nil {
+		return list, nil, errors.Wrap(err, "resolve board list by id")
+	}
+	if list.IsArchived() {
+		return list, nil, apierror.WithDesc(ErrorCodeInvalidInput, "the board list is archived")
+	}
+	targetBoard, err := svc.boardService.ResolveByID(ctx, list.BoardID)
+	if err != nil {
+		return list, nil, errors.Wrap(err, "resolve board by id")
+	}
+	return list, targetBoard, nil
+}
+
+// labelMapping maps the labels of the source board to the target board's
+// labels with the same slug.
+func (svc *Service) labelMapping(ctx context.Context, sourceBoardID string, targetBoard *board.Board) (map[string]string, error) {
+	sourceBoard := targetBoard
+	if sourceBoardID != targetBoard.ID {
+		var err error
+		sourceBoard, err = svc.boardService.ResolveByID(ctx, sourceBoardID)
+		if err != nil {
+			return nil, errors.Wrap(err, "resolve board by id")
+		}
+	}
+	return sourceBoard.LabelMappingTo(*targetBoard), nil
+}
+
+func membersWithAccess(members []Member, boardEntity *board.Board) []Member {
+	res := make([]Member, 0)
+	for _, m := range members {
+		if boardEntity.HasAccess(m.UserID) {
+			res = append(res, m)
+		}
+	}
+	return res
+}
+
+func (svc *Service) UpdateMembers(ctx context.Context, cardID string, members []MemberInput) (*Card, error) {
+	err := svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		entity, err := svc.repo.ResolveByID(ctx, cardID)
+		if err != nil {
+			return errors.Wrap(err, "resolve card by id")
+		}
+		err = entity.UpdateMembers(members)
+		if err != nil {
+			return errors.Wrap(err, "add members")
+		}
+		return errors.Wrap(svc.repo.Store(ctx, entity), "store card")
+	})
 	if err != nil {
 		return nil, err
 	}
-	if entity.BoardID != list.BoardID {
-		return nil, apierror.WithDesc(
-			ErrorCodeInvalidInput,
-			"the board list doesn't associate with the board")
+	return svc.ResolveByID(ctx, cardID)
+}
+
+func (svc *Service) AddAttachment(ctx context.Context, cardID string, input AttachmentInput) (*Card, error) {
+	err := validator.New().Struct(input)
+	if err != nil {
+		return nil, apierror.WithDesc(ErrorCodeInvalidInput, err.Error())
 	}
-	if err := entity.MoveList(listID); err != nil {
+	err = svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		entity, err := svc.repo.ResolveByID(ctx, cardID)
+		if err != nil {
+			return errors.Wrap(err, "resolve card by id")
+		}
+		err = entity.AddAttachment(input.FileURL, input.FileType, input.LinkName)
+		if err != nil {
+			return errors.Wrap(err, "add attachment")
+		}
+		return errors.Wrap(svc.repo.Store(ctx, entity), "store card")
+	})
+	if err != nil {
 		return nil, err
 	}
-	if err = svc.repo.Store(ctx, entity); err != nil {
+	return svc.ResolveByID(ctx, cardID)
+}
+
+func (svc *Service) DeleteAttachment(ctx context.Context, cardID, attachmentID string) (*Card, error) {
+	err := svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		entity, err := svc.repo.ResolveByID(ctx, cardID)
+		if err != nil {
+			return errors.Wrap(err, "resolve card by id")
+		}
+		err = entity.DeleteAttachment(attachmentID)
+		if err != nil {
+			return err
+		}
+		return errors.Wrap(svc.repo.Store(ctx, entity), "store card")
+	})
+	if err != nil {
 		return nil, err
 	}
 	return svc.ResolveByID(ctx, cardID)
 }
 
-func (svc *Service) UpdateMembers(ctx context.Context, cardID string, members []MemberInput) (*Card, error) {
-	entity, err := svc.repo.ResolveByID(ctx, cardID)
+func (svc *Service) UpdateCustomFields(ctx context.Context, cardID string, inputs []CustomFieldValueInput) (*Card, error) {
+	err := svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		entity, err := svc.repo.ResolveByID(ctx, cardID)
+		if err != nil {
+			return errors.Wrap(err, "resolve card by id")
+		}
+		boardEntity, err := svc.boardService.ResolveByID(ctx, entity.BoardID)
+		if err != nil {
+			return errors.Wrap(err, "resolve board by id")
+		}
+		err = entity.SetCustomFieldValues(boardEntity.CustomFields, inputs)
+		if err != nil {
+			return err
+		}
+		return errors.Wrap(svc.repo.Store(ctx, entity), "store card")
+	})
 	if err != nil {
-		return nil, errors.Wrap(err, "resolve card by id")
+		return nil, err
+	}
+	return svc.ResolveByID(ctx, cardID)
+}
+
+// ParseCustomFieldFilters converts raw search inputs into typed filters using
+// each field's definition.
+func (svc *Service) ParseCustomFieldFilters(ctx context.Context, inputs []CustomFieldValueInput) ([]CustomFieldFilter, error) {
+	var res []CustomFieldFilter
+	for _, input := range inputs {
+		field, err := svc.boardService.ResolveCustomFieldByID(ctx, input.FieldID)
+		if err != nil {
+			return nil, errors.Wrap(err, "resolve custom field by id")
+		}
+		value, err := field.ParseValue(input.Value, input.OptionIDs)
+		if err != nil {
+			return nil, err
+		}
+		if value.IsEmpty() {
+			continue
+		}
+		res = append(res, CustomFieldFilter{FieldID: field.ID, Value: value})
+	}
+	return res, nil
+}
+
+func (svc *Service) AddLabel(ctx context.Context, cardID, labelID string) (*Card, error) {
+	err := svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		cardEntity, err := svc.repo.ResolveByID(ctx, cardID)
+		if err != nil {
+			return errors.Wrap(err, "resolve card by id")
+		}
+		exist, err := svc.boardService.ExistLabelByID(ctx, labelID)
+		if err != nil {
+			return errors.Wrap(err, "exist label by id")
+		}
+		if !exist {
+			return apierror.WithDesc(ErrorCodeEntityNotFound, "label not found")
+		}
+		err = cardEntity.AddLabel(labelID)
+		if err != nil {
+			if f, ok := err.(apierror.APIError); ok && f.Code == ErrorCodeAlreadyExist {
+				return nil
+			}
+			return errors.Wrap(err, "add label to card entity")
+		}
+		return errors.Wrap(svc.repo.StoreLabels(ctx, cardEntity.ID, cardEntity.Labels), "store labels")
+	})
+	if err != nil {
+		return nil, err
 	}
-	err = entity.UpdateMembers(members)
+	return svc.ResolveByID(ctx, cardID)
+}
+
+func (svc *Service) RemoveLabel(ctx context.Context, cardID string, labelID string) (*Card, error) {
+	err := svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		cardEntity, err := svc.repo.ResolveByID(ctx, cardID)
+		if err != nil {
+			return errors.Wrap(err, "resolve card by id")
+		}
+		cardEntity.RemoveLabel(labelID)
+		return errors.Wrap(svc.repo.StoreLabels(ctx, cardEntity.ID, cardEntity.Labels), "store labels")
+	})
 	if err != nil {
-		return nil, errors.Wrap(err, "add members")
+		return nil, err
 	}
-	err = svc.repo.Store(ctx, entity)
+	return svc.ResolveByID(ctx, cardID)
+}
+
+func (svc *Service) Watch(ctx context.Context, cardID, userID string) (*Card, error) {
+	if userID == "" {
+		return nil, apierror.WithDesc(ErrorCodeInvalidInput, "user_id is mandatory")
+	}
+	err := svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		cardEntity, err := svc.repo.ResolveByID(ctx, cardID)
+		if err != nil {
+			return errors.Wrap(err, "resolve card by id")
+		}
+		boardEntity, err := svc.boardService.ResolveByID(ctx, cardEntity.BoardID)
+		if err != nil {
+			return errors.Wrap(err, "resolve board by id")
+		}
+		if !boardEntity.HasAccess(userID) {
+			return apierror.WithDesc(ErrorCodeInvalidInput, "user isn't a member of the board")
+		}
+		err = cardEntity.Watch(userID)
+		if err != nil {
+			if f, ok := err.(apierror.APIError); ok && f.Code == ErrorCodeAlreadyExist {
+				return nil
+			}
+			return errors.Wrap(err, "watch card")
+		}
+		return errors.Wrap(svc.repo.Store(ctx, cardEntity), "store card")
+	})
 	if err != nil {
-		return nil, errors.Wrap(err, "store card")
+		return nil, err
 	}
-	return svc.repo.ResolveByID(ctx, cardID)
+	return svc.ResolveByID(ctx, cardID)
 }
 
-func (svc *Service) AddLabel(ctx context.Context, cardID, labelID string) (*Card, error) {
-	cardEntity, err := svc.repo.ResolveByID(ctx, cardID)
+func (svc *Service) Unwatch(ctx context.Context, cardID, userID string) (*Card, error) {
+	err := svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		cardEntity, err := svc.repo.ResolveByID(ctx, cardID)
+		if err != nil {
+			return errors.Wrap(err, "resolve card by id")
+		}
+		cardEntity.Unwatch(userID)
+		return errors.Wrap(svc.repo.Store(ctx, cardEntity), "store card")
+	})
 	if err != nil {
-		return nil, errors.Wrap(err, "resolve card by id")
+		return nil, err
 	}
-	exist, err := svc.boardService.ExistLabelByID(ctx, labelID)
+	return svc.ResolveByID(ctx, cardID)
+}
+
+// CreateFromTemplate fills the template placeholders with the given values
+// and creates the card through Create.
+func (svc *Service) CreateFromTemplate(ctx context.Context, input TemplateCardInput) (*Card, error) {
+	err := validator.New().Struct(input)
+	if err != nil {
+		return nil, apierror.WithDesc(ErrorCodeInvalidInput, err.Error())
+	}
+	template, err := svc.templateRepo.ResolveByID(ctx, input.TemplateID)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve card template by id")
+	}
+	list, err := svc.boardService.ResolveListByID(ctx, input.ListID)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve board list by id")
+	}
+	if list.BoardID != template.BoardID {
+		return nil, apierror.WithDesc(ErrorCodeInvalidInput, "the board list doesn't associate with the template's board")
+	}
+	cardInput, err := template.ToCardInput(input.ListID, input.Values, time.Now())
 	if err != nil {
-		return nil, errors.Wrap(err, "exist label by id")
+		return nil, err
+	}
+	return svc.Create(ctx, cardInput)
+}
+
+func (svc *Service) CreateTemplate(ctx context.Context, input TemplateInput) (*Template, error) {
+	entity, err := input.ToEntity()
+	if err != nil {
+		return nil, err
 	}
-	if !exist {
-		return nil, apierror.WithDesc(ErrorCodeEntityNotFound, "label not found")
+	err = svc.validateTemplate(ctx, *entity)
+	if err != nil {
+		return nil, err
 	}
-	err = cardEntity.AddLabel(labelID)
+	err = svc.templateRepo.Store(ctx, entity)
 	if err != nil {
-		if f, ok := err.(apierror.APIError); ok && f.Code == ErrorCodeAlreadyExist {
-			return cardEntity, nil
+		return nil, errors.Wrap(err, "store card template")
+	}
+	return svc.templateRepo.ResolveByID(ctx, entity.ID)
+}
+
+func (svc *Service) UpdateTemplate(ctx context.Context, id string, input TemplateInput) (*Template, error) {
+	entity, err := svc.templateRepo.ResolveByID(ctx, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve card template by id")
+	}
+	input.BoardID = entity.BoardID
+	err = entity.Update(input)
+	if err != nil {
+		return nil, err
+	}
+	err = svc.validateTemplate(ctx, *entity)
+	if err != nil {
+		return nil, err
+	}
+	err = svc.templateRepo.Store(ctx, entity)
+	if err != nil {
+		return nil, errors.Wrap(err, "store card template")
+	}
+	return svc.templateRepo.ResolveByID(ctx, id)
+}
+
+// DeleteTemplate returns the remaining templates of the template's board.
+func (svc *Service) DeleteTemplate(ctx context.Context, id string) ([]Template, error) {
+	entity, err := svc.templateRepo.ResolveByID(ctx, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve card template by id")
+	}
+	err = svc.templateRepo.Delete(ctx, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "delete card template")
+	}
+	return svc.templateRepo.ResolveAllByBoardID(ctx, entity.BoardID)
+}
+
+func (svc *Service) ResolveTemplatesByBoardID(ctx context.Context, boardID string) ([]Template, error) {
+	return svc.templateRepo.ResolveAllByBoardID(ctx, boardID)
+}
+
+// validateTemplate makes sure the default labels and members still belong to
+// the template's board.
+func (svc *Service) validateTemplate(ctx context.Context, template Template) error {
+	boardEntity, err := svc.boardService.ResolveByID(ctx, template.BoardID)
+	if err != nil {
+		return errors.Wrap(err, "resolve board by id")
+	}
+	for _, labelID := range template.LabelIDs {
+		if !boardEntity.LabelExist(labelID) {
+			return apierror.WithDesc(ErrorCodeInvalidInput, "label doesn't belong to the template's board")
+		}
+	}
+	for _, userID := range template.MemberIDs {
+		if !boardEntity.HasAccess(userID) {
+			return apierror.WithDesc(ErrorCodeInvalidInput, "user isn't a member of the board")
 		}
-		return nil, errors.Wrap(err, "add label to card entity")
 	}
-	err = svc.repo.StoreLabels(ctx, cardEntity.ID, cardEntity.Labels)
+	return nil
+}
+
+// Link relates two cards, which may live on different boards. Linking twice
+// is a no-op.
+func (svc *Service) Link(ctx context.Context, input RelationInput) (*Card, error) {
+	err := validator.New().Struct(input)
+	if err != nil {
+		return nil, apierror.WithDesc(ErrorCodeInvalidInput, err.Error())
+	}
+	relation, err := NewRelation(input.CardID, input.RelatedCardID, input.Type)
 	if err != nil {
-		return nil, errors.Wrap(err, "store labels")
+		return nil, err
+	}
+	// both cards are locked before the blocking chain is walked, and the
+	// walk reads the relations for update, so two links committing at the
+	// same time can't close a cycle
+	err = svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		err := svc.repo.LockByIDs(ctx, []string{input.CardID, input.RelatedCardID})
+		if err != nil {
+			return errors.Wrap(err, "lock cards")
+		}
+		cardEntity, err := svc.repo.ResolveByID(ctx, input.CardID)
+		if err != nil {
+			return errors.Wrap(err, "resolve card by id")
+		}
+		if _, exist := cardEntity.FindRelation(input.RelatedCardID, input.Type); exist {
+			return nil
+		}
+		_, err = svc.repo.ResolveByID(ctx, input.RelatedCardID)
+		if err != nil {
+			return errors.Wrap(err, "resolve related card by id")
+		}
+		if relation.Type == RelationTypeBlockedBy {
+			cycle, err := svc.isBlockedBy(ctx, relation.RelatedCardID, relation.CardID)
+			if err != nil {
+				return errors.Wrap(err, "detect blocking cycle")
+			}
+			if cycle {
+				return apierror.WithDesc(ErrorCodeInvalidInput, "the related card is already blocked by this card")
+			}
+		}
+		return errors.Wrap(svc.repo.StoreRelation(ctx, relation), "store relation")
+	})
+	if err != nil {
+		return nil, err
 	}
-	return svc.repo.ResolveByID(ctx, cardID)
+	return svc.ResolveByID(ctx, input.CardID)
 }
 
-func (svc *Service) RemoveLabel(ctx context.Context, cardID string, labelID string) (*Card, error) {
+func (svc *Service) Unlink(ctx context.Context, input RelationInput) (*Card, error) {
+	err := svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		err := svc.repo.LockByIDs(ctx, []string{input.CardID, input.RelatedCardID})
+		if err != nil {
+			return errors.Wrap(err, "lock cards")
+		}
+		cardEntity, err := svc.repo.ResolveByID(ctx, input.CardID)
+		if err != nil {
+			return errors.Wrap(err, "resolve card by id")
+		}
+		relation, exist := cardEntity.FindRelation(input.RelatedCardID, input.Type)
+		if !exist {
+			return nil
+		}
+		return errors.Wrap(svc.repo.DeleteRelation(ctx, relation), "delete relation")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return svc.ResolveByID(ctx, input.CardID)
+}
+
+func (svc *Service) ResolveRelations(ctx context.Context, cardID string) ([]Relation, error) {
 	cardEntity, err := svc.repo.ResolveByID(ctx, cardID)
 	if err != nil {
 		return nil, errors.Wrap(err, "resolve card by id")
 	}
-	cardEntity.RemoveLabel(labelID)
-	err = svc.repo.StoreLabels(ctx, cardEntity.ID, cardEntity.Labels)
+	return cardEntity.Relations, nil
+}
+
+// isBlockedBy walks the blocking chain of cardID breadth first and reports
+// whether blockerID is part of it.
+func (svc *Service) isBlockedBy(ctx context.Context, cardID, blockerID string) (bool, error) {
+	visited := map[string]bool{cardID: true}
+	frontier := []string{cardID}
+	for len(frontier) > 0 {
+		relations, err := svc.repo.ResolveRelationsByCardIDs(ctx, frontier)
+		if err != nil {
+			return false, err
+		}
+		inFrontier := make(map[string]bool, len(frontier))
+		for _, id := range frontier {
+			inFrontier[id] = true
+		}
+		var next []string
+		for _, r := range relations {
+			if r.Type != RelationTypeBlockedBy || !inFrontier[r.CardID] {
+				continue
+			}
+			if r.RelatedCardID == blockerID {
+				return true, nil
+			}
+			if visited[r.RelatedCardID] {
+				continue
+			}
+			visited[r.RelatedCardID] = true
+			next = append(next, r.RelatedCardID)
+		}
+		frontier = next
+	}
+	return false, nil
+}
+
+// ResolveRecipients returns the users who should be notified about changes
+// on the card: its members, its watchers and everyone watching its board or
+// its list.
+func (svc *Service) ResolveRecipients(ctx context.Context, cardID string) ([]string, error) {
+	cardEntity, err := svc.repo.ResolveByID(ctx, cardID)
 	if err != nil {
-		return nil, errors.Wrap(err, "store labels")
+		return nil, errors.Wrap(err, "resolve card by id")
+	}
+	boardEntity, err := svc.boardService.ResolveByID(ctx, cardEntity.BoardID)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve board by id")
 	}
-	return svc.repo.ResolveByID(ctx, cardID)
+	var userIDs []string
+	for _, m := range cardEntity.Members {
+		userIDs = append(userIDs, m.UserID)
+	}
+	for _, w := range cardEntity.Watchers {
+		userIDs = append(userIDs, w.UserID)
+	}
+	userIDs = append(userIDs, boardEntity.WatcherUserIDs(cardEntity.ListID)...)
+	seen := make(map[string]bool, 0)
+	res := make([]string, 0)
+	for _, userID := range userIDs {
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		res = append(res, userID)
+	}
+	return res, nil
 }
 
 func (svc *Service) generateCode(ctx context.Context, retried int) (string, error) {
+	return generateCode(ctx, svc.repo, retried)
+}
+
+func generateCode(ctx context.Context, repo Repository, retried int) (string, error) {
 	letters := []rune("1234567890ABCDEFGHIJKLMNOPQRSTUVWXYZ")
 	codeLength := 5
 	b := make([]rune, codeLength)
@@ -146,30 +780,303 @@ func (svc *Service) generateCode(ctx context.Context, retried int) (string, erro
 		b[i] = letters[rand.Intn(len(letters))]
 	}
 	code := string(b)
-	total, err := svc.repo.CountByFilter(ctx, Filter{PublicIDs: []string{code}})
+	total, err := repo.CountByFilter(ctx, Filter{PublicIDs: []string{code}})
 	if err != nil {
 		return "", errors.Wrap(err, "count rows by public_id")
 	}
-	if total > 0 && retried <= 3 {
-		return svc.generateCode(ctx, retried+1)
+	if total > 0 {
+		if retried >= 3 {
+			return "", errors.New("couldn't generate a unique card public id")
+		}
+		return generateCode(ctx, repo, retried+1)
 	}
 	return code, nil
 }
 
 func (svc *Service) ResolveByID(ctx context.Context, id string) (*Card, error) {
-	return svc.repo.ResolveByID(ctx, id)
+	res, err := svc.repo.ResolveByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	cards := []Card{*res}
+	err = svc.evaluateFormulas(ctx, cards)
+	if err != nil {
+		return nil, err
+	}
+	return &cards[0], nil
+}
+
+// ResolveView resolves the board's cards grouped into swimlanes.
+func (svc *Service) ResolveView(ctx context.Context, input ViewInput) (*View, error) {
+	err := validator.New().Struct(input)
+	if err != nil {
+		return nil, apierror.WithDesc(ErrorCodeInvalidInput, err.Error())
+	}
+	boardEntity, err := svc.boardService.ResolveByID(ctx, input.BoardID)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve board by id")
+	}
+	var field board.CustomField
+	if input.GroupBy == GroupByCustomField {
+		var exist bool
+		field, exist = boardEntity.CustomFieldByID(input.CustomFieldID)
+		if !exist {
+			return nil, apierror.WithDesc(ErrorCodeEntityNotFound, "custom field couldn't be found")
+		}
+	}
+	cards, err := svc.ResolveAllByFilter(ctx, Filter{BoardIDs: []string{boardEntity.ID}})
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve cards by board id")
+	}
+	view := NewView(*boardEntity, cards, input.GroupBy, field)
+	return &view, nil
+}
+
+// ResolveByKey resolves a card by its current key or one it had before it
+// moved to another board, case insensitive.
+func (svc *Service) ResolveByKey(ctx context.Context, key string) (*Card, error) {
+	id, err := svc.repo.ResolveIDByKey(ctx, strings.ToUpper(strings.TrimSpace(key)))
+	if err != nil {
+		return nil, err
+	}
+	return svc.ResolveByID(ctx, id)
+}
+
+// Resolve resolves a card by whatever identifies it in a shared link: the
+// entity ID, the public ID or the key, with the collections of the
+// projection.
+func (svc *Service) Resolve(ctx context.Context, ref string, projection Projection) (*Card, error) {
+	ref = strings.TrimSpace(ref)
+	id := ref
+	if _, err := uuid.Parse(ref); err != nil {
+		// public IDs have no dash, keys always have one
+		if strings.Contains(ref, "-") {
+			id, err = svc.repo.ResolveIDByKey(ctx, strings.ToUpper(ref))
+		} else {
+			id, err = svc.repo.ResolveIDByPublicID(ctx, strings.ToUpper(ref))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if projection == nil {
+		return svc.ResolveByID(ctx, id)
+	}
+	cards, err := svc.ResolveAllByFilter(ctx, Filter{IDs: []string{id}, Projection: projection})
+	if err != nil {
+		return nil, err
+	}
+	if len(cards) == 0 {
+		return nil, apierror.WithDesc(ErrorCodeEntityNotFound, "card couldn't be found")
+	}
+	return &cards[0], nil
 }
 
 func (svc *Service) ResolveAllByFilter(ctx context.Context, filter Filter) ([]Card, error) {
-	return svc.repo.ResolveAllByFilter(ctx, filter)
+	res, err := svc.repo.ResolveAllByFilter(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	// formula values are custom field values, there's nothing to evaluate
+	// them into when those aren't loaded
+	if !filter.Projection.Includes(CollectionCustomFields) {
+		return res, nil
+	}
+	err = svc.evaluateFormulas(ctx, res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ResolveByIDs resolves the cards in the order of ids, leaving out the ones
+// that couldn't be found.
+func (svc *Service) ResolveByIDs(ctx context.Context, ids []string, projection Projection) ([]Card, error) {
+	cards, err := svc.ResolveAllByFilter(ctx, Filter{IDs: ids, Projection: projection})
+	if err != nil {
+		return nil, err
+	}
+	cardMap := make(map[string]Card, 0)
+	for _, entity := range cards {
+		cardMap[entity.ID] = entity
+	}
+	res := make([]Card, 0)
+	for _, id := range ids {
+		if entity, exist := cardMap[id]; exist {
+			res = append(res, entity)
+		}
+	}
+	return res, nil
+}
+
+// BatchResolve resolves the cards with one result per requested ID, in the
+// same order.
+func (svc *Service) BatchResolve(ctx context.Context, ids []string) ([]BatchResult, error) {
+	err := validateBatchSize(len(ids))
+	if err != nil {
+		return nil, err
+	}
+	cards, err := svc.ResolveAllByFilter(ctx, Filter{IDs: ids})
+	if err != nil {
+		return nil, err
+	}
+	cardMap := make(map[string]*Card, 0)
+	for i := range cards {
+		cardMap[cards[i].ID] = &cards[i]
+	}
+	res := make([]BatchResult, len(ids))
+	for i, id := range ids {
+		res[i].ID = id
+		entity, exist := cardMap[id]
+		if !exist {
+			res[i].Err = apierror.WithDesc(ErrorCodeEntityNotFound, "card couldn't be found")
+			continue
+		}
+		res[i].Card = entity
+	}
+	return res, nil
 }
 
+// BatchUpdate applies the patches in order and stores every updated card in a
+// single transaction, which reads the cards too. A patch that can't be applied gets its own error in
+// the results without holding back the others.
+func (svc *Service) BatchUpdate(ctx context.Context, patches []Patch) ([]BatchResult, error) {
+	err := validateBatchSize(len(patches))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0)
+	for _, p := range patches {
+		ids = append(ids, p.CardID)
+	}
+	var res []BatchResult
+	var entities []Card
+	err = svc.tx.RetryTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		res, entities, err = svc.applyPatches(ctx, ids, patches)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(entities) > 0 {
+		err = svc.evaluateFormulas(ctx, entities)
+		if err != nil {
+			return nil, err
+		}
+	}
+	cardMap := make(map[string]*Card, 0)
+	for i := range entities {
+		cardMap[entities[i].ID] = &entities[i]
+	}
+	for i := range res {
+		if res[i].Err == nil {
+			res[i].Card = cardMap[res[i].ID]
+		}
+	}
+	return res, nil
+}
+
+// applyPatches reads the cards for update, patches them and stores the
+// updated ones. It returns a result per patch, without its card, and the
+// stored cards.
+func (svc *Service) applyPatches(ctx context.Context, ids []string, patches []Patch) ([]BatchResult, []Card, error) {
+	cards, err := svc.repo.ResolveAllByIDsForUpdate(ctx, ids)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "resolve cards by ids")
+	}
+	cardMap := make(map[string]*Card, 0)
+	for i := range cards {
+		if cards[i].DeletedAt == nil {
+			cardMap[cards[i].ID] = &cards[i]
+		}
+	}
+	validate := validator.New()
+	res := make([]BatchResult, len(patches))
+	updated := make([]string, 0)
+	for i, p := range patches {
+		res[i].ID = p.CardID
+		err := validate.Struct(p)
+		if err != nil {
+			res[i].Err = apierror.WithDesc(ErrorCodeInvalidInput, err.Error())
+			continue
+		}
+		entity, exist := cardMap[p.CardID]
+		if !exist {
+			res[i].Err = apierror.WithDesc(ErrorCodeEntityNotFound, "card couldn't be found")
+			continue
+		}
+		// patch a copy so a failed patch leaves the card as it was
+		patched := entity.deepCopy()
+		err = patched.ApplyPatch(p)
+		if err != nil {
+			res[i].Err = err
+			continue
+		}
+		cardMap[p.CardID] = &patched
+		updated = append(updated, p.CardID)
+	}
+	entities := make([]Card, 0)
+	stored := make(map[string]bool, 0)
+	for _, id := range updated {
+		if stored[id] {
+			continue
+		}
+		stored[id] = true
+		entities = append(entities, *cardMap[id])
+	}
+	if len(entities) > 0 {
+		err = svc.repo.StoreAll(ctx, entities)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "store cards")
+		}
+	}
+	return res, entities, nil
+}
+
+// evaluateFormulas recomputes formula fields on read so cards reflect the
+// current formula definitions of their boards, resolving the custom fields of
+// each board once.
+func (svc *Service) evaluateFormulas(ctx context.Context, cards []Card) error {
+	boardFields := make(map[string][]board.CustomField, 0)
+	for i := range cards {
+		fields, exist := boardFields[cards[i].BoardID]
+		if !exist {
+			var err error
+			fields, err = svc.boardService.ResolveCustomFields(ctx, cards[i].BoardID)
+			if err != nil {
+				return errors.Wrap(err, "resolve custom fields by board id")
+			}
+			boardFields[cards[i].BoardID] = fields
+		}
+		cards[i].EvaluateFormulas(fields)
+	}
+	return nil
+}
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// Search returns a page of the cards matching the filter, pages start at 1.
 func (svc *Service) Search(ctx context.Context, pageNum, limit int32, filter Filter) (res CardPage, err error) {
+	if pageNum < 1 {
+		pageNum = 1
+	}
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+	filter.Offset = int((pageNum - 1) * limit)
+	filter.Limit = int(limit)
 	total, err := svc.repo.CountByFilter(ctx, filter)
 	if err != nil {
 		return
 	}
-	items, err := svc.repo.ResolveAllByFilter(ctx, filter)
+	items, err := svc.ResolveAllByFilter(ctx, filter)
 	if err != nil {
 		return
 	}
@@ -0,0 +1,109 @@
+package card
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rakateja/milo/twirp-rpc-examples/card/database/databasetest"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/domains/board"
+)
+
+func testServices(t *testing.T) (*board.Service, *Service) {
+	db := databasetest.Open(t)
+	repo := NewSQLRepository(db)
+	boardService := board.NewService(board.NewSQLRepository(db), board.NewLabelSQLRepository(db), board.NewCustomFieldSQLRepository(db), NewBoardCardCopier(db), NewBoardListCards(repo), NewBoardFieldCards(repo, nil), db)
+	return boardService, NewService(repo, NewTemplateSQLRepository(db), boardService, db)
+}
+
+func TestBulkMoveToAnotherBoard(t *testing.T) {
+	boardService, svc := testServices(t)
+	ctx := context.Background()
+	source, err := boardService.Create(ctx, board.Input{
+		Title:   "Move source",
+		Members: []board.MemberInput{{UserID: "alice"}, {UserID: "bob"}},
+		Lists:   []board.ListInput{{Title: "Todo"}},
+	})
+	if err != nil {
+		t.Fatalf("Create source board returned error: %v", err)
+	}
+	source, err = boardService.CreateCustomField(ctx, source.ID, board.CustomFieldInput{Name: "Estimate", Type: board.FieldTypeNumber})
+	if err != nil {
+		t.Fatalf("CreateCustomField returned error: %v", err)
+	}
+	target, err := boardService.Create(ctx, board.Input{
+		Title:   "Move target",
+		Members: []board.MemberInput{{UserID: "alice"}},
+		Lists:   []board.ListInput{{Title: "Todo"}},
+	})
+	if err != nil {
+		t.Fatalf("Create target board returned error: %v", err)
+	}
+	entity, err := svc.Create(ctx, CardInput{
+		ListID:       source.Lists[0].ID,
+		BoardID:      source.ID,
+		Title:        "Moved card",
+		Members:      []MemberInput{{UserID: "alice"}, {UserID: "bob"}},
+		CustomFields: []CustomFieldValueInput{{FieldID: source.CustomFields[0].ID, Value: "3"}},
+	})
+	if err != nil {
+		t.Fatalf("Create card returned error: %v", err)
+	}
+	if len(entity.Members) != 2 || len(entity.CustomFields) != 1 {
+		t.Fatalf("created card has %d members and %d custom field values, want 2 and 1", len(entity.Members), len(entity.CustomFields))
+	}
+	_, err = svc.BulkMove(ctx, BulkMoveInput{CardIDs: []string{entity.ID}, ListID: target.Lists[0].ID})
+	if err != nil {
+		t.Fatalf("BulkMove returned error: %v", err)
+	}
+	got, err := svc.ResolveByID(ctx, entity.ID)
+	if err != nil {
+		t.Fatalf("ResolveByID returned error: %v", err)
+	}
+	if got.BoardID != target.ID || got.ListID != target.Lists[0].ID {
+		t.Errorf("moved card is on board %s list %s, want board %s list %s", got.BoardID, got.ListID, target.ID, target.Lists[0].ID)
+	}
+	if len(got.Members) != 1 || got.Members[0].UserID != "alice" {
+		t.Errorf("moved card members = %+v, want only alice, who has access to the target board", got.Members)
+	}
+	if len(got.CustomFields) != 0 {
+		t.Errorf("moved card custom field values = %+v, want none of the source board's", got.CustomFields)
+	}
+}
+
+func TestRemoveLabel(t *testing.T) {
+	boardService, svc := testServices(t)
+	ctx := context.Background()
+	boardEntity, err := boardService.Create(ctx, board.Input{
+		Title:  "Remove label",
+		Lists:  []board.ListInput{{Title: "Todo"}},
+		Labels: []board.LabelInput{{Title: "Bug", Color: "red"}, {Title: "Chore", Color: "grey"}},
+	})
+	if err != nil {
+		t.Fatalf("Create board returned error: %v", err)
+	}
+	labelIDs := make(map[string]string, 0)
+	for _, l := range boardEntity.Labels {
+		labelIDs[l.Title] = l.ID
+	}
+	bug, chore := labelIDs["Bug"], labelIDs["Chore"]
+	entity, err := svc.Create(ctx, CardInput{
+		ListID:   boardEntity.Lists[0].ID,
+		BoardID:  boardEntity.ID,
+		Title:    "Labeled card",
+		LabelIDs: []string{bug, chore},
+	})
+	if err != nil {
+		t.Fatalf("Create card returned error: %v", err)
+	}
+	_, err = svc.RemoveLabel(ctx, entity.ID, bug)
+	if err != nil {
+		t.Fatalf("RemoveLabel returned error: %v", err)
+	}
+	got, err := svc.ResolveByID(ctx, entity.ID)
+	if err != nil {
+		t.Fatalf("ResolveByID returned error: %v", err)
+	}
+	if len(got.Labels) != 1 || got.Labels[0].LabelID != chore {
+		t.Errorf("card labels = %+v, want only %s", got.Labels, chore)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
@@ -18,12 +19,13 @@ type SQLRepository struct {
 }
 
 const (
-	insertCardQuery = `
+	upsertCardQuery = `
 		INSERT INTO card (
 			entity_id,
 			list_id,
 			board_id,
 			public_id,
+			card_key,
 			title,
 			description,
 			due_date_from,
@@ -32,21 +34,19 @@ const (
 			created_at,
 			updated_at,
 			deleted_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`
-	updateCardQuery = `
-		UPDATE card SET
-			list_id = ?,
-			board_id = ?,
-			public_id = ?,
-			title = ?,
-			description = ?,
-			due_date_from = ?,
-			due_date_until = ?,
-			due_date_completed_at = ?,
-			updated_at = ?,
-			deleted_at = ?
-		WHERE entity_id = ?
+		) VALUES %s
+		ON DUPLICATE KEY UPDATE
+			list_id = VALUES(list_id),
+			board_id = VALUES(board_id),
+			public_id = VALUES(public_id),
+			card_key = VALUES(card_key),
+			title = VALUES(title),
+			description = VALUES(description),
+			due_date_from = VALUES(due_date_from),
+			due_date_until = VALUES(due_date_until),
+			due_date_completed_at = VALUES(due_date_completed_at),
+			updated_at = VALUES(updated_at),
+			deleted_at = VALUES(deleted_at)
 	`
 	selectCardIDQuery = `
 		SELECT
@@ -59,6 +59,7 @@ const (
 			c.list_id,
 			c.board_id,
 			c.public_id,
+			c.card_key,
 			c.title,
 			c.description,
 			c.due_date_from,
@@ -69,21 +70,41 @@ const (
 			c.deleted_at
 		FROM card c
 	`
+	allocateCardKeyQuery = `
+		UPDATE board SET
+			card_seq = LAST_INSERT_ID(card_seq + 1)
+		WHERE entity_id = ?
+	`
+	insertKeyQuery = `
+		INSERT INTO card_key (
+			card_key,
+			card_id,
+			board_id,
+			created_at
+		) VALUES (?, ?, ?, ?)
+	`
+	selectKeyQuery = `
+		SELECT
+			card_key,
+			card_id,
+			board_id,
+			created_at
+		FROM card_key
+	`
 	countCardQuery = `
 		SELECT
 			COUNT(entity_id)
 		FROM card c
 	`
-	insertMemberQuery = `
+	upsertMemberQuery = `
 		INSERT INTO card_member (
 			entity_id,
 			card_id,
 			user_id,
 			created_at
-		) VALUES (?, ?, ?, ?)
-	`
-	deleteMemberQuery = `
-		DELETE FROM card_member
+		) VALUES %s
+		ON DUPLICATE KEY UPDATE
+			user_id = VALUES(user_id)
 	`
 	selectMemberQuery = `
 		SELECT
@@ -98,7 +119,7 @@ const (
 			COUNT(entity_id)
 		FROM card_member
 	`
-	insertAttachmentQuery = `
+	upsertAttachmentQuery = `
 		INSERT INTO card_attachment (
 			entity_id,
 			card_id,
@@ -107,10 +128,12 @@ const (
 			file_url,
 			created_at,
 			updated_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?)
-	`
-	deleteAttachmentQuery = `
-		DELETE FROM card_attachment
+		) VALUES %s
+		ON DUPLICATE KEY UPDATE
+			link_name = VALUES(link_name),
+			file_type = VALUES(file_type),
+			file_url = VALUES(file_url),
+			updated_at = VALUES(updated_at)
 	`
 	selectAttachmentQuery = `
 		SELECT 
@@ -136,12 +159,129 @@ const (
 			created_at
 		FROM card_label
 	`
-	insertLabelQuery = `
+	upsertLabelQuery = `
 		INSERT INTO card_label (entity_id, card_id, label_id, created_at)
-		VALUES (?, ?, ?, ?)
+		VALUES %s
+		ON DUPLICATE KEY UPDATE
+			label_id = VALUES(label_id)
 	`
-	deleteLabelQuery = `
-		DELETE FROM card_label
+	selectWatcherQuery = `
+		SELECT
+			entity_id,
+			card_id,
+			user_id,
+			created_at
+		FROM card_watcher
+	`
+	upsertWatcherQuery = `
+		INSERT INTO card_watcher (entity_id, card_id, user_id, created_at)
+		VALUES %s
+		ON DUPLICATE KEY UPDATE
+			user_id = VALUES(user_id)
+	`
+	selectCustomFieldValueQuery = `
+		SELECT
+			entity_id,
+			card_id,
+			field_id,
+			value_text,
+			value_number,
+			value_date,
+			value_checked,
+			option_ids,
+			created_at,
+			updated_at
+		FROM card_custom_field_value
+	`
+	upsertCustomFieldValueQuery = `
+		INSERT INTO card_custom_field_value (
+			entity_id,
+			card_id,
+			field_id,
+			value_text,
+			value_number,
+			value_date,
+			value_checked,
+			option_ids,
+			created_at,
+			updated_at
+		) VALUES %s
+		ON DUPLICATE KEY UPDATE
+			field_id = VALUES(field_id),
+			value_text = VALUES(value_text),
+			value_number = VALUES(value_number),
+			value_date = VALUES(value_date),
+			value_checked = VALUES(value_checked),
+			option_ids = VALUES(option_ids),
+			updated_at = VALUES(updated_at)
+	`
+	selectChecklistQuery = `
+		SELECT
+			entity_id,
+			card_id,
+			title,
+			position,
+			created_at
+		FROM card_checklist
+	`
+	upsertChecklistQuery = `
+		INSERT INTO card_checklist (entity_id, card_id, title, position, created_at)
+		VALUES %s
+		ON DUPLICATE KEY UPDATE
+			title = VALUES(title),
+			position = VALUES(position)
+	`
+	selectChecklistItemQuery = `
+		SELECT
+			entity_id,
+			checklist_id,
+			card_id,
+			title,
+			checked,
+			position,
+			created_at,
+			updated_at
+		FROM card_checklist_item
+	`
+	upsertChecklistItemQuery = `
+		INSERT INTO card_checklist_item (
+			entity_id,
+			checklist_id,
+			card_id,
+			title,
+			checked,
+			position,
+			created_at,
+			updated_at
+		) VALUES %s
+		ON DUPLICATE KEY UPDATE
+			checklist_id = VALUES(checklist_id),
+			title = VALUES(title),
+			checked = VALUES(checked),
+			position = VALUES(position),
+			updated_at = VALUES(updated_at)
+	`
+	selectRelationQuery = `
+		SELECT
+			entity_id,
+			card_id,
+			related_card_id,
+			type,
+			created_at
+		FROM card_relation
+	`
+	insertRelationQuery = `
+		INSERT INTO card_relation (entity_id, card_id, related_card_id, type, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	upsertRelationQuery = `
+		INSERT INTO card_relation (entity_id, card_id, related_card_id, type, created_at)
+		VALUES %s
+		ON DUPLICATE KEY UPDATE
+			type = VALUES(type)
+	`
+	deleteRelationQuery = `
+		DELETE FROM card_relation
 	`
 )
 
@@ -149,16 +289,46 @@ func NewSQLRepository(db *database.MySQL) Repository {
 	return &SQLRepository{db: db}
 }
 
+// Store upserts the card with all its collections in one transaction. A new
+// card has them inserted, an existing one has them diffed.
 func (repo *SQLRepository) Store(ctx context.Context, entity *Card) error {
-	exist, err := repo.existByID(ctx, entity.ID)
+	err := repo.db.Transaction(ctx, func(ctx context.Context) error {
+		inserted, err := repo.upsert(ctx, entity)
+		if err != nil {
+			return err
+		}
+		if inserted {
+			return repo.insertCollections(ctx, []Card{*entity})
+		}
+		return repo.storeCollections(ctx, []Card{*entity})
+	})
 	if err != nil {
-		return errors.Wrap(err, "exist by id")
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// StoreAll updates existing cards with all their collections in one
+// transaction. The cards and each of their collections take a fixed number of
+// statements, however many cards there are.
+func (repo *SQLRepository) StoreAll(ctx context.Context, entities []Card) error {
+	if len(entities) == 0 {
+		return nil
 	}
-	err = repo.db.WithTransaction(func(tx *sqlx.Tx) error {
-		if exist {
-			return repo.update(tx, entity)
+	err := repo.db.Transaction(ctx, func(ctx context.Context) error {
+		rows := make([][]interface{}, 0, len(entities))
+		for i := range entities {
+			err := repo.allocateKey(ctx, &entities[i])
+			if err != nil {
+				return errors.Wrap(err, "allocate card key")
+			}
+			rows = append(rows, cardRow(entities[i]))
 		}
-		return repo.insert(tx, entity)
+		err := repo.db.BulkExec(ctx, upsertCardQuery, rows)
+		if err != nil {
+			return errors.Wrap(err, "upsert cards")
+		}
+		return repo.storeCollections(ctx, entities)
 	})
 	if err != nil {
 		return errors.WithStack(err)
@@ -167,62 +337,134 @@ func (repo *SQLRepository) Store(ctx context.Context, entity *Card) error {
 }
 
 func (repo *SQLRepository) StoreLabels(ctx context.Context, cardID string, labels []Label) error {
-	err := repo.db.WithTransaction(func(tx *sqlx.Tx) error {
-		err := repo.deleteLabelsByCardID(tx, cardID)
+	err := repo.db.Transaction(ctx, func(ctx context.Context) error {
+		return repo.storeLabels(ctx, []string{cardID}, labels)
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (repo *SQLRepository) StoreRelation(ctx context.Context, relation Relation) error {
+	return repo.db.Transaction(ctx, func(ctx context.Context) error {
+		_, err := repo.db.ExecContext(ctx, insertRelationQuery,
+			relation.ID,
+			relation.CardID,
+			relation.RelatedCardID,
+			relation.Type,
+			relation.CreatedAt,
+		)
 		if err != nil {
-			return errors.WithStack(err)
+			return errors.Wrap(err, "insert card relation")
 		}
-		err = repo.insertLabels(tx, labels)
+		return nil
+	})
+}
+
+func (repo *SQLRepository) DeleteRelation(ctx context.Context, relation Relation) error {
+	return repo.db.Transaction(ctx, func(ctx context.Context) error {
+		_, err := repo.db.ExecContext(ctx, deleteRelationQuery+" WHERE entity_id = ?", relation.ID)
 		if err != nil {
-			return errors.WithStack(err)
+			return errors.Wrap(err, "delete card relation")
 		}
 		return nil
 	})
+}
+
+// ResolveRelationsByCardIDs returns the relations in both directions, i.e.
+// the ones the cards own and the ones pointing at them.
+func (repo *SQLRepository) ResolveRelationsByCardIDs(ctx context.Context, cardIDs []string) (res []Relation, err error) {
+	if len(cardIDs) == 0 {
+		return
+	}
+	query, args, err := repo.db.In(database.ForUpdate(ctx, selectRelationQuery+" WHERE card_id IN (:card_id) OR related_card_id IN (:card_id) ORDER BY created_at"), map[string]interface{}{
+		"card_id": cardIDs,
+	})
 	if err != nil {
-		return errors.WithStack(err)
+		return
 	}
-	return nil
+	query = repo.db.Rebind(query)
+	err = repo.db.SelectContext(ctx, &res, query, args...)
+	if err != nil {
+		err = errors.Wrap(err, "resolve relation by card id")
+		return
+	}
+	return res, nil
 }
 
+// ResolveByID reads the card and its collections for update inside a
+// transaction, so storing it back doesn't drop the rows other transactions
+// wrote since.
 func (repo *SQLRepository) ResolveByID(ctx context.Context, id string) (*Card, error) {
 	log.Println("ResolveByID() is invoked")
 	var result Card
-	err := repo.db.Get(&result, selectCardQuery+" WHERE c.entity_id = ?", id)
+	err := repo.db.GetContext(ctx, &result, database.ForUpdate(ctx, selectCardQuery+" WHERE c.entity_id = ?"), id)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, apierror.WithDesc(ErrorCodeEntityNotFound, "card couldn't be found")
 		}
 		return nil, errors.Wrap(err, "select card by id")
 	}
-	members, err := repo.resolveMembersByCardID(ctx, []string{result.ID})
+	cards := []Card{result}
+	err = repo.loadCollections(ctx, cards, nil)
 	if err != nil {
-		return nil, errors.Wrap(err, "resolve member by card ids")
+		return nil, err
 	}
-	attachments, err := repo.resolveAttachmentsByCardID(ctx, []string{result.ID})
+	return &cards[0], nil
+}
+
+func (repo *SQLRepository) ResolveIDByPublicID(ctx context.Context, publicID string) (string, error) {
+	var id string
+	err := repo.db.GetContext(ctx, &id, selectCardIDQuery+" WHERE c.public_id = ?", publicID)
 	if err != nil {
-		return nil, errors.Wrap(err, "resolve attachment by card ids")
+		if err == sql.ErrNoRows {
+			return "", apierror.WithDesc(ErrorCodeEntityNotFound, "card couldn't be found")
+		}
+		return "", errors.Wrap(err, "select card id by public id")
 	}
-	labels, err := repo.selectLabelsByCardID(id)
+	return id, nil
+}
+
+// ResolveIDByKey also finds cards by the keys they had before moving boards.
+func (repo *SQLRepository) ResolveIDByKey(ctx context.Context, key string) (string, error) {
+	var id string
+	err := repo.db.GetContext(ctx, &id, "SELECT card_id FROM card_key WHERE card_key = ?", key)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", apierror.WithDesc(ErrorCodeEntityNotFound, "card couldn't be found")
+		}
+		return "", errors.Wrap(err, "select card id by key")
+	}
+	return id, nil
+}
+
+// ResolveIDsByCustomFieldID returns the cards holding a value of the field.
+// The value rows are locked, so inside a transaction no card gets a value of
+// the field until it ends.
+func (repo *SQLRepository) ResolveIDsByCustomFieldID(ctx context.Context, fieldID string) ([]string, error) {
+	ids := make([]string, 0)
+	err := repo.db.SelectContext(ctx, &ids, "SELECT card_id FROM card_custom_field_value WHERE field_id = ? FOR UPDATE", fieldID)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, errors.Wrap(err, "select card ids by custom field id")
 	}
-	result.Members = members
-	result.Attachments = attachments
-	result.Labels = labels
-	return &result, nil
+	return ids, nil
 }
 
 func (repo *SQLRepository) ResolveAllIDsByFilter(ctx context.Context, filter Filter) ([]string, error) {
-	whereClauseQuery, joinQuery, values := repo.buildQueryWithFilter(filter)
-	query, args, err := repo.db.In(selectCardIDQuery+" "+joinQuery+" "+whereClauseQuery, values)
+	whereClauseQuery, values := repo.buildQueryWithFilter(filter)
+	sortJoinQuery, orderQuery := repo.buildSortQuery(filter, values)
+	limitQuery := repo.buildLimitQuery(filter, values)
+	query, args, err := repo.db.In(selectCardIDQuery+" "+sortJoinQuery+" "+whereClauseQuery+" "+orderQuery+" "+limitQuery, values)
 	if err != nil {
 		return make([]string, 0), errors.WithStack(err)
 	}
 	query = repo.db.Rebind(query)
-	rows, err := repo.db.Query(query, args...)
+	rows, err := repo.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return make([]string, 0), errors.WithStack(err)
 	}
+	defer rows.Close()
 	var cardIDs []string
 	for rows.Next() {
 		var id string
@@ -231,23 +473,27 @@ func (repo *SQLRepository) ResolveAllIDsByFilter(ctx context.Context, filter Fil
 		}
 		cardIDs = append(cardIDs, id)
 	}
+	if err := rows.Err(); err != nil {
+		return make([]string, 0), errors.Wrap(err, "select card ids with filter")
+	}
 	return cardIDs, nil
 }
 
 func (repo *SQLRepository) ResolveIDsByFilter(ctx context.Context, filter Filter, limit int) ([]string, error) {
-	whereClauseQuery, joinQuery, values := repo.buildQueryWithFilter(filter)
-	log.Printf("ResolveIDsByFilter() %s %s %v", joinQuery, whereClauseQuery, values)
+	whereClauseQuery, values := repo.buildQueryWithFilter(filter)
+	log.Printf("ResolveIDsByFilter() %s %v", whereClauseQuery, values)
+	sortJoinQuery, orderQuery := repo.buildSortQuery(filter, values)
 	values["limit"] = limit
-	fmt.Printf("ResolveIDsByFilter() %v\n", values)
-	query, args, err := repo.db.In(selectCardIDQuery+" "+joinQuery+" "+whereClauseQuery+" LIMIT :limit", values)
+	query, args, err := repo.db.In(selectCardIDQuery+" "+sortJoinQuery+" "+whereClauseQuery+" "+orderQuery+" LIMIT :limit", values)
 	if err != nil {
 		return make([]string, 0), errors.WithStack(err)
 	}
 	query = repo.db.Rebind(query)
-	rows, err := repo.db.Query(query, args...)
+	rows, err := repo.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return make([]string, 0), errors.WithStack(err)
 	}
+	defer rows.Close()
 	var cardIDs []string
 	for rows.Next() {
 		var id string
@@ -260,70 +506,213 @@ func (repo *SQLRepository) ResolveIDsByFilter(ctx context.Context, filter Filter
 }
 
 func (repo *SQLRepository) ResolveAllByFilter(ctx context.Context, filter Filter) ([]Card, error) {
-	if filter.IsEmpty() {
+	// an empty filter reads every card, it's only served a page at a time
+	if filter.IsEmpty() && filter.Limit <= 0 {
 		return nil, nil
 	}
-	whereClauseQuery, joinQuery, values := repo.buildQueryWithFilter(filter)
-	query, args, err := repo.db.In(selectCardQuery+" "+joinQuery+" "+whereClauseQuery, values)
+	whereClauseQuery, values := repo.buildQueryWithFilter(filter)
+	sortJoinQuery, orderQuery := repo.buildSortQuery(filter, values)
+	limitQuery := repo.buildLimitQuery(filter, values)
+	query, args, err := repo.db.In(selectCardQuery+" "+sortJoinQuery+" "+whereClauseQuery+" "+orderQuery+" "+limitQuery, values)
 	if err != nil {
 		err = errors.WithStack(err)
 		return nil, err
 	}
 	query = repo.db.Rebind(query)
 	var res []Card
-	err = repo.db.Select(&res, query, args...)
+	err = repo.db.SelectContext(ctx, &res, query, args...)
 	if err != nil {
 		err = errors.WithMessage(err, "select card with filter")
 		return nil, err
 	}
-	var cardIDs []string
-	for _, entity := range res {
-		cardIDs = append(cardIDs, entity.ID)
-	}
-	members, err := repo.resolveMembersByCardID(ctx, cardIDs)
+	err = repo.loadCollections(ctx, res, filter.Projection)
 	if err != nil {
-		err = errors.Wrap(err, "resolve members by card id")
 		return nil, err
 	}
-	attachments, err := repo.resolveAttachmentsByCardID(ctx, cardIDs)
+	return res, nil
+}
+
+// ResolveAllByIDsForUpdate locks the cards in id order before reading them,
+// their collections are read for update too.
+func (repo *SQLRepository) ResolveAllByIDsForUpdate(ctx context.Context, ids []string) ([]Card, error) {
+	err := repo.LockByIDs(ctx, ids)
 	if err != nil {
-		err = errors.Wrap(err, "resolve attacment by card id")
 		return nil, err
 	}
+	return repo.ResolveAllByFilter(ctx, Filter{IDs: ids})
+}
+
+// loadCollections loads the collections of the cards the projection
+// includes, one query per collection however many cards there are. Inside a
+// transaction the collections a store diffs are locked.
+func (repo *SQLRepository) loadCollections(ctx context.Context, cards []Card, projection Projection) error {
+	if len(cards) == 0 {
+		return nil
+	}
+	var cardIDs []string
+	for _, entity := range cards {
+		cardIDs = append(cardIDs, entity.ID)
+	}
 	membersMap := make(map[string][]Member, 0)
 	attachmentsMap := make(map[string][]Attachment, 0)
-	for _, m := range members {
-		membersMap[m.CardID] = append(membersMap[m.CardID], m)
+	watchersMap := make(map[string][]Watcher, 0)
+	customFieldsMap := make(map[string][]CustomFieldValue, 0)
+	labelsMap := make(map[string][]Label, 0)
+	checklistsMap := make(map[string][]Checklist, 0)
+	relationsMap := make(map[string][]Relation, 0)
+	keysMap := make(map[string][]cardKey, 0)
+	if projection.Includes(CollectionMembers) {
+		members, err := repo.resolveMembersByCardID(ctx, cardIDs)
+		if err != nil {
+			return errors.Wrap(err, "resolve members by card id")
+		}
+		for _, m := range members {
+			membersMap[m.CardID] = append(membersMap[m.CardID], m)
+		}
 	}
-	for _, a := range attachments {
-		attachmentsMap[a.CardID] = append(attachmentsMap[a.CardID], a)
+	if projection.Includes(CollectionAttachments) {
+		attachments, err := repo.resolveAttachmentsByCardID(ctx, cardIDs)
+		if err != nil {
+			return errors.Wrap(err, "resolve attacment by card id")
+		}
+		for _, a := range attachments {
+			attachmentsMap[a.CardID] = append(attachmentsMap[a.CardID], a)
+		}
+	}
+	if projection.Includes(CollectionWatchers) {
+		watchers, err := repo.resolveWatchersByCardID(ctx, cardIDs)
+		if err != nil {
+			return errors.Wrap(err, "resolve watchers by card id")
+		}
+		for _, w := range watchers {
+			watchersMap[w.CardID] = append(watchersMap[w.CardID], w)
+		}
+	}
+	if projection.Includes(CollectionCustomFields) {
+		customFields, err := repo.resolveCustomFieldValuesByCardID(ctx, cardIDs)
+		if err != nil {
+			return errors.Wrap(err, "resolve custom field values by card id")
+		}
+		for _, v := range customFields {
+			customFieldsMap[v.CardID] = append(customFieldsMap[v.CardID], v)
+		}
+	}
+	if projection.Includes(CollectionLabels) {
+		labels, err := repo.resolveLabelsByCardID(ctx, cardIDs)
+		if err != nil {
+			return errors.Wrap(err, "resolve labels by card id")
+		}
+		for _, l := range labels {
+			labelsMap[l.CardID] = append(labelsMap[l.CardID], l)
+		}
+	}
+	if projection.Includes(CollectionChecklists) {
+		checklists, err := repo.resolveChecklistsByCardID(ctx, cardIDs)
+		if err != nil {
+			return errors.Wrap(err, "resolve checklists by card id")
+		}
+		for _, c := range checklists {
+			checklistsMap[c.CardID] = append(checklistsMap[c.CardID], c)
+		}
 	}
-	var result []Card
-	for _, cardEntity := range res {
+	if projection.Includes(CollectionRelations) {
+		relations, err := repo.ResolveRelationsByCardIDs(ctx, cardIDs)
+		if err != nil {
+			return errors.Wrap(err, "resolve relations by card id")
+		}
+		for _, r := range relations {
+			relationsMap[r.CardID] = append(relationsMap[r.CardID], r)
+			relationsMap[r.RelatedCardID] = append(relationsMap[r.RelatedCardID], r)
+		}
+	}
+	if projection.Includes(CollectionPreviousKeys) {
+		keys, err := repo.resolveKeysByCardID(ctx, cardIDs)
+		if err != nil {
+			return errors.Wrap(err, "resolve keys by card id")
+		}
+		for _, k := range keys {
+			keysMap[k.CardID] = append(keysMap[k.CardID], k)
+		}
+	}
+	for i := range cards {
+		cardEntity := &cards[i]
 		cardEntity.Attachments = attachmentsMap[cardEntity.ID]
 		cardEntity.Members = membersMap[cardEntity.ID]
-		result = append(result, cardEntity)
+		cardEntity.Watchers = watchersMap[cardEntity.ID]
+		cardEntity.CustomFields = customFieldsMap[cardEntity.ID]
+		cardEntity.Labels = labelsMap[cardEntity.ID]
+		cardEntity.Checklists = checklistsMap[cardEntity.ID]
+		cardEntity.Relations = relationsMap[cardEntity.ID]
+		if projection.Includes(CollectionPreviousKeys) {
+			cardEntity.PreviousKeys = previousKeys(*cardEntity, keysMap[cardEntity.ID])
+		}
+	}
+	return nil
+}
+
+// CountListCardsForUpdate counts the cards of the list once its row is
+// locked. The lock holds until the transaction of ctx ends, so another count
+// of the list waits for the cards this transaction stores.
+func (repo *SQLRepository) CountListCardsForUpdate(ctx context.Context, listID string) (int, error) {
+	if !database.InTransaction(ctx) {
+		return 0, errors.New("list cards can only be counted for update in a transaction")
+	}
+	var id string
+	err := repo.db.GetContext(ctx, &id, "SELECT entity_id FROM board_list WHERE entity_id = ? FOR UPDATE", listID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, apierror.WithDesc(ErrorCodeEntityNotFound, "board list couldn't be found")
+		}
+		return 0, errors.Wrap(err, "lock board list")
+	}
+	var total int
+	err = repo.db.GetContext(ctx, &total, countCardQuery+" WHERE list_id = ? AND deleted_at IS NULL", listID)
+	if err != nil {
+		return 0, errors.Wrap(err, "count cards by list id")
+	}
+	return total, nil
+}
+
+// LockByIDs locks the rows of the cards in id order until the transaction of
+// ctx ends, without reading their collections.
+func (repo *SQLRepository) LockByIDs(ctx context.Context, ids []string) error {
+	if !database.InTransaction(ctx) {
+		return errors.New("cards can only be locked in a transaction")
 	}
-	return result, nil
+	if len(ids) == 0 {
+		return nil
+	}
+	query, args, err := repo.db.In("SELECT entity_id FROM card WHERE entity_id IN (:entity_id) ORDER BY entity_id FOR UPDATE", map[string]interface{}{
+		"entity_id": ids,
+	})
+	if err != nil {
+		return err
+	}
+	var locked []string
+	err = repo.db.SelectContext(ctx, &locked, repo.db.Rebind(query), args...)
+	if err != nil {
+		return errors.Wrap(err, "lock cards")
+	}
+	return nil
 }
 
 func (repo *SQLRepository) CountByFilter(ctx context.Context, filter Filter) (int, error) {
-	whereClauseQuery, joinQuery, values := repo.buildQueryWithFilter(filter)
-	query, args, err := repo.db.In(countCardQuery+" "+joinQuery+" "+whereClauseQuery, values)
+	whereClauseQuery, values := repo.buildQueryWithFilter(filter)
+	query, args, err := repo.db.In(countCardQuery+" "+whereClauseQuery, values)
 	if err != nil {
 		err = errors.WithStack(err)
 		return 0, err
 	}
 	query = repo.db.Rebind(query)
 	var total int
-	err = repo.db.Get(&total, query, args...)
+	err = repo.db.GetContext(ctx, &total, query, args...)
 	if err != nil {
 		return total, errors.Wrap(err, "count rows by filter")
 	}
 	return total, nil
 }
 
-func (repo *SQLRepository) buildQueryWithFilter(filter Filter) (whereClauseQuery string, joinQuery string, values map[string]interface{}) {
+func (repo *SQLRepository) buildQueryWithFilter(filter Filter) (whereClauseQuery string, values map[string]interface{}) {
 	values = make(map[string]interface{}, 0)
 	params := make([]string, 0)
 	if len(filter.IDs) > 0 {
@@ -343,26 +732,92 @@ func (repo *SQLRepository) buildQueryWithFilter(filter Filter) (whereClauseQuery
 		values["board_ids"] = filter.BoardIDs
 	}
 	if len(filter.UserIDs) > 0 {
-		joinQuery = "INNER JOIN card_member m ON m.card_id = c.entity_id"
-		params = append(params, "m.user_id IN (:user_ids)")
+		// a join would repeat the cards with several of the users as members
+		params = append(params, "EXISTS (SELECT 1 FROM card_member m WHERE m.card_id = c.entity_id AND m.user_id IN (:user_ids))")
 		values["user_ids"] = filter.UserIDs
 	}
+	for i, f := range filter.CustomFields {
+		fieldKey := fmt.Sprintf("cf%d_field_id", i)
+		valueKey := fmt.Sprintf("cf%d_value", i)
+		values[fieldKey] = f.FieldID
+		existQuery := fmt.Sprintf("SELECT 1 FROM card_custom_field_value cf%d WHERE cf%d.card_id = c.entity_id AND cf%d.field_id = :%s", i, i, i, fieldKey)
+		switch {
+		case f.Value.Text != nil:
+			params = append(params, fmt.Sprintf("EXISTS (%s AND cf%d.value_text = :%s)", existQuery, i, valueKey))
+			values[valueKey] = *f.Value.Text
+		case f.Value.Number != nil:
+			params = append(params, fmt.Sprintf("EXISTS (%s AND cf%d.value_number = :%s)", existQuery, i, valueKey))
+			values[valueKey] = *f.Value.Number
+		case f.Value.Date != nil:
+			params = append(params, fmt.Sprintf("EXISTS (%s AND cf%d.value_date = :%s)", existQuery, i, valueKey))
+			values[valueKey] = *f.Value.Date
+		case f.Value.Checked != nil && *f.Value.Checked:
+			params = append(params, fmt.Sprintf("EXISTS (%s AND cf%d.value_checked = 1)", existQuery, i))
+		case f.Value.Checked != nil:
+			// unchecked cards usually have no value row at all
+			params = append(params, fmt.Sprintf("NOT EXISTS (%s AND cf%d.value_checked = 1)", existQuery, i))
+		default:
+			for j, optionID := range f.Value.OptionIDs {
+				optionKey := fmt.Sprintf("cf%d_option%d", i, j)
+				existQuery += fmt.Sprintf(" AND FIND_IN_SET(:%s, cf%d.option_ids)", optionKey, i)
+				values[optionKey] = optionID
+			}
+			params = append(params, fmt.Sprintf("EXISTS (%s)", existQuery))
+		}
+	}
+	if filter.Blocked {
+		// a blocker counts until its due date is completed or it's deleted
+		params = append(params, `EXISTS (
+			SELECT 1 FROM card_relation r
+			INNER JOIN card b ON b.entity_id = r.related_card_id
+			WHERE r.card_id = c.entity_id AND r.type = :blocked_type
+				AND b.due_date_completed_at IS NULL AND b.deleted_at IS NULL)`)
+		values["blocked_type"] = string(RelationTypeBlockedBy)
+	}
 	if len(params) == 0 {
-		return "", "", make(map[string]interface{}, 0)
+		return "", make(map[string]interface{}, 0)
 	}
 	whereClauseQuery = "WHERE " + strings.Join(params, " AND ")
-	return whereClauseQuery, joinQuery, values
+	return whereClauseQuery, values
+}
+
+// buildSortQuery joins the custom field value the cards are sorted by. Only
+// one of the value columns is set for a field, so ordering by all of them
+// sorts by whichever type the field has.
+func (repo *SQLRepository) buildSortQuery(filter Filter, values map[string]interface{}) (joinQuery string, orderQuery string) {
+	// the ID breaks ties, so pages don't overlap
+	if filter.Sort.CustomFieldID == "" {
+		return "", "ORDER BY c.created_at, c.entity_id"
+	}
+	direction := "ASC"
+	if filter.Sort.Descending {
+		direction = "DESC"
+	}
+	values["sort_field_id"] = filter.Sort.CustomFieldID
+	joinQuery = "LEFT JOIN card_custom_field_value s ON s.card_id = c.entity_id AND s.field_id = :sort_field_id"
+	orderQuery = fmt.Sprintf("ORDER BY s.value_number %[1]s, s.value_date %[1]s, s.value_text %[1]s, s.value_checked %[1]s, c.created_at, c.entity_id", direction)
+	return joinQuery, orderQuery
+}
+
+// buildLimitQuery pages the cards when the filter has a limit.
+func (repo *SQLRepository) buildLimitQuery(filter Filter, values map[string]interface{}) string {
+	if filter.Limit <= 0 {
+		return ""
+	}
+	values["limit"] = filter.Limit
+	values["offset"] = filter.Offset
+	return "LIMIT :limit OFFSET :offset"
 }
 
 func (repo *SQLRepository) resolveMembersByCardID(ctx context.Context, cardIDs []string) (res []Member, err error) {
-	query, args, err := repo.db.In(selectMemberQuery+" WHERE card_id IN (:card_id)", map[string]interface{}{
+	query, args, err := repo.db.In(database.ForUpdate(ctx, selectMemberQuery+" WHERE card_id IN (:card_id)"), map[string]interface{}{
 		"card_id": cardIDs,
 	})
 	if err != nil {
 		return
 	}
 	query = repo.db.Rebind(query)
-	err = repo.db.Select(&res, query, args...)
+	err = repo.db.SelectContext(ctx, &res, query, args...)
 	if err != nil {
 		err = errors.Wrap(err, "resolve member by card id")
 		return
@@ -371,14 +826,14 @@ func (repo *SQLRepository) resolveMembersByCardID(ctx context.Context, cardIDs [
 }
 
 func (repo *SQLRepository) resolveAttachmentsByCardID(ctx context.Context, cardIDs []string) (res []Attachment, err error) {
-	query, args, err := repo.db.In(selectAttachmentQuery+" WHERE card_id IN (:card_id)", map[string]interface{}{
+	query, args, err := repo.db.In(database.ForUpdate(ctx, selectAttachmentQuery+" WHERE card_id IN (:card_id)"), map[string]interface{}{
 		"card_id": cardIDs,
 	})
 	if err != nil {
 		return
 	}
 	query = repo.db.Rebind(query)
-	err = repo.db.Select(&res, query, args...)
+	err = repo.db.SelectContext(ctx, &res, query, args...)
 	if err != nil {
 		err = errors.Wrap(err, "resolve attachment by card id")
 		return
@@ -386,21 +841,113 @@ func (repo *SQLRepository) resolveAttachmentsByCardID(ctx context.Context, cardI
 	return res, nil
 }
 
-func (repo *SQLRepository) existByID(ctx context.Context, id string) (bool, error) {
-	var total int
-	err := repo.db.Get(&total, countCardQuery+" WHERE entity_id = ?", id)
+func (repo *SQLRepository) resolveWatchersByCardID(ctx context.Context, cardIDs []string) (res []Watcher, err error) {
+	query, args, err := repo.db.In(database.ForUpdate(ctx, selectWatcherQuery+" WHERE card_id IN (:card_id)"), map[string]interface{}{
+		"card_id": cardIDs,
+	})
+	if err != nil {
+		return
+	}
+	query = repo.db.Rebind(query)
+	err = repo.db.SelectContext(ctx, &res, query, args...)
 	if err != nil {
-		return false, errors.Wrap(err, "execute count card query")
+		err = errors.Wrap(err, "resolve watcher by card id")
+		return
+	}
+	return res, nil
+}
+
+func (repo *SQLRepository) resolveCustomFieldValuesByCardID(ctx context.Context, cardIDs []string) (res []CustomFieldValue, err error) {
+	query, args, err := repo.db.In(database.ForUpdate(ctx, selectCustomFieldValueQuery+" WHERE card_id IN (:card_id)"), map[string]interface{}{
+		"card_id": cardIDs,
+	})
+	if err != nil {
+		return
+	}
+	query = repo.db.Rebind(query)
+	err = repo.db.SelectContext(ctx, &res, query, args...)
+	if err != nil {
+		err = errors.Wrap(err, "resolve custom field value by card id")
+		return
 	}
-	return total > 0, nil
+	return res, nil
 }
 
-func (repo *SQLRepository) insert(tx *sqlx.Tx, entity *Card) error {
-	res, err := tx.Exec(insertCardQuery,
+func (repo *SQLRepository) resolveLabelsByCardID(ctx context.Context, cardIDs []string) (res []Label, err error) {
+	query, args, err := repo.db.In(database.ForUpdate(ctx, selectLabelQuery+" WHERE card_id IN (:card_id)"), map[string]interface{}{
+		"card_id": cardIDs,
+	})
+	if err != nil {
+		return
+	}
+	err = repo.db.SelectContext(ctx, &res, repo.db.Rebind(query), args...)
+	if err != nil {
+		err = errors.Wrap(err, "resolve label by card id")
+		return
+	}
+	return res, nil
+}
+
+func (repo *SQLRepository) resolveChecklistsByCardID(ctx context.Context, cardIDs []string) (res []Checklist, err error) {
+	query, args, err := repo.db.In(database.ForUpdate(ctx, selectChecklistQuery+" WHERE card_id IN (:card_id) ORDER BY position"), map[string]interface{}{
+		"card_id": cardIDs,
+	})
+	if err != nil {
+		return
+	}
+	err = repo.db.SelectContext(ctx, &res, repo.db.Rebind(query), args...)
+	if err != nil {
+		err = errors.Wrap(err, "resolve checklist by card id")
+		return
+	}
+	query, args, err = repo.db.In(database.ForUpdate(ctx, selectChecklistItemQuery+" WHERE card_id IN (:card_id) ORDER BY position"), map[string]interface{}{
+		"card_id": cardIDs,
+	})
+	if err != nil {
+		return
+	}
+	var items []ChecklistItem
+	err = repo.db.SelectContext(ctx, &items, repo.db.Rebind(query), args...)
+	if err != nil {
+		err = errors.Wrap(err, "resolve checklist item by card id")
+		return
+	}
+	itemsMap := make(map[string][]ChecklistItem, 0)
+	for _, item := range items {
+		itemsMap[item.ChecklistID] = append(itemsMap[item.ChecklistID], item)
+	}
+	for i := range res {
+		res[i].Items = itemsMap[res[i].ID]
+	}
+	return res, nil
+}
+
+// upsert writes the card row and tells whether it was inserted, MySQL
+// counts 1 affected row for an insert, 2 for an update and 0 when nothing
+// changed.
+func (repo *SQLRepository) upsert(ctx context.Context, entity *Card) (bool, error) {
+	err := repo.allocateKey(ctx, entity)
+	if err != nil {
+		return false, errors.Wrap(err, "allocate card key")
+	}
+	res, err := repo.db.ExecContext(ctx, fmt.Sprintf(upsertCardQuery, "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"), cardRow(*entity)...)
+	if err != nil {
+		return false, errors.Wrap(err, "upsert card")
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "checking rows affected")
+	}
+	return rowsAffected == 1, nil
+}
+
+func cardRow(entity Card) []interface{} {
+	return []interface{}{
 		entity.ID,
 		entity.ListID,
 		entity.BoardID,
 		entity.PublicID,
+		entity.Key,
 		entity.Title,
 		entity.Description,
 		entity.DueDateFrom,
@@ -409,161 +956,383 @@ func (repo *SQLRepository) insert(tx *sqlx.Tx, entity *Card) error {
 		entity.CreatedAt,
 		entity.UpdatedAt,
 		entity.DeletedAt,
-	)
-	if err != nil {
-		return errors.Wrap(err, "insert card")
 	}
-	rowsAffected, err := res.RowsAffected()
+}
+
+// insertAll writes new cards with their collections. Only the keys are
+// allocated card by card, the rows take a multi-row statement per table.
+func (repo *SQLRepository) insertAll(ctx context.Context, entities []Card) error {
+	if len(entities) == 0 {
+		return nil
+	}
+	rows := make([][]interface{}, 0, len(entities))
+	for i := range entities {
+		err := repo.allocateKey(ctx, &entities[i])
+		if err != nil {
+			return errors.Wrap(err, "allocate card key")
+		}
+		rows = append(rows, cardRow(entities[i]))
+	}
+	err := repo.db.BulkExec(ctx, upsertCardQuery, rows)
 	if err != nil {
-		return errors.Wrap(err, "checking rows affected")
+		return errors.Wrap(err, "insert cards")
 	}
-	if rowsAffected <= 0 {
-		return errors.New("no rows affected")
+	return repo.insertCollections(ctx, entities)
+}
+
+// insertCollections inserts the collections of new cards, a multi-row
+// statement per collection.
+func (repo *SQLRepository) insertCollections(ctx context.Context, entities []Card) error {
+	var members []Member
+	var attachments []Attachment
+	var watchers []Watcher
+	var values []CustomFieldValue
+	var labels []Label
+	var checklists []Checklist
+	for _, entity := range entities {
+		members = append(members, entity.Members...)
+		attachments = append(attachments, entity.Attachments...)
+		watchers = append(watchers, entity.Watchers...)
+		values = append(values, entity.CustomFields...)
+		labels = append(labels, entity.Labels...)
+		checklists = append(checklists, entity.Checklists...)
 	}
-	err = repo.insertMembers(tx, entity.Members)
+	err := repo.upsertMembers(ctx, members)
 	if err != nil {
 		return errors.Wrap(err, "insert members")
 	}
-	err = repo.insertAttachments(tx, entity.Attachments)
+	err = repo.upsertAttachments(ctx, attachments)
 	if err != nil {
 		return errors.Wrap(err, "insert attachments")
 	}
+	err = repo.upsertWatchers(ctx, watchers)
+	if err != nil {
+		return errors.Wrap(err, "insert watchers")
+	}
+	err = repo.upsertCustomFieldValues(ctx, values)
+	if err != nil {
+		return errors.Wrap(err, "insert custom field values")
+	}
+	err = repo.upsertLabels(ctx, labels)
+	if err != nil {
+		return errors.Wrap(err, "insert labels")
+	}
+	err = repo.upsertChecklists(ctx, checklists)
+	if err != nil {
+		return errors.Wrap(err, "insert checklists")
+	}
 	return nil
 }
 
-func (repo *SQLRepository) update(tx *sqlx.Tx, entity *Card) error {
-	res, err := tx.Exec(updateCardQuery,
-		entity.ListID,
-		entity.BoardID,
-		entity.PublicID,
-		entity.Title,
-		entity.Description,
-		entity.DueDateFrom,
-		entity.DueDateUntil,
-		entity.DueDateCompletedAt,
-		entity.UpdatedAt,
-		entity.DeletedAt,
-		entity.ID,
-	)
+// storeCollections diffs the collections of existing cards: the rows they no
+// longer have are deleted and the others upserted, MySQL leaving the ones that
+// didn't change untouched. Only the relations a card owns are its rows.
+func (repo *SQLRepository) storeCollections(ctx context.Context, entities []Card) error {
+	cardIDs := make([]string, 0, len(entities))
+	var members []Member
+	var attachments []Attachment
+	var watchers []Watcher
+	var values []CustomFieldValue
+	var labels []Label
+	var checklists []Checklist
+	var relations []Relation
+	for _, entity := range entities {
+		cardIDs = append(cardIDs, entity.ID)
+		members = append(members, entity.Members...)
+		attachments = append(attachments, entity.Attachments...)
+		watchers = append(watchers, entity.Watchers...)
+		values = append(values, entity.CustomFields...)
+		labels = append(labels, entity.Labels...)
+		checklists = append(checklists, entity.Checklists...)
+		for _, r := range entity.Relations {
+			if r.CardID == entity.ID {
+				relations = append(relations, r)
+			}
+		}
+	}
+	var memberIDs []string
+	for _, m := range members {
+		memberIDs = append(memberIDs, m.ID)
+	}
+	err := repo.db.DeleteExcept(ctx, "card_member", "card_id IN (?)", cardIDs, memberIDs)
 	if err != nil {
-		return errors.Wrap(err, "insert card")
+		return errors.Wrap(err, "delete members")
 	}
-	_, err = res.RowsAffected()
+	err = repo.upsertMembers(ctx, members)
 	if err != nil {
-		return errors.Wrap(err, "checking rows affected")
+		return errors.Wrap(err, "upsert members")
+	}
+	var attachmentIDs []string
+	for _, a := range attachments {
+		attachmentIDs = append(attachmentIDs, a.ID)
 	}
-	// update members
-	err = repo.deleteMemberByCardID(tx, entity.ID)
+	err = repo.db.DeleteExcept(ctx, "card_attachment", "card_id IN (?)", cardIDs, attachmentIDs)
 	if err != nil {
-		return errors.Wrap(err, "delete members by card id")
+		return errors.Wrap(err, "delete attachments")
 	}
-	err = repo.insertMembers(tx, entity.Members)
+	err = repo.upsertAttachments(ctx, attachments)
 	if err != nil {
-		return errors.Wrap(err, "insert members")
+		return errors.Wrap(err, "upsert attachments")
+	}
+	var watcherIDs []string
+	for _, w := range watchers {
+		watcherIDs = append(watcherIDs, w.ID)
 	}
-	// update attachments
-	err = repo.deleteAttachmentsByCardID(tx, entity.ID)
+	err = repo.db.DeleteExcept(ctx, "card_watcher", "card_id IN (?)", cardIDs, watcherIDs)
 	if err != nil {
-		return errors.Wrap(err, "delete attachment")
+		return errors.Wrap(err, "delete watchers")
 	}
-	err = repo.insertAttachments(tx, entity.Attachments)
+	err = repo.upsertWatchers(ctx, watchers)
 	if err != nil {
-		return errors.Wrap(err, "insert attachment")
+		return errors.Wrap(err, "upsert watchers")
 	}
-	return nil
-}
-
-func (repo *SQLRepository) insertMembers(tx *sqlx.Tx, memberList []Member) error {
-	for _, m := range memberList {
-		res, err := tx.Exec(insertMemberQuery,
-			m.ID,
-			m.CardID,
-			m.UserID,
-			m.CreatedAt,
-		)
-		if err != nil {
-			return errors.Wrap(err, "insert member")
+	values, err = repo.liveFieldValues(ctx, values)
+	if err != nil {
+		return errors.Wrap(err, "select live custom fields")
+	}
+	var valueIDs []string
+	for _, v := range values {
+		if v.Error == "" {
+			valueIDs = append(valueIDs, v.ID)
 		}
-		rowsAffected, err := res.RowsAffected()
-		if err != nil {
-			return errors.Wrap(err, "checking rows affected")
+	}
+	err = repo.db.DeleteExcept(ctx, "card_custom_field_value", "card_id IN (?)", cardIDs, valueIDs)
+	if err != nil {
+		return errors.Wrap(err, "delete custom field values")
+	}
+	err = repo.upsertCustomFieldValues(ctx, values)
+	if err != nil {
+		return errors.Wrap(err, "upsert custom field values")
+	}
+	var checklistIDs, itemIDs []string
+	for _, c := range checklists {
+		checklistIDs = append(checklistIDs, c.ID)
+		for _, item := range c.Items {
+			itemIDs = append(itemIDs, item.ID)
 		}
-		if rowsAffected <= 0 {
-			return errors.Wrap(err, "no rows affected")
+	}
+	err = repo.db.DeleteExcept(ctx, "card_checklist_item", "card_id IN (?)", cardIDs, itemIDs)
+	if err != nil {
+		return errors.Wrap(err, "delete checklist items")
+	}
+	err = repo.db.DeleteExcept(ctx, "card_checklist", "card_id IN (?)", cardIDs, checklistIDs)
+	if err != nil {
+		return errors.Wrap(err, "delete checklists")
+	}
+	err = repo.upsertChecklists(ctx, checklists)
+	if err != nil {
+		return errors.Wrap(err, "upsert checklists")
+	}
+	var relationIDs []string
+	for _, r := range relations {
+		relationIDs = append(relationIDs, r.ID)
+	}
+	err = repo.db.DeleteExcept(ctx, "card_relation", "card_id IN (?)", cardIDs, relationIDs)
+	if err != nil {
+		return errors.Wrap(err, "delete relations")
+	}
+	err = repo.upsertRelations(ctx, relations)
+	if err != nil {
+		return errors.Wrap(err, "upsert relations")
+	}
+	return repo.storeLabels(ctx, cardIDs, labels)
+}
+
+// liveFieldValues drops the values of fields deleted since the card was read,
+// storing them would bring the values back. The fields are share locked so
+// none is deleted until the transaction ends.
+func (repo *SQLRepository) liveFieldValues(ctx context.Context, values []CustomFieldValue) ([]CustomFieldValue, error) {
+	if len(values) == 0 {
+		return values, nil
+	}
+	fieldIDs := make([]string, len(values))
+	for i, v := range values {
+		fieldIDs[i] = v.FieldID
+	}
+	query, args, err := sqlx.In("SELECT entity_id FROM custom_field WHERE entity_id IN (?) LOCK IN SHARE MODE", fieldIDs)
+	if err != nil {
+		return nil, err
+	}
+	var liveIDs []string
+	err = repo.db.SelectContext(ctx, &liveIDs, repo.db.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	live := make(map[string]bool, len(liveIDs))
+	for _, id := range liveIDs {
+		live[id] = true
+	}
+	res := make([]CustomFieldValue, 0, len(values))
+	for _, v := range values {
+		if live[v.FieldID] {
+			res = append(res, v)
 		}
 	}
-	return nil
+	return res, nil
 }
 
-func (repo *SQLRepository) deleteMemberByCardID(tx *sqlx.Tx, id string) error {
-	_, err := tx.Exec(deleteMemberQuery+" WHERE card_id = ?", id)
+// storeLabels replaces the labels of the cards with labels.
+func (repo *SQLRepository) storeLabels(ctx context.Context, cardIDs []string, labels []Label) error {
+	var labelIDs []string
+	for _, label := range labels {
+		labelIDs = append(labelIDs, label.ID)
+	}
+	err := repo.db.DeleteExcept(ctx, "card_label", "card_id IN (?)", cardIDs, labelIDs)
+	if err != nil {
+		return errors.Wrap(err, "delete card labels")
+	}
+	err = repo.upsertLabels(ctx, labels)
 	if err != nil {
-		return errors.Wrap(err, "delete member by card id")
+		return errors.Wrap(err, "upsert card labels")
 	}
 	return nil
 }
 
-func (repo *SQLRepository) insertAttachments(tx *sqlx.Tx, attachments []Attachment) error {
+func (repo *SQLRepository) upsertMembers(ctx context.Context, members []Member) error {
+	rows := make([][]interface{}, 0, len(members))
+	for _, m := range members {
+		rows = append(rows, []interface{}{m.ID, m.CardID, m.UserID, m.CreatedAt})
+	}
+	return repo.db.BulkExec(ctx, upsertMemberQuery, rows)
+}
+
+func (repo *SQLRepository) upsertAttachments(ctx context.Context, attachments []Attachment) error {
+	rows := make([][]interface{}, 0, len(attachments))
 	for _, a := range attachments {
-		res, err := tx.Exec(insertAttachmentQuery,
-			a.ID,
-			a.CardID,
-			a.LinkName,
-			a.FileType,
-			a.FileURL,
-			a.CreatedAt,
-			a.UpdatedAt,
-		)
-		if err != nil {
-			return errors.Wrap(err, "insert attachment")
-		}
-		rowsAffected, err := res.RowsAffected()
-		if err != nil {
-			return errors.Wrap(err, "update attachment")
-		}
-		if rowsAffected <= 0 {
-			return errors.New("No affected rows")
+		rows = append(rows, []interface{}{a.ID, a.CardID, a.LinkName, a.FileType, a.FileURL, a.CreatedAt, a.UpdatedAt})
+	}
+	return repo.db.BulkExec(ctx, upsertAttachmentQuery, rows)
+}
+
+func (repo *SQLRepository) upsertLabels(ctx context.Context, labels []Label) error {
+	rows := make([][]interface{}, 0, len(labels))
+	for _, label := range labels {
+		rows = append(rows, []interface{}{label.ID, label.CardID, label.LabelID, label.CreatedAt})
+	}
+	return repo.db.BulkExec(ctx, upsertLabelQuery, rows)
+}
+
+func (repo *SQLRepository) upsertWatchers(ctx context.Context, watchers []Watcher) error {
+	rows := make([][]interface{}, 0, len(watchers))
+	for _, w := range watchers {
+		rows = append(rows, []interface{}{w.ID, w.CardID, w.UserID, w.CreatedAt})
+	}
+	return repo.db.BulkExec(ctx, upsertWatcherQuery, rows)
+}
+
+func (repo *SQLRepository) upsertCustomFieldValues(ctx context.Context, values []CustomFieldValue) error {
+	rows := make([][]interface{}, 0, len(values))
+	for _, v := range values {
+		if v.Error != "" {
+			continue
 		}
+		rows = append(rows, []interface{}{v.ID, v.CardID, v.FieldID, v.Text, v.Number, v.Date, v.Checked, v.OptionIDs, v.CreatedAt, v.UpdatedAt})
 	}
-	return nil
+	return repo.db.BulkExec(ctx, upsertCustomFieldValueQuery, rows)
+}
+
+func (repo *SQLRepository) upsertRelations(ctx context.Context, relations []Relation) error {
+	rows := make([][]interface{}, 0, len(relations))
+	for _, r := range relations {
+		rows = append(rows, []interface{}{r.ID, r.CardID, r.RelatedCardID, r.Type, r.CreatedAt})
+	}
+	return repo.db.BulkExec(ctx, upsertRelationQuery, rows)
 }
 
-func (repo *SQLRepository) deleteAttachmentsByCardID(tx *sqlx.Tx, cardID string) error {
-	_, err := tx.Exec(deleteAttachmentQuery+" WHERE card_id = ?", cardID)
+func (repo *SQLRepository) upsertChecklists(ctx context.Context, checklists []Checklist) error {
+	rows := make([][]interface{}, 0, len(checklists))
+	var itemRows [][]interface{}
+	for _, c := range checklists {
+		rows = append(rows, []interface{}{c.ID, c.CardID, c.Title, c.Position, c.CreatedAt})
+		for _, item := range c.Items {
+			itemRows = append(itemRows, []interface{}{item.ID, item.ChecklistID, item.CardID, item.Title, item.Checked, item.Position, item.CreatedAt, item.UpdatedAt})
+		}
+	}
+	err := repo.db.BulkExec(ctx, upsertChecklistQuery, rows)
 	if err != nil {
-		return errors.WithStack(err)
+		return errors.Wrap(err, "upsert card checklists")
+	}
+	err = repo.db.BulkExec(ctx, upsertChecklistItemQuery, itemRows)
+	if err != nil {
+		return errors.Wrap(err, "upsert card checklist items")
 	}
 	return nil
 }
 
-func (repo *SQLRepository) selectLabelsByCardID(cardID string) ([]Label, error) {
-	var res []Label
-	err := repo.db.Select(&res, selectLabelQuery+" WHERE card_id = ?", cardID)
+// cardKey is a key a card has, or had before it moved to another board.
+type cardKey struct {
+	Key       string    `db:"card_key"`
+	CardID    string    `db:"card_id"`
+	BoardID   string    `db:"board_id"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// allocateKey gives the card the next key of its board when it has none.
+// The board row is locked by the increment until the transaction ends, so
+// concurrent cards get distinct numbers.
+func (repo *SQLRepository) allocateKey(ctx context.Context, entity *Card) error {
+	if entity.Key != "" {
+		return nil
+	}
+	res, err := repo.db.ExecContext(ctx, allocateCardKeyQuery, entity.BoardID)
 	if err != nil {
-		return res, errors.Wrap(err, "select card label with card id")
+		return errors.Wrap(err, "increment board card sequence")
 	}
-	return res, nil
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "checking rows affected")
+	}
+	if rowsAffected <= 0 {
+		return apierror.WithDesc(ErrorCodeEntityNotFound, "board couldn't be found")
+	}
+	// LAST_INSERT_ID(expr) is reported as the insert id of the update
+	number, err := res.LastInsertId()
+	if err != nil {
+		return errors.Wrap(err, "read board card sequence")
+	}
+	var code string
+	err = repo.db.GetContext(ctx, &code, "SELECT code FROM board WHERE entity_id = ?", entity.BoardID)
+	if err != nil {
+		return errors.Wrap(err, "select board code")
+	}
+	entity.Key = fmt.Sprintf("%s-%d", code, number)
+	// the sequence increment is rolled back with the key, a retry allocates
+	// the key again
+	database.OnRollback(ctx, func() {
+		entity.Key = ""
+	})
+	_, err = repo.db.ExecContext(ctx, insertKeyQuery, entity.Key, entity.ID, entity.BoardID, time.Now())
+	if err != nil {
+		return errors.Wrap(err, "insert card key")
+	}
+	return nil
 }
 
-func (repo *SQLRepository) deleteLabelsByCardID(tx *sqlx.Tx, cardID string) error {
-	_, err := tx.Exec(deleteLabelQuery+" WHERE card_id = ?", cardID)
+func (repo *SQLRepository) resolveKeysByCardID(ctx context.Context, cardIDs []string) (res []cardKey, err error) {
+	if len(cardIDs) == 0 {
+		return
+	}
+	query, args, err := repo.db.In(selectKeyQuery+" WHERE card_id IN (:card_id) ORDER BY created_at", map[string]interface{}{
+		"card_id": cardIDs,
+	})
 	if err != nil {
-		return errors.Wrap(err, "delete card labels row by card id")
+		return
 	}
-	return nil
+	query = repo.db.Rebind(query)
+	err = repo.db.SelectContext(ctx, &res, query, args...)
+	if err != nil {
+		err = errors.Wrap(err, "resolve key by card id")
+		return
+	}
+	return res, nil
 }
 
-func (repo *SQLRepository) insertLabels(tx *sqlx.Tx, labels []Label) error {
-	for _, label := range labels {
-		_, err := tx.Exec(
-			insertLabelQuery,
-			label.ID,
-			label.CardID,
-			label.LabelID,
-			label.CreatedAt)
-		if err != nil {
-			return err
+func previousKeys(entity Card, keys []cardKey) []string {
+	var res []string
+	for _, k := range keys {
+		if k.Key != entity.Key {
+			res = append(res, k.Key)
 		}
 	}
-	return nil
+	return res
 }
@@ -0,0 +1,97 @@
+package card
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/database/databasetest"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/domains/board"
+)
+
+// TestStoreStatements stores a card unchanged, with one member removed and
+// with every member replaced. Only a collection that lost rows gets a DELETE,
+// and the number of statements doesn't grow with the number of members.
+func TestStoreStatements(t *testing.T) {
+	db, counter := databasetest.OpenCounting(t)
+	repo := NewSQLRepository(db)
+	boardService := board.NewService(board.NewSQLRepository(db), board.NewLabelSQLRepository(db), board.NewCustomFieldSQLRepository(db), NewBoardCardCopier(db), NewBoardListCards(repo), NewBoardFieldCards(repo, nil), db)
+	svc := NewService(repo, NewTemplateSQLRepository(db), boardService, db)
+	ctx := context.Background()
+	cases := []struct {
+		name    string
+		edit    func(entity *Card)
+		deletes int
+	}{
+		{"unchanged", func(entity *Card) {}, 0},
+		{"one changed", func(entity *Card) { entity.Members = entity.Members[1:] }, 1},
+		{"reinsert", func(entity *Card) {
+			for i := range entity.Members {
+				entity.Members[i].ID = uuid.New().String()
+			}
+		}, 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var totals []int
+			for _, size := range []int{2, 10} {
+				entity := createCardWithMembers(t, boardService, svc, size)
+				c.edit(entity)
+				counter.Reset()
+				err := repo.Store(ctx, entity)
+				if err != nil {
+					t.Fatalf("Store returned error: %v", err)
+				}
+				if deletes := counter.Count("DELETE"); deletes != c.deletes {
+					t.Errorf("Store with %d members sent %d DELETE statements, want %d", size, deletes, c.deletes)
+				}
+				totals = append(totals, counter.Total())
+				got, err := repo.ResolveByID(ctx, entity.ID)
+				if err != nil {
+					t.Fatalf("ResolveByID returned error: %v", err)
+				}
+				if !reflect.DeepEqual(memberUserIDs(got.Members), memberUserIDs(entity.Members)) {
+					t.Errorf("stored card members = %v, want %v", memberUserIDs(got.Members), memberUserIDs(entity.Members))
+				}
+			}
+			if totals[0] != totals[1] {
+				t.Errorf("Store sent %d statements for 2 members and %d for 10, want the same", totals[0], totals[1])
+			}
+		})
+	}
+}
+
+func createCardWithMembers(t *testing.T, boardService *board.Service, svc *Service, size int) *Card {
+	ctx := context.Background()
+	input := board.Input{Title: "Store statements", Lists: []board.ListInput{{Title: "Todo"}}}
+	var members []MemberInput
+	for i := 0; i < size; i++ {
+		userID := fmt.Sprintf("user-%d", i)
+		input.Members = append(input.Members, board.MemberInput{UserID: userID})
+		members = append(members, MemberInput{UserID: userID})
+	}
+	boardEntity, err := boardService.Create(ctx, input)
+	if err != nil {
+		t.Fatalf("Create board returned error: %v", err)
+	}
+	entity, err := svc.Create(ctx, CardInput{
+		ListID:  boardEntity.Lists[0].ID,
+		BoardID: boardEntity.ID,
+		Title:   "Stored card",
+		Members: members,
+	})
+	if err != nil {
+		t.Fatalf("Create card returned error: %v", err)
+	}
+	return entity
+}
+
+func memberUserIDs(members []Member) map[string]bool {
+	res := make(map[string]bool, 0)
+	for _, m := range members {
+		res[m.UserID] = true
+	}
+	return res
+}
@@ -0,0 +1,207 @@
+package card
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/apierror"
+)
+
+// Template is a per-board blueprint for cards. TitlePattern and Description
+// may contain {{placeholders}} that are filled when a card is created from
+// the template; {{date}} defaults to the current date.
+type Template struct {
+	ID           string             `json:"entity_id" db:"entity_id"`
+	BoardID      string             `json:"board_id" db:"board_id"`
+	Name         string             `json:"name" db:"name"`
+	TitlePattern string             `json:"title_pattern" db:"title_pattern"`
+	Description  string             `json:"description" db:"description"`
+	LabelIDs     StringList         `json:"label_ids" db:"label_ids"`
+	MemberIDs    StringList         `json:"member_ids" db:"member_ids"`
+	Checklists   TemplateChecklists `json:"checklists" db:"checklists"`
+	// DueInDays sets the card's due date relative to its creation.
+	DueInDays *int      `json:"due_in_days" db:"due_in_days"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([a-zA-Z0-9_]+)\s*\}\}`)
+
+func (t *Template) Update(input TemplateInput) error {
+	if err := input.Validate(); err != nil {
+		return err
+	}
+	t.Name = input.Name
+	t.TitlePattern = input.TitlePattern
+	t.Description = input.Description
+	t.LabelIDs = input.LabelIDs
+	t.MemberIDs = input.MemberIDs
+	t.Checklists = input.Checklists
+	t.DueInDays = input.DueInDays
+	t.UpdatedAt = time.Now()
+	return nil
+}
+
+// Placeholders returns the placeholder names used by the template, in order
+// of appearance.
+func (t Template) Placeholders() []string {
+	seen := make(map[string]bool, 0)
+	var res []string
+	for _, m := range placeholderPattern.FindAllStringSubmatch(t.TitlePattern+"\n"+t.Description, -1) {
+		if seen[m[1]] {
+			continue
+		}
+		seen[m[1]] = true
+		res = append(res, m[1])
+	}
+	return res
+}
+
+// ToCardInput instantiates the template into a card for the given list.
+func (t Template) ToCardInput(listID string, values map[string]string, now time.Time) (CardInput, error) {
+	filled := map[string]string{"date": now.Format("2006-01-02")}
+	for k, v := range values {
+		filled[k] = v
+	}
+	for _, name := range t.Placeholders() {
+		if _, exist := filled[name]; !exist {
+			return CardInput{}, apierror.WithDesc(ErrorCodeInvalidInput, fmt.Sprintf("missing value for placeholder %q", name))
+		}
+	}
+	fill := func(s string) string {
+		return placeholderPattern.ReplaceAllStringFunc(s, func(m string) string {
+			return filled[placeholderPattern.FindStringSubmatch(m)[1]]
+		})
+	}
+	input := CardInput{
+		ListID:      listID,
+		BoardID:     t.BoardID,
+		Title:       strings.TrimSpace(fill(t.TitlePattern)),
+		Description: fill(t.Description),
+		LabelIDs:    t.LabelIDs,
+	}
+	if input.Title == "" {
+		return CardInput{}, apierror.WithDesc(ErrorCodeInvalidInput, "title is empty after filling the placeholders")
+	}
+	for _, userID := range t.MemberIDs {
+		input.Members = append(input.Members, MemberInput{UserID: userID})
+	}
+	for _, c := range t.Checklists {
+		input.Checklists = append(input.Checklists, ChecklistInput{Title: c.Title, Items: c.Items})
+	}
+	if t.DueInDays != nil {
+		from := now.Format(time.RFC3339)
+		until := now.AddDate(0, 0, *t.DueInDays).Format(time.RFC3339)
+		input.DueDateFrom = &from
+		input.DueDateUntil = &until
+	}
+	return input, nil
+}
+
+type TemplateChecklist struct {
+	Title string   `json:"title" validate:"required"`
+	Items []string `json:"items"`
+}
+
+// TemplateChecklists is stored as a JSON column.
+type TemplateChecklists []TemplateChecklist
+
+func (t TemplateChecklists) Value() (driver.Value, error) {
+	if t == nil {
+		return "[]", nil
+	}
+	return jsonValue(t)
+}
+
+func (t *TemplateChecklists) Scan(src interface{}) error {
+	return jsonScan(src, t)
+}
+
+// StringList is stored as a JSON column.
+type StringList []string
+
+func (t StringList) Value() (driver.Value, error) {
+	if t == nil {
+		return "[]", nil
+	}
+	return jsonValue(t)
+}
+
+func (t *StringList) Scan(src interface{}) error {
+	return jsonScan(src, t)
+}
+
+func jsonValue(v interface{}) (driver.Value, error) {
+	bt, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return string(bt), nil
+}
+
+func jsonScan(src interface{}, dest interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, dest)
+	case string:
+		return json.Unmarshal([]byte(v), dest)
+	}
+	return errors.Errorf("unsupported type %T", src)
+}
+
+type TemplateInput struct {
+	BoardID      string              `json:"board_id" validate:"required"`
+	Name         string              `json:"name" validate:"required,max=100"`
+	TitlePattern string              `json:"title_pattern" validate:"required,max=255"`
+	Description  string              `json:"description"`
+	LabelIDs     []string            `json:"label_ids"`
+	MemberIDs    []string            `json:"member_ids"`
+	Checklists   []TemplateChecklist `json:"checklists" validate:"dive"`
+	DueInDays    *int                `json:"due_in_days" validate:"omitempty,min=0"`
+}
+
+func (input TemplateInput) Validate() error {
+	if err := validator.New().Struct(input); err != nil {
+		return apierror.WithDesc(ErrorCodeInvalidInput, err.Error())
+	}
+	return nil
+}
+
+func (input TemplateInput) ToEntity() (*Template, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	now := time.Now()
+	return &Template{
+		ID:           id.String(),
+		BoardID:      input.BoardID,
+		Name:         input.Name,
+		TitlePattern: input.TitlePattern,
+		Description:  input.Description,
+		LabelIDs:     input.LabelIDs,
+		MemberIDs:    input.MemberIDs,
+		Checklists:   input.Checklists,
+		DueInDays:    input.DueInDays,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}, nil
+}
+
+type TemplateCardInput struct {
+	TemplateID string            `json:"template_id" validate:"required"`
+	ListID     string            `json:"list_id" validate:"required"`
+	Values     map[string]string `json:"values"`
+}
@@ -0,0 +1,151 @@
+package card
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/apierror"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/database"
+)
+
+type TemplateRepository interface {
+	Store(ctx context.Context, entity *Template) error
+	Delete(ctx context.Context, id string) error
+	ResolveByID(ctx context.Context, id string) (*Template, error)
+	ResolveAllByBoardID(ctx context.Context, boardID string) ([]Template, error)
+}
+
+type TemplateSQLRepository struct {
+	db *database.MySQL
+}
+
+func NewTemplateSQLRepository(db *database.MySQL) TemplateRepository {
+	return &TemplateSQLRepository{db: db}
+}
+
+const (
+	insertTemplateQuery = `
+		INSERT INTO card_template (
+			entity_id,
+			board_id,
+			name,
+			title_pattern,
+			description,
+			label_ids,
+			member_ids,
+			checklists,
+			due_in_days,
+			created_at,
+			updated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	updateTemplateQuery = `
+		UPDATE card_template SET
+			name = ?,
+			title_pattern = ?,
+			description = ?,
+			label_ids = ?,
+			member_ids = ?,
+			checklists = ?,
+			due_in_days = ?,
+			updated_at = ?
+		WHERE entity_id = ?
+	`
+	selectTemplateQuery = `
+		SELECT
+			entity_id,
+			board_id,
+			name,
+			title_pattern,
+			description,
+			label_ids,
+			member_ids,
+			checklists,
+			due_in_days,
+			created_at,
+			updated_at
+		FROM card_template
+	`
+	countTemplateQuery = `
+		SELECT COUNT(entity_id) FROM card_template
+	`
+	deleteTemplateQuery = `
+		DELETE FROM card_template
+	`
+)
+
+func (repo *TemplateSQLRepository) Store(ctx context.Context, entity *Template) error {
+	var total int
+	err := repo.db.GetContext(ctx, &total, countTemplateQuery+" WHERE entity_id = ?", entity.ID)
+	if err != nil {
+		return errors.Wrap(err, "count card template by id")
+	}
+	return repo.db.Transaction(ctx, func(ctx context.Context) error {
+		if total > 0 {
+			_, err = repo.db.ExecContext(ctx, updateTemplateQuery,
+				entity.Name,
+				entity.TitlePattern,
+				entity.Description,
+				entity.LabelIDs,
+				entity.MemberIDs,
+				entity.Checklists,
+				entity.DueInDays,
+				entity.UpdatedAt,
+				entity.ID,
+			)
+			if err != nil {
+				return errors.Wrap(err, "update card template")
+			}
+			return nil
+		}
+		_, err = repo.db.ExecContext(ctx, insertTemplateQuery,
+			entity.ID,
+			entity.BoardID,
+			entity.Name,
+			entity.TitlePattern,
+			entity.Description,
+			entity.LabelIDs,
+			entity.MemberIDs,
+			entity.Checklists,
+			entity.DueInDays,
+			entity.CreatedAt,
+			entity.UpdatedAt,
+		)
+		if err != nil {
+			return errors.Wrap(err, "insert card template")
+		}
+		return nil
+	})
+}
+
+func (repo *TemplateSQLRepository) Delete(ctx context.Context, id string) error {
+	return repo.db.Transaction(ctx, func(ctx context.Context) error {
+		_, err := repo.db.ExecContext(ctx, deleteTemplateQuery+" WHERE entity_id = ?", id)
+		if err != nil {
+			return errors.Wrap(err, "delete card template")
+		}
+		return nil
+	})
+}
+
+func (repo *TemplateSQLRepository) ResolveByID(ctx context.Context, id string) (*Template, error) {
+	var res Template
+	err := repo.db.GetContext(ctx, &res, selectTemplateQuery+" WHERE entity_id = ?", id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, apierror.WithDesc(ErrorCodeEntityNotFound, "card template couldn't be found")
+		}
+		return nil, errors.Wrap(err, "select card template by id")
+	}
+	return &res, nil
+}
+
+func (repo *TemplateSQLRepository) ResolveAllByBoardID(ctx context.Context, boardID string) ([]Template, error) {
+	var res []Template
+	err := repo.db.SelectContext(ctx, &res, selectTemplateQuery+" WHERE board_id = ? ORDER BY name", boardID)
+	if err != nil {
+		return nil, errors.Wrap(err, "select card template by board id")
+	}
+	return res, nil
+}
@@ -0,0 +1,181 @@
+package card
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rakateja/milo/twirp-rpc-examples/card/domains/board"
+)
+
+// GroupBy is the dimension the cards of a board view are grouped by into
+// swimlanes.
+type GroupBy string
+
+const (
+	GroupByNone        GroupBy = ""
+	GroupByMember      GroupBy = "member"
+	GroupByLabel       GroupBy = "label"
+	GroupByCustomField GroupBy = "custom_field"
+)
+
+type ViewInput struct {
+	BoardID       string  `json:"board_id" validate:"required"`
+	GroupBy       GroupBy `json:"group_by" validate:"omitempty,oneof=member label custom_field"`
+	CustomFieldID string  `json:"custom_field_id" validate:"required_if=GroupBy custom_field"`
+}
+
+// View is a board's cards laid out in swimlanes, each one split into the
+// board's lists. A card with several members, labels or options shows up in
+// each of their swimlanes; cards without any end up in the last swimlane,
+// which has an empty key.
+type View struct {
+	Board     board.Board `json:"board"`
+	GroupBy   GroupBy     `json:"group_by"`
+	Swimlanes []Swimlane  `json:"swimlanes"`
+}
+
+type Swimlane struct {
+	Key       string         `json:"key"`
+	Title     string         `json:"title"`
+	CardCount int            `json:"card_count"`
+	Cells     []SwimlaneCell `json:"cells"`
+}
+
+type SwimlaneCell struct {
+	ListID string `json:"list_id"`
+	Cards  []Card `json:"cards"`
+}
+
+type swimlaneKey struct {
+	Key   string
+	Title string
+}
+
+// NewView groups the cards in a single pass. Lanes follow the order of the
+// board's labels or the field's options, the others are sorted by title.
+// Archived lists and deleted cards are left out, and the lists' card counts
+// are those of the view.
+func NewView(boardEntity board.Board, cards []Card, groupBy GroupBy, field board.CustomField) View {
+	lists := make([]board.BoardList, 0)
+	listIndex := make(map[string]int, 0)
+	for _, l := range boardEntity.Lists {
+		if l.IsArchived() {
+			continue
+		}
+		l.CardCount = 0
+		listIndex[l.ID] = len(lists)
+		lists = append(lists, l)
+	}
+	boardEntity.Lists = lists
+
+	lanes := make([]Swimlane, 0)
+	laneIndex := make(map[string]int, 0)
+	newLane := func(key, title string) {
+		cells := make([]SwimlaneCell, len(lists))
+		for i, l := range lists {
+			cells[i] = SwimlaneCell{ListID: l.ID, Cards: make([]Card, 0)}
+		}
+		laneIndex[key] = len(lanes)
+		lanes = append(lanes, Swimlane{Key: key, Title: title, Cells: cells})
+	}
+	// lanes known upfront keep the board's order
+	switch groupBy {
+	case GroupByLabel:
+		for _, l := range boardEntity.Labels {
+			newLane(l.ID, l.Title)
+		}
+	case GroupByCustomField:
+		for _, o := range field.Options {
+			newLane(o.ID, o.Title)
+		}
+	case GroupByNone:
+		newLane("", "")
+	}
+	ordered := len(lanes)
+
+	for _, c := range cards {
+		idx, exist := listIndex[c.ListID]
+		if !exist || c.DeletedAt != nil {
+			continue
+		}
+		lists[idx].CardCount++
+		keys := cardSwimlaneKeys(c, groupBy, field)
+		if len(keys) == 0 {
+			keys = []swimlaneKey{{}}
+		}
+		for _, k := range keys {
+			if _, exist := laneIndex[k.Key]; !exist {
+				newLane(k.Key, k.Title)
+			}
+			lane := &lanes[laneIndex[k.Key]]
+			lane.CardCount++
+			lane.Cells[idx].Cards = append(lane.Cells[idx].Cards, c)
+		}
+	}
+
+	res := make([]Swimlane, 0, len(lanes))
+	var empty *Swimlane
+	for i := range lanes {
+		if lanes[i].Key == "" && groupBy != GroupByNone {
+			empty = &lanes[i]
+			continue
+		}
+		res = append(res, lanes[i])
+	}
+	rest := res[ordered:]
+	sort.SliceStable(rest, func(i, j int) bool {
+		return rest[i].Title < rest[j].Title
+	})
+	if empty != nil {
+		res = append(res, *empty)
+	}
+	return View{Board: boardEntity, GroupBy: groupBy, Swimlanes: res}
+}
+
+func cardSwimlaneKeys(c Card, groupBy GroupBy, field board.CustomField) []swimlaneKey {
+	var res []swimlaneKey
+	switch groupBy {
+	case GroupByNone:
+		res = append(res, swimlaneKey{})
+	case GroupByMember:
+		for _, m := range c.Members {
+			res = append(res, swimlaneKey{Key: m.UserID, Title: m.UserID})
+		}
+	case GroupByLabel:
+		for _, l := range c.Labels {
+			// the lane title comes from the board's labels
+			res = append(res, swimlaneKey{Key: l.LabelID})
+		}
+	case GroupByCustomField:
+		for _, v := range c.CustomFields {
+			if v.FieldID != field.ID {
+				continue
+			}
+			if field.Type.HasOptions() {
+				for _, optionID := range v.OptionIDs {
+					res = append(res, swimlaneKey{Key: optionID})
+				}
+				continue
+			}
+			if value := fieldValueString(v.FieldValue); value != "" {
+				res = append(res, swimlaneKey{Key: value, Title: value})
+			}
+		}
+	}
+	return res
+}
+
+func fieldValueString(v board.FieldValue) string {
+	switch {
+	case v.Text != nil:
+		return *v.Text
+	case v.Number != nil:
+		return strconv.FormatFloat(*v.Number, 'f', -1, 64)
+	case v.Date != nil:
+		return v.Date.Format(time.RFC3339)
+	case v.Checked != nil:
+		return strconv.FormatBool(*v.Checked)
+	}
+	return ""
+}
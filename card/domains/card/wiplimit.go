@@ -0,0 +1,54 @@
+package card
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/apierror"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/domains/board"
+	"github.com/twitchtv/twirp"
+)
+
+// WipLimitWarningHeader carries the warning of a list in warn mode that went
+// over its WIP limit.
+const WipLimitWarningHeader = "Wip-Limit-Warning"
+
+// checkWipLimit fails when incoming more cards take the list over its WIP
+// limit. Lists in warn mode let the cards through and only set a warning
+// header on the response. A strict limit is checked against a count taken
+// with the list locked, in the transaction of ctx that stores the cards, so
+// concurrent moves can't both fit in the last free place.
+func checkWipLimit(ctx context.Context, repo Repository, list board.BoardList, incoming int) error {
+	if list.WipLimit == nil || incoming <= 0 {
+		return nil
+	}
+	if list.WipLimitMode != board.WipLimitModeWarn {
+		total, err := repo.CountListCardsForUpdate(ctx, list.ID)
+		if err != nil {
+			return errors.Wrap(err, "count list cards for update")
+		}
+		list.CardCount = total
+	}
+	if !list.ExceedsWipLimit(incoming) {
+		return nil
+	}
+	desc := fmt.Sprintf("list %q is limited to %d cards and has %d", list.Title, *list.WipLimit, list.CardCount)
+	if list.WipLimitMode == board.WipLimitModeWarn {
+		// not an rpc call when it fails, there is nobody to warn
+		_ = twirp.SetHTTPResponseHeader(ctx, WipLimitWarningHeader, desc)
+		return nil
+	}
+	return apierror.WithDesc(ErrorCodeWipLimitExceeded, desc)
+}
+
+// incomingCards counts the cards that aren't in the list yet.
+func incomingCards(cards []Card, listID string) int {
+	var total int
+	for _, c := range cards {
+		if c.ListID != listID && c.DeletedAt == nil {
+			total++
+		}
+	}
+	return total
+}
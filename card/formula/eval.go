@@ -0,0 +1,386 @@
+package formula
+
+import (
+	"math"
+	"strings"
+	"time"
+)
+
+type node interface {
+	eval(env Env) (interface{}, error)
+	refs(seen map[string]bool)
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n *literalNode) eval(env Env) (interface{}, error) {
+	return n.value, nil
+}
+
+func (n *literalNode) refs(seen map[string]bool) {}
+
+type refNode struct {
+	key string
+	pos int
+}
+
+func (n *refNode) eval(env Env) (interface{}, error) {
+	value, exist := env(n.key)
+	if !exist {
+		return nil, newError(n.pos, "unknown field %q", n.key)
+	}
+	return value, nil
+}
+
+func (n *refNode) refs(seen map[string]bool) {
+	seen[n.key] = true
+}
+
+type unaryNode struct {
+	op      string
+	operand node
+	pos     int
+}
+
+func (n *unaryNode) eval(env Env) (interface{}, error) {
+	value, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	f, err := toNumber(value, n.pos)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "-" {
+		return -f, nil
+	}
+	return f, nil
+}
+
+func (n *unaryNode) refs(seen map[string]bool) {
+	n.operand.refs(seen)
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+	pos         int
+}
+
+func (n *binaryNode) eval(env Env) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "&":
+		return toText(left) + toText(right), nil
+	case "=", "==", "<>", "!=", "<", "<=", ">", ">=":
+		return compare(n.op, left, right, n.pos)
+	}
+	l, err := toNumber(left, n.pos)
+	if err != nil {
+		return nil, err
+	}
+	r, err := toNumber(right, n.pos)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, newError(n.pos, "division by zero")
+		}
+		return l / r, nil
+	case "%":
+		if r == 0 {
+			return nil, newError(n.pos, "division by zero")
+		}
+		return math.Mod(l, r), nil
+	case "^":
+		return math.Pow(l, r), nil
+	}
+	return nil, newError(n.pos, "unknown operator %q", n.op)
+}
+
+func (n *binaryNode) refs(seen map[string]bool) {
+	n.left.refs(seen)
+	n.right.refs(seen)
+}
+
+type callNode struct {
+	name string
+	fn   function
+	args []node
+	pos  int
+}
+
+func (n *callNode) eval(env Env) (interface{}, error) {
+	return n.fn.call(env, n.args, n.pos)
+}
+
+func (n *callNode) refs(seen map[string]bool) {
+	for _, arg := range n.args {
+		arg.refs(seen)
+	}
+}
+
+// function receives its arguments unevaluated so IF, AND and OR only
+// evaluate the branches they need.
+type function struct {
+	minArgs int
+	maxArgs int
+	call    func(env Env, args []node, pos int) (interface{}, error)
+}
+
+var functions map[string]function
+
+func init() {
+	functions = map[string]function{
+		"IF": {2, 3, func(env Env, args []node, pos int) (interface{}, error) {
+			cond, err := args[0].eval(env)
+			if err != nil {
+				return nil, err
+			}
+			if toBool(cond) {
+				return args[1].eval(env)
+			}
+			if len(args) == 3 {
+				return args[2].eval(env)
+			}
+			return false, nil
+		}},
+		"AND": {1, -1, func(env Env, args []node, pos int) (interface{}, error) {
+			for _, arg := range args {
+				value, err := arg.eval(env)
+				if err != nil {
+					return nil, err
+				}
+				if !toBool(value) {
+					return false, nil
+				}
+			}
+			return true, nil
+		}},
+		"OR": {1, -1, func(env Env, args []node, pos int) (interface{}, error) {
+			for _, arg := range args {
+				value, err := arg.eval(env)
+				if err != nil {
+					return nil, err
+				}
+				if toBool(value) {
+					return true, nil
+				}
+			}
+			return false, nil
+		}},
+		"NOT": {1, 1, func(env Env, args []node, pos int) (interface{}, error) {
+			value, err := args[0].eval(env)
+			if err != nil {
+				return nil, err
+			}
+			return !toBool(value), nil
+		}},
+		"SUM": {1, -1, numbers(func(values []float64) float64 {
+			var total float64
+			for _, v := range values {
+				total += v
+			}
+			return total
+		})},
+		"MIN": {1, -1, numbers(func(values []float64) float64 {
+			res := values[0]
+			for _, v := range values[1:] {
+				res = math.Min(res, v)
+			}
+			return res
+		})},
+		"MAX": {1, -1, numbers(func(values []float64) float64 {
+			res := values[0]
+			for _, v := range values[1:] {
+				res = math.Max(res, v)
+			}
+			return res
+		})},
+		"ABS": {1, 1, numbers(func(values []float64) float64 {
+			return math.Abs(values[0])
+		})},
+		"ROUND": {1, 2, numbers(func(values []float64) float64 {
+			digits := 0.0
+			if len(values) == 2 {
+				digits = values[1]
+			}
+			pow := math.Pow(10, digits)
+			return math.Round(values[0]*pow) / pow
+		})},
+		"CONCAT": {1, -1, func(env Env, args []node, pos int) (interface{}, error) {
+			var sb strings.Builder
+			for _, arg := range args {
+				value, err := arg.eval(env)
+				if err != nil {
+					return nil, err
+				}
+				sb.WriteString(toText(value))
+			}
+			return sb.String(), nil
+		}},
+		"LEN": {1, 1, func(env Env, args []node, pos int) (interface{}, error) {
+			value, err := args[0].eval(env)
+			if err != nil {
+				return nil, err
+			}
+			return float64(len([]rune(toText(value)))), nil
+		}},
+		"DAYS": {2, 2, func(env Env, args []node, pos int) (interface{}, error) {
+			end, err := args[0].eval(env)
+			if err != nil {
+				return nil, err
+			}
+			start, err := args[1].eval(env)
+			if err != nil {
+				return nil, err
+			}
+			endDate, ok := end.(time.Time)
+			startDate, ok2 := start.(time.Time)
+			if !ok || !ok2 {
+				if end == nil || start == nil {
+					return nil, nil
+				}
+				return nil, newError(pos, "DAYS expects two dates")
+			}
+			return math.Floor(endDate.Sub(startDate).Hours() / 24), nil
+		}},
+	}
+}
+
+// numbers evaluates every argument as a number before calling fn.
+func numbers(fn func(values []float64) float64) func(env Env, args []node, pos int) (interface{}, error) {
+	return func(env Env, args []node, pos int) (interface{}, error) {
+		values := make([]float64, 0, len(args))
+		for _, arg := range args {
+			value, err := arg.eval(env)
+			if err != nil {
+				return nil, err
+			}
+			f, err := toNumber(value, pos)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, f)
+		}
+		return fn(values), nil
+	}
+}
+
+// toNumber follows spreadsheet semantics: empty fields count as zero and
+// booleans as 0 or 1.
+func toNumber(value interface{}, pos int) (float64, error) {
+	switch v := value.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return v, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, newError(pos, "expected a number but got %q", toText(value))
+}
+
+func toBool(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	}
+	return true
+}
+
+func toText(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return formatNumber(v)
+	case bool:
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+	return ""
+}
+
+func compare(op string, left, right interface{}, pos int) (interface{}, error) {
+	var cmp int
+	switch l := left.(type) {
+	case time.Time:
+		r, ok := right.(time.Time)
+		if !ok {
+			return nil, newError(pos, "can't compare a date with %q", toText(right))
+		}
+		switch {
+		case l.Before(r):
+			cmp = -1
+		case l.After(r):
+			cmp = 1
+		}
+	case string:
+		cmp = strings.Compare(l, toText(right))
+	default:
+		if s, ok := right.(string); ok {
+			cmp = strings.Compare(toText(left), s)
+			break
+		}
+		lf, err := toNumber(left, pos)
+		if err != nil {
+			return nil, err
+		}
+		rf, err := toNumber(right, pos)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case lf < rf:
+			cmp = -1
+		case lf > rf:
+			cmp = 1
+		}
+	}
+	switch op {
+	case "=", "==":
+		return cmp == 0, nil
+	case "<>", "!=":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	}
+	return cmp >= 0, nil
+}
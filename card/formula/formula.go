@@ -0,0 +1,126 @@
+// Package formula implements the spreadsheet-like expressions used by
+// formula custom fields, e.g. `=points * 2` or `IF(done, 0, estimate)`.
+//
+// Identifiers reference other fields of the same card by key, which is the
+// field name lower cased with everything but letters and digits replaced by
+// underscores, e.g. `cost` for "Cost ($)".
+package formula
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos)
+}
+
+func newError(pos int, format string, args ...interface{}) *Error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Key normalizes a field name into the identifier formulas use to reference
+// it. Every run of characters other than a-z, 0-9 and _ becomes a single
+// underscore, leading and trailing ones are dropped, and a key starting with
+// a digit gets an underscore prepended so it doesn't lex as a number.
+func Key(name string) string {
+	var sb strings.Builder
+	separate := false
+	for _, r := range strings.ToLower(name) {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '_' {
+			separate = true
+			continue
+		}
+		if separate && sb.Len() > 0 {
+			sb.WriteByte('_')
+		}
+		separate = false
+		sb.WriteRune(r)
+	}
+	key := sb.String()
+	if key != "" && key[0] >= '0' && key[0] <= '9' {
+		key = "_" + key
+	}
+	return key
+}
+
+// ValidKey tells whether formulas can reference a field by the key, which
+// isn't the case for an empty key or one that reads as a boolean literal.
+func ValidKey(key string) bool {
+	return key != "" && key != "true" && key != "false"
+}
+
+type Expr struct {
+	src  string
+	root node
+}
+
+// Parse compiles a formula. A leading "=" is optional.
+func Parse(src string) (*Expr, error) {
+	body := strings.TrimSpace(src)
+	offset := len(src) - len(strings.TrimLeft(src, " \t\n"))
+	if strings.HasPrefix(body, "=") {
+		body = body[1:]
+		offset++
+	}
+	if strings.TrimSpace(body) == "" {
+		return nil, newError(0, "formula is empty")
+	}
+	tokens, err := tokenize(body)
+	if err != nil {
+		return nil, shift(err, offset)
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseExpr()
+	if err != nil {
+		return nil, shift(err, offset)
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, shift(newError(tok.pos, "unexpected %q", tok.text), offset)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+func shift(err error, offset int) error {
+	if e, ok := err.(*Error); ok {
+		return &Error{Pos: e.Pos + offset, Msg: e.Msg}
+	}
+	return err
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// References returns the keys of the fields the formula reads, sorted.
+func (e *Expr) References() []string {
+	seen := make(map[string]bool, 0)
+	e.root.refs(seen)
+	res := make([]string, 0, len(seen))
+	for k := range seen {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Env resolves a field key to its value on the card being evaluated. Values
+// are float64, string, bool, time.Time or nil when the field is empty.
+type Env func(key string) (interface{}, bool)
+
+// Eval evaluates the formula. It returns float64, string, bool, time.Time or
+// nil.
+func (e *Expr) Eval(env Env) (interface{}, error) {
+	return e.root.eval(env)
+}
+
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,230 @@
+package formula
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func testEnv(values map[string]interface{}) Env {
+	return func(key string) (interface{}, bool) {
+		v, exist := values[key]
+		return v, exist
+	}
+}
+
+func TestEval(t *testing.T) {
+	due := time.Date(2023, 5, 10, 0, 0, 0, 0, time.UTC)
+	start := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	env := testEnv(map[string]interface{}{
+		"points":   3.0,
+		"estimate": 5.0,
+		"done":     true,
+		"title":    "Fix login",
+		"empty":    nil,
+		"due":      due,
+		"start":    start,
+	})
+	tests := []struct {
+		name string
+		src  string
+		want interface{}
+	}{
+		{"leading equals", "=1 + 2", 3.0},
+		{"multiplication before addition", "1 + 2 * 3", 7.0},
+		{"parentheses", "(1 + 2) * 3", 9.0},
+		{"left associative subtraction", "10 - 4 - 3", 3.0},
+		{"left associative division", "12 / 3 / 2", 2.0},
+		{"modulo", "7 % 4", 3.0},
+		{"power before multiplication", "2 * 3 ^ 2", 18.0},
+		{"right associative power", "2 ^ 3 ^ 2", 512.0},
+		{"unary minus", "-points + 1", -2.0},
+		{"unary minus of power", "-2 ^ 2", -4.0},
+		{"negative exponent", "2 ^ -1", 0.5},
+		{"addition before comparison", "1 + 1 = 2", true},
+		{"concatenation after addition", `1 + 2 & "x"`, "3x"},
+		{"comparison after concatenation", `"a" & "b" = "ab"`, true},
+		{"not equal", "points <> estimate", true},
+		{"less or equal", "points <= 3", true},
+		{"field reference", "points * 2", 6.0},
+		{"case insensitive reference", "POINTS * 2", 6.0},
+		{"empty field counts as zero", "empty + 1", 1.0},
+		{"boolean counts as one", "done + 1", 2.0},
+		{"boolean literal", "TRUE", true},
+		{"IF true branch", "IF(done, 0, estimate)", 0.0},
+		{"IF false branch", "IF(NOT(done), 0, estimate)", 5.0},
+		{"IF without else", "IF(empty, 1)", false},
+		{"IF skips the failing branch", "IF(done, 1, 1 / 0)", 1.0},
+		{"AND", "AND(done, points > 2)", true},
+		{"OR", "OR(empty, points > 5)", false},
+		{"SUM", "SUM(points, estimate, 2)", 10.0},
+		{"MIN", "MIN(points, estimate)", 3.0},
+		{"MAX", "MAX(points, estimate)", 5.0},
+		{"ABS", "ABS(points - estimate)", 2.0},
+		{"ROUND", "ROUND(2 / 3, 2)", 0.67},
+		{"CONCAT", `CONCAT(title, " (", points, ")")`, "Fix login (3)"},
+		{"LEN", "LEN(title)", 9.0},
+		{"DAYS", "DAYS(due, start)", 9.0},
+		{"DAYS of an empty date", "DAYS(empty, start)", nil},
+		{"date comparison", "due > start", true},
+		{"escaped quote", `"say ""hi"""`, `say "hi"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := Parse(tt.src)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned error: %v", tt.src, err)
+			}
+			got, err := expr.Eval(env)
+			if err != nil {
+				t.Fatalf("Eval(%q) returned error: %v", tt.src, err)
+			}
+			if got != tt.want {
+				t.Errorf("Eval(%q) = %#v, want %#v", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	env := testEnv(map[string]interface{}{
+		"points": 3.0,
+		"zero":   0.0,
+		"title":  "Fix login",
+		"due":    time.Date(2023, 5, 10, 0, 0, 0, 0, time.UTC),
+	})
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"division by zero", "points / 0", "division by zero"},
+		{"division by zero field", "points / zero", "division by zero"},
+		{"modulo by zero", "points % zero", "division by zero"},
+		{"unknown field", "points + estimate", `unknown field "estimate"`},
+		{"unknown field in a function", "SUM(points, missing)", `unknown field "missing"`},
+		{"text in arithmetic", "title * 2", `expected a number but got "Fix login"`},
+		{"text negated", "-title", "expected a number"},
+		{"text in a number function", "ABS(title)", "expected a number"},
+		{"date compared with a number", "due > 1", "can't compare a date"},
+		{"DAYS of a number", "DAYS(points, due)", "DAYS expects two dates"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := Parse(tt.src)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned error: %v", tt.src, err)
+			}
+			_, err = expr.Eval(env)
+			if err == nil {
+				t.Fatalf("Eval(%q) returned no error, want %q", tt.src, tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Eval(%q) error = %q, want it to contain %q", tt.src, err.Error(), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+		pos  int
+	}{
+		{"empty", "", "formula is empty", 0},
+		{"only equals", " = ", "formula is empty", 0},
+		{"dangling operator", "1 +", "unexpected end of formula", 3},
+		{"operator after equals", "=1 + * 2", "unexpected \"*\"", 5},
+		{"unclosed parenthesis", "(1 + 2", `expected ")"`, 6},
+		{"extra closing parenthesis", "1 + 2)", `unexpected ")"`, 5},
+		{"unterminated string", `"abc`, "unterminated string", 0},
+		{"unexpected character", "points # 2", "unexpected character '#'", 7},
+		{"invalid number", "1.2.3", `invalid number "1.2.3"`, 0},
+		{"unknown function", "FOO(1)", "unknown function FOO", 0},
+		{"too few arguments", "IF(1)", "wrong number of arguments for IF", 0},
+		{"too many arguments", "NOT(1, 2)", "wrong number of arguments for NOT", 0},
+		{"missing comma", "SUM(1 2)", `expected ")"`, 6},
+		{"position after leading equals", "=1 +", "unexpected end of formula", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.src)
+			if err == nil {
+				t.Fatalf("Parse(%q) returned no error, want %q", tt.src, tt.want)
+			}
+			e, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("Parse(%q) error is %T, want *Error", tt.src, err)
+			}
+			if !strings.Contains(e.Msg, tt.want) {
+				t.Errorf("Parse(%q) error = %q, want it to contain %q", tt.src, e.Msg, tt.want)
+			}
+			if e.Pos != tt.pos {
+				t.Errorf("Parse(%q) error position = %d, want %d", tt.src, e.Pos, tt.pos)
+			}
+		})
+	}
+}
+
+func TestReferences(t *testing.T) {
+	expr, err := Parse("IF(done, points * 2, estimate) + points")
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	got := strings.Join(expr.References(), ",")
+	if want := "done,estimate,points"; got != want {
+		t.Errorf("References() = %q, want %q", got, want)
+	}
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Points", "points"},
+		{"Story points", "story_points"},
+		{"  Story   points  ", "story_points"},
+		{"Cost ($)", "cost"},
+		{"a-b", "a_b"},
+		{"due_date", "due_date"},
+		{"Café", "caf"},
+		{"2nd estimate", "_2nd_estimate"},
+		{"$$$", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Key(tt.name); got != tt.want {
+				t.Errorf("Key(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyIsReferenceable(t *testing.T) {
+	names := []string{"Cost ($)", "a-b", "2nd estimate", "Story points"}
+	for _, name := range names {
+		key := Key(name)
+		if !ValidKey(key) {
+			t.Fatalf("ValidKey(%q) = false", key)
+		}
+		expr, err := Parse(key + " + 1")
+		if err != nil {
+			t.Fatalf("Parse of the key of %q returned error: %v", name, err)
+		}
+		got, err := expr.Eval(testEnv(map[string]interface{}{key: 1.0}))
+		if err != nil {
+			t.Fatalf("Eval of the key of %q returned error: %v", name, err)
+		}
+		if got != 2.0 {
+			t.Errorf("Eval of the key of %q = %#v, want 2", name, got)
+		}
+	}
+	for _, key := range []string{"", "true", "false"} {
+		if ValidKey(key) {
+			t.Errorf("ValidKey(%q) = true", key)
+		}
+	}
+}
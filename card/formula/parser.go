@@ -0,0 +1,206 @@
+package formula
+
+import (
+	"strconv"
+	"strings"
+)
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) acceptOperator(ops ...string) (token, bool) {
+	tok := p.peek()
+	if tok.kind != tokenOperator {
+		return tok, false
+	}
+	for _, op := range ops {
+		if tok.text == op {
+			p.next()
+			return tok, true
+		}
+	}
+	return tok, false
+}
+
+func (p *parser) parseExpr() (node, error) {
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseConcat()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.acceptOperator("=", "==", "<>", "!=", "<", "<=", ">", ">=")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: tok.text, left: left, right: right, pos: tok.pos}
+	}
+}
+
+func (p *parser) parseConcat() (node, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.acceptOperator("&")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: tok.text, left: left, right: right, pos: tok.pos}
+	}
+}
+
+func (p *parser) parseAdditive() (node, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.acceptOperator("+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: tok.text, left: left, right: right, pos: tok.pos}
+	}
+}
+
+func (p *parser) parseTerm() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.acceptOperator("*", "/", "%")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: tok.text, left: left, right: right, pos: tok.pos}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if tok, ok := p.acceptOperator("-", "+"); ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: tok.text, operand: operand, pos: tok.pos}, nil
+	}
+	return p.parsePower()
+}
+
+func (p *parser) parsePower() (node, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if tok, ok := p.acceptOperator("^"); ok {
+		exponent, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &binaryNode{op: tok.text, left: base, right: exponent, pos: tok.pos}, nil
+	}
+	return base, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenNumber:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, newError(tok.pos, "invalid number %q", tok.text)
+		}
+		return &literalNode{value: f}, nil
+	case tokenString:
+		return &literalNode{value: tok.text}, nil
+	case tokenIdent:
+		name := strings.ToLower(tok.text)
+		if p.peek().kind == tokenLParen {
+			return p.parseCall(tok)
+		}
+		switch name {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		}
+		return &refNode{key: name, pos: tok.pos}, nil
+	case tokenLParen:
+		inner, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, newError(closing.pos, "expected \")\"")
+		}
+		return inner, nil
+	case tokenEOF:
+		return nil, newError(tok.pos, "unexpected end of formula")
+	}
+	return nil, newError(tok.pos, "unexpected %q", tok.text)
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	fn, exist := functions[strings.ToUpper(name.text)]
+	if !exist {
+		return nil, newError(name.pos, "unknown function %s", strings.ToUpper(name.text))
+	}
+	p.next()
+	var args []node
+	if p.peek().kind != tokenRParen {
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.peek().kind != tokenComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if closing := p.next(); closing.kind != tokenRParen {
+		return nil, newError(closing.pos, "expected \")\"")
+	}
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, newError(name.pos, "wrong number of arguments for %s", strings.ToUpper(name.text))
+	}
+	return &callNode{name: strings.ToUpper(name.text), fn: fn, args: args, pos: name.pos}, nil
+}
@@ -4,44 +4,40 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/rakateja/milo/twirp-rpc-examples/card/cache"
 	"github.com/rakateja/milo/twirp-rpc-examples/card/config"
 	"github.com/rakateja/milo/twirp-rpc-examples/card/database"
 	"github.com/rakateja/milo/twirp-rpc-examples/card/domains/board"
 	"github.com/rakateja/milo/twirp-rpc-examples/card/domains/card"
 	pb "github.com/rakateja/milo/twirp-rpc-examples/card/proto/rpcproto"
 	"github.com/rakateja/milo/twirp-rpc-examples/card/servers"
-	redis "github.com/redis/go-redis/v9"
 )
 
 func main() {
 	conf := config.NewConfig()
 	db, err := database.NewMySQL(conf)
 	ck(err)
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     conf.RedisHost,
-		Password: conf.RedisPassword,
-		DB:       0,
-	})
-	/*rdb1 := redis.NewClusterClient(&redis.ClusterOptions{
-		Addrs:       []string{":6373", ":6374", ":6375"},
-		PoolTimeout: time.Second * 30,
-
-		// To route commands by latency or randomly, enable one of the following.
-		//RouteByLatency: true,
-		//RouteRandomly: true,
-	})
-	err = rdb1.ForEachShard(context.Background(), func(ctx context.Context, shard *redis.Client) error {
-		return shard.Ping(ctx).Err()
-	})
-	if err != nil {
-		panic(err)
-	}*/
+	rdb, err := database.NewRedis(conf)
+	ck(err)
+	cacheOptions := cache.NewOptions(conf)
+	boardCache := cache.New("board", rdb, cacheOptions)
+	cardCache := cache.New("card", rdb, cacheOptions)
+	// the caches fall back to MySQL, so Redis being down only degrades them
+	if err := database.PingRedis(rdb); err != nil {
+		boardCache.Trip(err)
+		cardCache.Trip(err)
+	}
 	labelSQLRepo := board.NewLabelSQLRepository(db)
+	labelCachedRepo := board.NewCachedLabelRepository(labelSQLRepo, boardCache)
+	customFieldSQLRepo := board.NewCustomFieldSQLRepository(db)
+	customFieldCachedRepo := board.NewCachedCustomFieldRepository(customFieldSQLRepo, boardCache)
 	boardSQLRepo := board.NewSQLRepository(db)
-	boardService := board.NewService(boardSQLRepo, labelSQLRepo)
+	boardCachedRepo := board.NewCachedRepository(boardSQLRepo, boardCache)
 	cardSQLRepo := card.NewSQLRepository(db)
-	cardCachedRepo := card.NewCachedRepository(cardSQLRepo, rdb)
-	cardService := card.NewService(cardCachedRepo, boardService)
+	cardCachedRepo := card.NewCachedRepository(cardSQLRepo, cardCache)
+	boardService := board.NewService(boardCachedRepo, labelCachedRepo, customFieldCachedRepo, card.NewBoardCardCopier(db), card.NewBoardListCards(cardCachedRepo), card.NewBoardFieldCards(cardCachedRepo, cardCache), db)
+	cardTemplateSQLRepo := card.NewTemplateSQLRepository(db)
+	cardService := card.NewService(cardCachedRepo, cardTemplateSQLRepo, boardService, db)
 	boardTwirpServer := servers.NewBoardServer(boardService)
 	boardTwirpHandler := pb.NewBoardServiceServer(boardTwirpServer)
 	cardTwirpServer := card.NewRPCServer(cardService)
@@ -49,6 +45,8 @@ func main() {
 	mux := http.NewServeMux()
 	mux.Handle(boardTwirpHandler.PathPrefix(), boardTwirpHandler)
 	mux.Handle(cardTwirpHandler.PathPrefix(), cardTwirpHandler)
+	mux.Handle("/debug/cache", cache.NewStatsHandler(boardCache, cardCache))
+	mux.Handle("/health", cache.NewHealthHandler(boardCache, cardCache))
 	mux.Handle("/swaggerui/", http.StripPrefix("/swaggerui/", http.FileServer(http.Dir("./swaggerui"))))
 
 	log.Printf("listening to port :9001\n")
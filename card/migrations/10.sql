@@ -0,0 +1,2 @@
+ALTER TABLE `board_list` ADD COLUMN wip_limit INT NULL DEFAULT NULL AFTER position;
+ALTER TABLE `board_list` ADD COLUMN wip_limit_mode VARCHAR(10) NOT NULL DEFAULT 'strict' AFTER wip_limit;
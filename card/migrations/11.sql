@@ -0,0 +1,110 @@
+-- a card whose board row is gone can't get a board-prefixed key, so the
+-- migration stops before changing anything while there are such cards: the
+-- insert fails with "Column 'cards_without_board' cannot be null". Delete
+-- them or move them to a board, then run it again.
+CREATE TEMPORARY TABLE `card_orphan_check`(
+    cards_without_board INT NOT NULL
+) ENGINE=InnoDB;
+
+INSERT INTO `card_orphan_check` (cards_without_board)
+SELECT IF(COUNT(*) = 0, 0, NULL)
+FROM `card` c
+LEFT JOIN `board` b ON b.entity_id = c.board_id
+WHERE b.entity_id IS NULL;
+
+DROP TEMPORARY TABLE `card_orphan_check`;
+
+ALTER TABLE `board` ADD COLUMN card_seq INT NOT NULL DEFAULT 0 AFTER code;
+
+-- boards sharing a code, e.g. the hard-coded FOOBAR, keep it on the oldest one
+-- and the others get it suffixed with their rank: FOOBAR2, FOOBAR3, ...
+-- Ranks are counted with self joins rather than ROW_NUMBER() to run on 5.7.
+CREATE TABLE `board_code_rename`(
+    entity_id CHAR(36) NOT NULL PRIMARY KEY,
+    code VARCHAR(50) NOT NULL
+) ENGINE=InnoDB;
+
+INSERT INTO `board_code_rename` (entity_id, code)
+SELECT b1.entity_id, CONCAT(LEFT(b1.code, 10 - LENGTH(COUNT(*))), COUNT(*))
+FROM `board` b1
+JOIN `board` b2 ON b2.code = b1.code
+    AND (b2.created_at < b1.created_at OR (b2.created_at = b1.created_at AND b2.entity_id <= b1.entity_id))
+GROUP BY b1.entity_id, b1.code
+HAVING COUNT(*) > 1;
+
+-- a suffixed code some board already has, or that two boards got, is
+-- replaced by the first free B1, B2, ...
+UPDATE `board_code_rename` r
+JOIN (
+    SELECT code FROM `board`
+    UNION ALL
+    SELECT code FROM (SELECT code FROM `board_code_rename` GROUP BY code HAVING COUNT(*) > 1) dup
+) taken ON taken.code = r.code
+SET r.code = '';
+
+CREATE TABLE `board_code_digit`(
+    d INT NOT NULL PRIMARY KEY
+) ENGINE=InnoDB;
+
+INSERT INTO `board_code_digit` (d) VALUES (0), (1), (2), (3), (4), (5), (6), (7), (8), (9);
+
+SET @n := 0;
+CREATE TABLE `board_code_free` ENGINE=InnoDB AS
+SELECT (@n := @n + 1) AS n, f.code
+FROM (
+    SELECT CONCAT('B', 1 + d1.d + 10 * d2.d + 100 * d3.d + 1000 * d4.d) AS code
+    FROM `board_code_digit` d1
+    CROSS JOIN `board_code_digit` d2
+    CROSS JOIN `board_code_digit` d3
+    CROSS JOIN `board_code_digit` d4
+) f
+WHERE f.code NOT IN (SELECT code FROM `board`)
+    AND f.code NOT IN (SELECT code FROM `board_code_rename`);
+
+SET @n := 0;
+CREATE TABLE `board_code_fallback` ENGINE=InnoDB AS
+SELECT (@n := @n + 1) AS n, entity_id FROM `board_code_rename` WHERE code = '';
+
+UPDATE `board_code_rename` r
+JOIN `board_code_fallback` f ON f.entity_id = r.entity_id
+JOIN `board_code_free` c ON c.n = f.n
+SET r.code = c.code;
+
+UPDATE `board` b
+JOIN `board_code_rename` r ON r.entity_id = b.entity_id
+SET b.code = r.code;
+
+DROP TABLE `board_code_rename`, `board_code_digit`, `board_code_free`, `board_code_fallback`;
+
+ALTER TABLE `board` ADD UNIQUE KEY `board_code` (`code`);
+
+ALTER TABLE `card` ADD COLUMN card_key VARCHAR(60) NULL DEFAULT NULL AFTER public_id;
+
+-- numbers the cards of each board in the order they were created: the rows
+-- are updated in that order, left to right, and @n restarts on a new board
+SET @board_id := NULL, @n := 0;
+UPDATE `card`
+SET card_key = (@n := IF(@board_id <=> board_id, @n + 1, 1)),
+    board_id = (@board_id := board_id)
+ORDER BY board_id, created_at, entity_id;
+
+UPDATE `card` c
+JOIN `board` b ON b.entity_id = c.board_id
+SET c.card_key = CONCAT(b.code, '-', c.card_key);
+
+UPDATE `board` b SET b.card_seq = (SELECT COUNT(c.entity_id) FROM `card` c WHERE c.board_id = b.entity_id);
+
+ALTER TABLE `card` MODIFY COLUMN card_key VARCHAR(60) NOT NULL;
+ALTER TABLE `card` ADD UNIQUE KEY `card_card_key` (`card_key`);
+
+-- every key a card ever had, so links keep working after a card moves boards
+CREATE TABLE IF NOT EXISTS `card_key`(
+    card_key VARCHAR(60) NOT NULL PRIMARY KEY,
+    card_id CHAR(36) NOT NULL,
+    board_id CHAR(36) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    KEY `card_key_card_id` (`card_id`)
+) ENGINE=InnoDB;
+
+INSERT INTO `card_key` (card_key, card_id, board_id, created_at)
+SELECT card_key, entity_id, board_id, created_at FROM `card`;
@@ -0,0 +1 @@
+ALTER TABLE `card` ADD INDEX `card_public_id` (`public_id`);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS `card_watcher`(
+    entity_id CHAR(36) NOT NULL PRIMARY KEY,
+    card_id CHAR(36) NOT NULL,
+    user_id CHAR(36) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY `card_watcher_card_user` (`card_id`, `user_id`)
+) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS `board_watcher`(
+    entity_id CHAR(36) NOT NULL PRIMARY KEY,
+    board_id CHAR(36) NOT NULL,
+    list_id CHAR(36) NOT NULL DEFAULT '',
+    user_id CHAR(36) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY `board_watcher_board_list_user` (`board_id`, `list_id`, `user_id`),
+    FOREIGN KEY (`board_id`) REFERENCES board(`entity_id`)
+) ENGINE=InnoDB;
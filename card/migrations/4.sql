@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS `custom_field`(
+    entity_id CHAR(36) NOT NULL PRIMARY KEY,
+    board_id CHAR(36) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    position SMALLINT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (`board_id`) REFERENCES board(`entity_id`)
+) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS `custom_field_option`(
+    entity_id CHAR(36) NOT NULL PRIMARY KEY,
+    field_id CHAR(36) NOT NULL,
+    title VARCHAR(100) NOT NULL,
+    color VARCHAR(20) NOT NULL,
+    position SMALLINT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (`field_id`) REFERENCES custom_field(`entity_id`)
+) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS `card_custom_field_value`(
+    entity_id CHAR(36) NOT NULL PRIMARY KEY,
+    card_id CHAR(36) NOT NULL,
+    field_id CHAR(36) NOT NULL,
+    value_text TEXT NULL DEFAULT NULL,
+    value_number DOUBLE NULL DEFAULT NULL,
+    value_date TIMESTAMP NULL DEFAULT NULL,
+    value_checked TINYINT(1) NULL DEFAULT NULL,
+    option_ids VARCHAR(1000) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL,
+    UNIQUE KEY `card_custom_field_value_card_field` (`card_id`, `field_id`),
+    KEY `card_custom_field_value_field` (`field_id`)
+) ENGINE=InnoDB;
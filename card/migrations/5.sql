@@ -0,0 +1 @@
+ALTER TABLE `custom_field` ADD COLUMN formula VARCHAR(1000) NOT NULL DEFAULT '' AFTER position;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS `card_relation`(
+    entity_id CHAR(36) NOT NULL PRIMARY KEY,
+    card_id CHAR(36) NOT NULL,
+    related_card_id CHAR(36) NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY `card_relation_card_related_type` (`card_id`, `related_card_id`, `type`),
+    KEY `card_relation_related_card` (`related_card_id`)
+) ENGINE=InnoDB;
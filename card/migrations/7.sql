@@ -0,0 +1,36 @@
+CREATE TABLE IF NOT EXISTS `card_template`(
+    entity_id CHAR(36) NOT NULL PRIMARY KEY,
+    board_id CHAR(36) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    title_pattern VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL,
+    label_ids JSON NOT NULL,
+    member_ids JSON NOT NULL,
+    checklists JSON NOT NULL,
+    due_in_days INT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY `card_template_board_name` (`board_id`, `name`),
+    FOREIGN KEY (`board_id`) REFERENCES board(`entity_id`)
+) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS `card_checklist`(
+    entity_id CHAR(36) NOT NULL PRIMARY KEY,
+    card_id CHAR(36) NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    position INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    KEY `card_checklist_card` (`card_id`)
+) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS `card_checklist_item`(
+    entity_id CHAR(36) NOT NULL PRIMARY KEY,
+    checklist_id CHAR(36) NOT NULL,
+    card_id CHAR(36) NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    checked TINYINT(1) NOT NULL DEFAULT 0,
+    position INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    KEY `card_checklist_item_card` (`card_id`)
+) ENGINE=InnoDB;
@@ -0,0 +1,11 @@
+ALTER TABLE `board` ADD COLUMN is_template TINYINT(1) NOT NULL DEFAULT 0 AFTER title;
+
+CREATE TABLE IF NOT EXISTS `board_template`(
+    entity_id CHAR(36) NOT NULL PRIMARY KEY,
+    board_id CHAR(36) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    description TEXT NOT NULL,
+    created_by CHAR(36) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (`board_id`) REFERENCES board(`entity_id`)
+) ENGINE=InnoDB;
@@ -0,0 +1,3 @@
+ALTER TABLE `board_list` MODIFY COLUMN position DOUBLE NOT NULL;
+ALTER TABLE `board_list` ADD COLUMN archived_at TIMESTAMP NULL DEFAULT NULL AFTER updated_at;
+ALTER TABLE `board_list` ADD INDEX `board_list_public_id` (`public_id`);
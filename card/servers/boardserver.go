@@ -5,7 +5,6 @@ import (
 
 	"github.com/rakateja/milo/twirp-rpc-examples/card/domains/board"
 	pb "github.com/rakateja/milo/twirp-rpc-examples/card/proto/rpcproto"
-	"github.com/twitchtv/twirp"
 )
 
 type BoardServer struct {
@@ -19,15 +18,15 @@ func NewBoardServer(svc *board.Service) pb.BoardService {
 func (svc *BoardServer) CreateBoard(ctx context.Context, input *pb.BoardCreateInput) (*pb.Board, error) {
 	res, err := svc.boardSvc.Create(ctx, ToBoardInputFromCreateInputPb(input))
 	if err != nil {
-		return nil, twirp.NewError(twirp.Internal, err.Error())
+		return nil, ToTwirpError(err)
 	}
 	return ToBoardPb(*res), nil
 }
 
 func (svc *BoardServer) UpdateBoard(ctx context.Context, input *pb.BoardUpdateInput) (*pb.Board, error) {
-	res, err := svc.boardSvc.Update(ctx, input.Id, board.UpdateInput{Title: input.Title})
+	res, err := svc.boardSvc.Update(ctx, input.Id, board.UpdateInput{Title: input.Title}, input.UpdateMask.GetPaths())
 	if err != nil {
-		return nil, twirp.NewError(twirp.Internal, err.Error())
+		return nil, ToTwirpError(err)
 	}
 	return ToBoardPb(*res), nil
 }
@@ -41,17 +40,237 @@ func (svc *BoardServer) AddLabel(context.Context, *pb.AddLabelInput) (*pb.Board,
 }
 
 func (svc *BoardServer) GetByID(ctx context.Context, input *pb.GetByIDInput) (*pb.Board, error) {
-	res, err := svc.boardSvc.ResolveByID(ctx, input.Id)
+	projection, err := board.NewProjection(input.ReadMask.GetPaths())
 	if err != nil {
-		return nil, twirp.NewError(twirp.Internal, err.Error())
+		return nil, ToTwirpError(err)
+	}
+	res, err := svc.boardSvc.Resolve(ctx, input.Id, projection)
+	if err != nil {
+		return nil, ToTwirpError(err)
+	}
+	return ToBoardPb(*res), nil
+}
+
+func (svc *BoardServer) GetByKey(ctx context.Context, input *pb.GetByKeyInput) (*pb.Board, error) {
+	res, err := svc.boardSvc.ResolveByCode(ctx, input.Key)
+	if err != nil {
+		return nil, ToTwirpError(err)
 	}
 	return ToBoardPb(*res), nil
 }
 
+func (svc *BoardServer) BatchGetBoards(ctx context.Context, input *pb.BatchGetInput) (*pb.BoardBatchResult, error) {
+	res, err := svc.boardSvc.BatchResolve(ctx, input.Ids)
+	if err != nil {
+		return nil, ToTwirpError(err)
+	}
+	return ToBoardBatchResultPb(res), nil
+}
+
 func (svc *BoardServer) GetPage(ctx context.Context, input *pb.GetPageInput) (*pb.BoardPage, error) {
-	res, err := svc.boardSvc.ResolvePage(ctx, int(input.Page), int(input.Limit))
+	projection, err := board.NewProjection(input.ReadMask.GetPaths())
+	if err != nil {
+		return nil, ToTwirpError(err)
+	}
+	res, err := svc.boardSvc.ResolvePage(ctx, int(input.Page), int(input.Limit), projection)
 	if err != nil {
-		return nil, twirp.NewError(twirp.Internal, err.Error())
+		return nil, ToTwirpError(err)
 	}
 	return ToBoardPagePb(res), nil
 }
+
+func (svc *BoardServer) WatchBoard(ctx context.Context, input *pb.BoardWatchInput) (*pb.Board, error) {
+	res, err := svc.boardSvc.Watch(ctx, input.BoardId, board.WatcherInput{
+		UserID: input.UserId,
+		ListID: input.ListId,
+	})
+	if err != nil {
+		return nil, ToTwirpError(err)
+	}
+	return ToBoardPb(*res), nil
+}
+
+func (svc *BoardServer) UnwatchBoard(ctx context.Context, input *pb.BoardWatchInput) (*pb.Board, error) {
+	res, err := svc.boardSvc.Unwatch(ctx, input.BoardId, board.WatcherInput{
+		UserID: input.UserId,
+		ListID: input.ListId,
+	})
+	if err != nil {
+		return nil, ToTwirpError(err)
+	}
+	return ToBoardPb(*res), nil
+}
+
+func (svc *BoardServer) CreateCustomField(ctx context.Context, input *pb.CustomFieldInput) (*pb.Board, error) {
+	res, err := svc.boardSvc.CreateCustomField(ctx, input.BoardId, board.CustomFieldInput{
+		Name:    input.Name,
+		Type:    board.FieldType(input.Type),
+		Formula: input.Formula,
+		Options: ToCustomFieldOptionInputFromPb(input.Options),
+	})
+	if err != nil {
+		return nil, ToTwirpError(err)
+	}
+	return ToBoardPb(*res), nil
+}
+
+func (svc *BoardServer) UpdateCustomField(ctx context.Context, input *pb.CustomFieldUpdateInput) (*pb.Board, error) {
+	res, err := svc.boardSvc.UpdateCustomField(ctx, input.Id, board.CustomFieldUpdateInput{
+		Name:    input.Name,
+		Formula: input.Formula,
+		Options: ToCustomFieldOptionInputFromPb(input.Options),
+	})
+	if err != nil {
+		return nil, ToTwirpError(err)
+	}
+	return ToBoardPb(*res), nil
+}
+
+func (svc *BoardServer) ReorderCustomFields(ctx context.Context, input *pb.CustomFieldReorderInput) (*pb.Board, error) {
+	res, err := svc.boardSvc.ReorderCustomFields(ctx, input.BoardId, input.FieldIds)
+	if err != nil {
+		return nil, ToTwirpError(err)
+	}
+	return ToBoardPb(*res), nil
+}
+
+func (svc *BoardServer) DeleteCustomField(ctx context.Context, input *pb.CustomFieldDeleteInput) (*pb.Board, error) {
+	res, err := svc.boardSvc.DeleteCustomField(ctx, input.Id)
+	if err != nil {
+		return nil, ToTwirpError(err)
+	}
+	return ToBoardPb(*res), nil
+}
+
+func (svc *BoardServer) CloneBoard(ctx context.Context, input *pb.CloneBoardInput) (*pb.Board, error) {
+	res, err := svc.boardSvc.Clone(ctx, input.BoardId, board.CloneInput{
+		Title:        input.Title,
+		Code:         input.Code,
+		IncludeCards: input.IncludeCards,
+	})
+	if err != nil {
+		return nil, ToTwirpError(err)
+	}
+	return ToBoardPb(*res), nil
+}
+
+func (svc *BoardServer) PublishBoardTemplate(ctx context.Context, input *pb.BoardTemplateInput) (*pb.BoardTemplate, error) {
+	res, err := svc.boardSvc.PublishTemplate(ctx, board.TemplateInput{
+		BoardID:     input.BoardId,
+		Name:        input.Name,
+		Description: input.Description,
+		UserID:      input.UserId,
+	})
+	if err != nil {
+		return nil, ToTwirpError(err)
+	}
+	return ToBoardTemplatePb(*res), nil
+}
+
+func (svc *BoardServer) GetBoardTemplates(ctx context.Context, input *pb.BoardTemplateFilter) (*pb.BoardTemplateList, error) {
+	res, err := svc.boardSvc.ResolveTemplates(ctx)
+	if err != nil {
+		return nil, ToTwirpError(err)
+	}
+	var templates []*pb.BoardTemplate
+	for _, t := range res {
+		templates = append(templates, ToBoardTemplatePb(t))
+	}
+	return &pb.BoardTemplateList{Templates: templates}, nil
+}
+
+func (svc *BoardServer) CreateBoardFromTemplate(ctx context.Context, input *pb.BoardFromTemplateInput) (*pb.Board, error) {
+	res, err := svc.boardSvc.CreateFromTemplate(ctx, input.TemplateId, board.TemplateBoardInput{
+		Title:   input.Title,
+		Code:    input.Code,
+		Members: ToBoardMemberInputFromPb(input.Members),
+	})
+	if err != nil {
+		return nil, ToTwirpError(err)
+	}
+	return ToBoardPb(*res), nil
+}
+
+func (svc *BoardServer) AddList(ctx context.Context, input *pb.BoardListAddInput) (*pb.Board, error) {
+	res, err := svc.boardSvc.AddList(ctx, input.BoardId, board.ListInput{
+		Title:    input.Title,
+		Position: input.Position,
+	})
+	if err != nil {
+		return nil, ToTwirpError(err)
+	}
+	return ToBoardPb(*res), nil
+}
+
+func (svc *BoardServer) RenameList(ctx context.Context, input *pb.BoardListRenameInput) (*pb.Board, error) {
+	res, err := svc.boardSvc.RenameList(ctx, board.ListRenameInput{
+		ListID: input.ListId,
+		Title:  input.Title,
+	})
+	if err != nil {
+		return nil, ToTwirpError(err)
+	}
+	return ToBoardPb(*res), nil
+}
+
+func (svc *BoardServer) ReorderLists(ctx context.Context, input *pb.BoardListReorderInput) (*pb.Board, error) {
+	res, err := svc.boardSvc.ReorderList(ctx, board.ListReorderInput{
+		ListID:      input.ListId,
+		AfterListID: input.AfterListId,
+	})
+	if err != nil {
+		return nil, ToTwirpError(err)
+	}
+	return ToBoardPb(*res), nil
+}
+
+func (svc *BoardServer) ArchiveList(ctx context.Context, input *pb.BoardListArchiveInput) (*pb.Board, error) {
+	res, err := svc.boardSvc.ArchiveList(ctx, board.ListArchiveInput{
+		ListID:   input.ListId,
+		Archived: input.Archived,
+	})
+	if err != nil {
+		return nil, ToTwirpError(err)
+	}
+	return ToBoardPb(*res), nil
+}
+
+func (svc *BoardServer) MoveListToBoard(ctx context.Context, input *pb.BoardListMoveInput) (*pb.Board, error) {
+	res, err := svc.boardSvc.MoveListToBoard(ctx, board.ListMoveInput{
+		ListID:  input.ListId,
+		BoardID: input.BoardId,
+	})
+	if err != nil {
+		return nil, ToTwirpError(err)
+	}
+	return ToBoardPb(*res), nil
+}
+
+func (svc *BoardServer) DeleteList(ctx context.Context, input *pb.BoardListDeleteInput) (*pb.Board, error) {
+	res, err := svc.boardSvc.DeleteList(ctx, board.ListDeleteInput{
+		ListID:       input.ListId,
+		Policy:       board.ListCardPolicy(input.Policy),
+		TargetListID: input.TargetListId,
+	})
+	if err != nil {
+		return nil, ToTwirpError(err)
+	}
+	return ToBoardPb(*res), nil
+}
+
+func (svc *BoardServer) SetListWipLimit(ctx context.Context, input *pb.BoardListWipLimitInput) (*pb.Board, error) {
+	var wipLimit *int
+	if input.WipLimit != nil {
+		limit := int(*input.WipLimit)
+		wipLimit = &limit
+	}
+	res, err := svc.boardSvc.SetListWipLimit(ctx, board.ListWipLimitInput{
+		ListID:   input.ListId,
+		WipLimit: wipLimit,
+		Mode:     board.WipLimitMode(input.Mode),
+	})
+	if err != nil {
+		return nil, ToTwirpError(err)
+	}
+	return ToBoardPb(*res), nil
+}
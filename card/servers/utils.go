@@ -4,13 +4,17 @@ import (
 	"time"
 
 	timestampPb "github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/pkg/errors"
+	"github.com/rakateja/milo/twirp-rpc-examples/card/apierror"
 	"github.com/rakateja/milo/twirp-rpc-examples/card/domains/board"
 	pb "github.com/rakateja/milo/twirp-rpc-examples/card/proto/rpcproto"
+	"github.com/twitchtv/twirp"
 )
 
 func ToBoardInputFromCreateInputPb(pbInput *pb.BoardCreateInput) board.Input {
 	return board.Input{
 		Title:   pbInput.Title,
+		Code:    pbInput.Code,
 		Members: ToBoardMemberInputFromPb(pbInput.Members),
 		Lists:   ToBoardListInputFromPb(pbInput.Lists),
 		Labels:  ToBoardLabelInputFromPb(pbInput.Labels),
@@ -43,12 +47,57 @@ func ToBoardListInputFromPb(ls []*pb.AddListInput) []board.ListInput {
 	for _, inputPb := range ls {
 		res = append(res, board.ListInput{
 			Title:    inputPb.Name,
-			Position: int(inputPb.Position),
+			Position: float64(inputPb.Position),
 		})
 	}
 	return res
 }
 
+func ToCustomFieldOptionInputFromPb(ls []*pb.CustomFieldOptionInput) []board.CustomFieldOptionInput {
+	res := make([]board.CustomFieldOptionInput, 0)
+	for _, inputPb := range ls {
+		res = append(res, board.CustomFieldOptionInput{
+			ID:    inputPb.Id,
+			Title: inputPb.Title,
+			Color: inputPb.Color,
+		})
+	}
+	return res
+}
+
+// ToTwirpError maps domain errors to twirp error codes so clients can tell
+// invalid input apart from server failures.
+func ToTwirpError(err error) error {
+	if apiErr, ok := errors.Cause(err).(apierror.APIError); ok {
+		switch apiErr.Code {
+		case board.ErrorCodeInvalidInput:
+			return twirp.NewError(twirp.InvalidArgument, apiErr.Error())
+		case board.ErrorCodeEntityNotFound:
+			return twirp.NewError(twirp.NotFound, apiErr.Error())
+		case board.ErrorCodeAlreadyExist:
+			return twirp.NewError(twirp.AlreadyExists, apiErr.Error())
+		case board.ErrorCodeWipLimitExceeded:
+			return twirp.NewError(twirp.FailedPrecondition, apiErr.Error())
+		}
+	}
+	return twirp.NewError(twirp.Internal, err.Error())
+}
+
+func ToBoardBatchResultPb(ls []board.BatchResult) *pb.BoardBatchResult {
+	res := &pb.BoardBatchResult{}
+	for _, r := range ls {
+		item := &pb.BoardBatchItem{Id: r.ID}
+		if r.Err != nil {
+			twerr := ToTwirpError(r.Err).(twirp.Error)
+			item.Error = &pb.BatchError{Code: string(twerr.Code()), Msg: twerr.Msg()}
+		} else {
+			item.Board = ToBoardPb(*r.Board)
+		}
+		res.Items = append(res.Items, item)
+	}
+	return res
+}
+
 func ToBoardInputFromUpdateInputPb(pbInput *pb.BoardUpdateInput) board.Input {
 	return board.Input{
 		Title: pbInput.Title,
@@ -68,13 +117,16 @@ func ToBoardPagePb(boardPage board.Page[board.Board]) *pb.BoardPage {
 
 func ToBoardPb(entity board.Board) *pb.Board {
 	return &pb.Board{
-		Id:        entity.ID,
-		Title:     entity.Title,
-		Members:   ToBoardMembersPb(entity.Members),
-		Lists:     ToBoardListsPb(entity.Lists),
-		Labels:    ToBoardLabelsPb(entity.Labels),
-		CreatedAt: ToTimestampPb(&entity.CreatedAt),
-		UpdatedAt: ToTimestampPb(&entity.UpdatedAt),
+		Id:           entity.ID,
+		Code:         entity.Code,
+		Title:        entity.Title,
+		Members:      ToBoardMembersPb(entity.Members),
+		Lists:        ToBoardListsPb(entity.Lists),
+		Labels:       ToBoardLabelsPb(entity.Labels),
+		Watchers:     ToBoardWatchersPb(entity.Watchers),
+		CustomFields: ToBoardCustomFieldsPb(entity.CustomFields),
+		CreatedAt:    ToTimestampPb(&entity.CreatedAt),
+		UpdatedAt:    ToTimestampPb(&entity.UpdatedAt),
 	}
 }
 
@@ -105,11 +157,64 @@ func ToBoardListsPb(ls []board.BoardList) []*pb.BoardList {
 	res := make([]*pb.BoardList, 0)
 	for _, entity := range ls {
 		res = append(res, &pb.BoardList{
+			Id:           entity.ID,
+			BoardId:      entity.BoardID,
+			PublicId:     entity.PublicID,
+			Title:        entity.Title,
+			Position:     entity.Position,
+			CreatedAt:    ToTimestampPb(&entity.CreatedAt),
+			UpdatedAt:    ToTimestampPb(&entity.UpdatedAt),
+			ArchivedAt:   ToTimestampPb(entity.ArchivedAt),
+			WipLimit:     ToInt32Pb(entity.WipLimit),
+			WipLimitMode: string(entity.WipLimitMode),
+			CardCount:    int32(entity.CardCount),
+		})
+	}
+	return res
+}
+
+func ToInt32Pb(v *int) *int32 {
+	if v == nil {
+		return nil
+	}
+	res := int32(*v)
+	return &res
+}
+
+func ToBoardWatchersPb(ls []board.Watcher) []*pb.BoardWatcher {
+	res := make([]*pb.BoardWatcher, 0)
+	for _, entity := range ls {
+		res = append(res, &pb.BoardWatcher{
 			Id:        entity.ID,
 			BoardId:   entity.BoardID,
-			PublicId:  entity.PublicID,
-			Title:     entity.Title,
+			ListId:    entity.ListID,
+			UserId:    entity.UserID,
+			CreatedAt: ToTimestampPb(&entity.CreatedAt),
+		})
+	}
+	return res
+}
+
+func ToBoardCustomFieldsPb(ls []board.CustomField) []*pb.BoardCustomField {
+	res := make([]*pb.BoardCustomField, 0)
+	for _, entity := range ls {
+		options := make([]*pb.BoardCustomFieldOption, 0)
+		for _, o := range entity.Options {
+			options = append(options, &pb.BoardCustomFieldOption{
+				Id:       o.ID,
+				Title:    o.Title,
+				Color:    o.Color,
+				Position: int32(o.Position),
+			})
+		}
+		res = append(res, &pb.BoardCustomField{
+			Id:        entity.ID,
+			BoardId:   entity.BoardID,
+			Name:      entity.Name,
+			Type:      string(entity.Type),
 			Position:  int32(entity.Position),
+			Options:   options,
+			Formula:   entity.Formula,
 			CreatedAt: ToTimestampPb(&entity.CreatedAt),
 			UpdatedAt: ToTimestampPb(&entity.UpdatedAt),
 		})
@@ -132,3 +237,14 @@ func ToBoardLabelsPb(ls []board.Label) []*pb.BoardLabel {
 	}
 	return res
 }
+
+func ToBoardTemplatePb(t board.Template) *pb.BoardTemplate {
+	return &pb.BoardTemplate{
+		Id:          t.ID,
+		BoardId:     t.BoardID,
+		Name:        t.Name,
+		Description: t.Description,
+		CreatedBy:   t.CreatedBy,
+		CreatedAt:   ToTimestampPb(&t.CreatedAt),
+	}
+}